@@ -0,0 +1,110 @@
+// Package alert raises alarms from the reading stream: glucose too high or
+// too low, changing too fast, or readings going missing
+package alert
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Kind is what an alert is about
+type Kind string
+
+// Alert kinds
+const (
+	High      Kind = "high"
+	Low       Kind = "low"
+	UrgentLow Kind = "urgent-low"
+	Rising    Kind = "rising"
+	Falling   Kind = "falling"
+	Missed    Kind = "missed"
+	// PredictedLow is glucose headed below a threshold
+	PredictedLow Kind = "predicted-low"
+)
+
+// Severity is how much an alert matters
+type Severity string
+
+// Severities
+const (
+	Warning Severity = "warning"
+	Urgent  Severity = "urgent"
+)
+
+// State is where an alert is in its life
+type State string
+
+// Alert states
+const (
+	// Firing is an alert's first notification
+	Firing State = "firing"
+	// Repeat is a reminder that an alert is still firing
+	Repeat State = "repeat"
+	// Cleared is an alert no longer firing
+	Cleared State = "cleared"
+	// Escalated is an urgent alert nobody's acknowledged, going further
+	Escalated State = "escalated"
+)
+
+// Alert is a notification about one alert on one sensor
+type Alert struct {
+	ID          string    `json:"id"`
+	Kind        Kind      `json:"kind"`
+	Severity    Severity  `json:"severity"`
+	State       State     `json:"state"`
+	Serial      string    `json:"serial"`
+	Transmitter string    `json:"xmit,omitempty"`
+	Start       time.Time `json:"start"`
+	Time        time.Time `json:"time"`
+	Glucose     float64   `json:"glucose,omitempty"`
+	Rate        *float64  `json:"rate,omitempty"`
+	Message     string    `json:"message"`
+	// Escalation is how far up the escalation chain it's gone
+	Escalation int `json:"escalation,omitempty"`
+}
+
+func (alert Alert) String() string {
+	return fmt.Sprintf("%v %v %v: %v", alert.Severity, alert.State, alert.Serial, alert.Message)
+}
+
+// Notifier tells someone about alerts
+type Notifier interface {
+	Notify(Alert) error
+}
+
+// NotifierFunc makes a Notifier of a function
+type NotifierFunc func(Alert) error
+
+// Notify calls the function
+func (fn NotifierFunc) Notify(alert Alert) error {
+	return fn(alert)
+}
+
+// named is a notifier with a name to route to it by
+type named struct {
+	Notifier
+	name string
+}
+
+// Named names a notifier, so profiles and escalations can pick it
+func Named(name string, notifier Notifier) Notifier {
+	return named{notifier, name}
+}
+
+// nameOf is a notifier's name, if it has one
+func nameOf(notifier Notifier) string {
+	if n, ok := notifier.(named); ok {
+		return n.name
+	}
+	return ""
+}
+
+// LogNotifier logs alerts
+type LogNotifier struct{}
+
+// Notify logs an alert
+func (LogNotifier) Notify(alert Alert) error {
+	log.Printf("alert: %v", alert)
+	return nil
+}
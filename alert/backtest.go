@@ -0,0 +1,187 @@
+package alert
+
+import (
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"math"
+	"sort"
+	"time"
+)
+
+// backtestSlack is how far off a reading can be from when a forecast was
+// for and still count as what actually happened
+const backtestSlack = 2 * time.Minute
+
+// BacktestResult is how a predicted low rule would have done on history
+type BacktestResult struct {
+	// Forecasts is how many forecasts had a reading to check against
+	Forecasts int
+	// Error is the mean absolute error of those, and Bias their mean
+	// (forecast less actual), both in mg/dL
+	Error float64
+	Bias  float64
+	// Covered is how many actual readings were inside the interval
+	Covered int
+	// Alerts is how many predicted lows would have fired, and Hits how many
+	// of those a low followed, while it was on or within the rule's ahead
+	Alerts int
+	Hits   int
+	// Lows is how many times glucose went under the threshold, Caught how
+	// many a predicted low fired ahead of, and Lead how far ahead on average
+	Lows   int
+	Caught int
+	Lead   time.Duration
+
+	confidence float64
+}
+
+func (result BacktestResult) String() string {
+	coverage := 0.0
+	if result.Forecasts > 0 {
+		coverage = 100 * float64(result.Covered) / float64(result.Forecasts)
+	}
+	return fmt.Sprintf("%v forecasts: mean error %.1f mg/dL, bias %+.1f mg/dL, %.0f%% inside the %.0f%% interval; "+
+		"%v predicted lows, %v followed by one; %v lows, %v predicted, %v ahead on average",
+		result.Forecasts, result.Error, result.Bias, coverage, 100*result.confidence,
+		result.Alerts, result.Hits, result.Lows, result.Caught, result.Lead.Truncate(time.Second))
+}
+
+// nearest is the reading closest to a time, within backtestSlack;
+// readings are oldest first
+func nearest(readings []miao2go.GlucoseReading, when time.Time) (miao2go.GlucoseReading, bool) {
+	idx := sort.Search(len(readings), func(i int) bool {
+		return !readings[i].Time.Before(when)
+	})
+	var best miao2go.GlucoseReading
+	found := false
+	for _, candidate := range []int{idx - 1, idx} {
+		if candidate < 0 || candidate >= len(readings) {
+			continue
+		}
+		off := absDuration(readings[candidate].Time.Sub(when))
+		if off <= backtestSlack && (!found || off < absDuration(best.Time.Sub(when))) {
+			best, found = readings[candidate], true
+		}
+	}
+	return best, found
+}
+
+// episode is when a predicted low was on
+type episode struct {
+	start, end time.Time
+}
+
+// covers is whether a low starting at a time followed an episode
+func (ep episode) covers(low time.Time, ahead time.Duration) bool {
+	end := ep.start.Add(ahead)
+	if ep.end.After(end) {
+		end = ep.end
+	}
+	return low.After(ep.start) && !low.After(end.Add(backtestSlack))
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Backtest replays stored readings through a predicted low rule, a minute
+// at a time as if they were arriving live, and checks its forecasts and
+// alerts against what actually happened next.  Readings from several
+// sensors are replayed separately
+func Backtest(readings []miao2go.GlucoseReading, rule Rule) BacktestResult {
+	result := BacktestResult{confidence: rule.Confidence}
+	bySerial := make(map[string][]miao2go.GlucoseReading)
+	for _, reading := range readings {
+		if reading.Raw > 0 {
+			bySerial[reading.Serial] = append(bySerial[reading.Serial], reading)
+		}
+	}
+	var errors, biases float64
+	var leads time.Duration
+	for _, series := range bySerial {
+		sort.SliceStable(series, func(i, j int) bool {
+			return series[i].Time.Before(series[j].Time)
+		})
+		var alerts []episode
+		var lows []time.Time
+		firing, low := false, false
+		start := 0
+		var last time.Time
+		for idx, reading := range series {
+			now := reading.Time
+			if now.Truncate(time.Minute).Equal(last) {
+				continue
+			}
+			last = now.Truncate(time.Minute)
+			switch {
+			case !low && reading.Glucose <= rule.Threshold:
+				low = true
+				lows = append(lows, now)
+			case low && reading.Glucose > rule.Threshold+rule.Hysteresis:
+				low = false
+			}
+			for now.Sub(series[start].Time) > rule.Lookback {
+				start++
+			}
+			forecast, ok := Predict(series[start:idx+1], now, rule.Lookback, rule.Ahead)
+			if !ok {
+				continue
+			}
+			if actual, ok := nearest(series, forecast.Time); ok {
+				result.Forecasts++
+				errors += math.Abs(forecast.Glucose - actual.Glucose)
+				biases += forecast.Glucose - actual.Glucose
+				if lower, upper := forecast.Interval(rule.Confidence); actual.Glucose >= lower && actual.Glucose <= upper {
+					result.Covered++
+				}
+			}
+			// as in the engine, it's held while it's already low
+			bound := forecast.Bound(rule.Confidence)
+			switch {
+			case !firing && bound <= rule.Threshold:
+				firing = true
+				if !low {
+					alerts = append(alerts, episode{start: now})
+				}
+			case firing && bound > rule.Threshold+rule.Hysteresis:
+				firing = false
+				if len(alerts) > 0 && alerts[len(alerts)-1].end.IsZero() {
+					alerts[len(alerts)-1].end = now
+				}
+			}
+		}
+		if len(alerts) > 0 && alerts[len(alerts)-1].end.IsZero() {
+			alerts[len(alerts)-1].end = last
+		}
+		result.Alerts += len(alerts)
+		result.Lows += len(lows)
+		for _, alert := range alerts {
+			for _, low := range lows {
+				if alert.covers(low, rule.Ahead) {
+					result.Hits++
+					break
+				}
+			}
+		}
+		for _, low := range lows {
+			for _, alert := range alerts {
+				if alert.covers(low, rule.Ahead) {
+					result.Caught++
+					leads += low.Sub(alert.start)
+					break
+				}
+			}
+		}
+	}
+	if result.Forecasts > 0 {
+		result.Error = errors / float64(result.Forecasts)
+		result.Bias = biases / float64(result.Forecasts)
+	}
+	if result.Caught > 0 {
+		result.Lead = leads / time.Duration(result.Caught)
+	}
+	return result
+}
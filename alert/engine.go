@@ -0,0 +1,518 @@
+package alert
+
+import (
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Backlog is how many notifications can wait for a slow notifier before
+// more for it are dropped
+var Backlog = 64
+
+// checkEvery is how often the engine looks for missed readings
+const checkEvery = time.Minute
+
+// forgetAfter is how long a sensor can go quiet before the engine stops
+// waiting for it, clearing its missed readings alert
+const forgetAfter = 24 * time.Hour
+
+// key is one alert on one sensor
+type key struct {
+	kind   Kind
+	serial string
+}
+
+// firing is an alert that's currently on
+type firing struct {
+	alert    Alert
+	notified time.Time
+	acked    bool
+}
+
+// outbox is a notifier and the alerts waiting for it; each has its own, so
+// one that's slow or hanging doesn't hold up the others
+type outbox struct {
+	notifier Notifier
+	alerts   chan Alert
+}
+
+// seen is the last reading from a sensor
+type seen struct {
+	time        time.Time
+	transmitter string
+}
+
+// Engine follows the reading stream, raising alerts as the rules say.  It's
+// a miao2go.Sink, so it can go anywhere a sink can
+type Engine struct {
+	config   *Config
+	outboxes []outbox
+	location *time.Location
+	now      func() time.Time
+
+	mu      sync.Mutex
+	firing  map[key]*firing
+	snoozed map[key]time.Time
+	last    map[string]seen
+
+	stop     chan struct{}
+	done     sync.WaitGroup
+	closed   bool
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewEngine starts an engine following config's rules and telling notifiers;
+// profiles and escalations pick notifiers by the names given them by Named
+func NewEngine(config *Config, notifiers ...Notifier) *Engine {
+	location, err := config.location()
+	if err != nil {
+		log.Printf("bad alert timezone, using local time: %v", err)
+		location = time.Local
+	}
+	engine := &Engine{
+		config:   config,
+		location: location,
+		now:      time.Now,
+		firing:   make(map[key]*firing),
+		snoozed:  make(map[key]time.Time),
+		last:     make(map[string]seen),
+		stop:     make(chan struct{}),
+	}
+	for _, notifier := range notifiers {
+		ob := outbox{notifier, make(chan Alert, Backlog)}
+		engine.outboxes = append(engine.outboxes, ob)
+		engine.done.Add(1)
+		go engine.deliver(ob)
+	}
+	engine.done.Add(1)
+	go engine.watch()
+	return engine
+}
+
+// deliver hands alerts to one notifier, in order
+func (engine *Engine) deliver(ob outbox) {
+	defer engine.done.Done()
+	for alert := range ob.alerts {
+		if err := ob.notifier.Notify(alert); err != nil {
+			log.Printf("couldn't notify %v%v: %v", alert.ID, label(ob.notifier), err)
+		}
+	}
+}
+
+// label is how a notifier is told apart in logs
+func label(notifier Notifier) string {
+	if name := nameOf(notifier); len(name) > 0 {
+		return " by " + name
+	}
+	return ""
+}
+
+// routed is whether a notifier is one of those named; no names is everyone
+func routed(to []string, name string) bool {
+	if len(to) == 0 {
+		return true
+	}
+	for _, want := range to {
+		if want == name {
+			return true
+		}
+	}
+	return false
+}
+
+// watch looks for missed readings until the engine is closed
+func (engine *Engine) watch() {
+	defer engine.done.Done()
+	ticker := time.NewTicker(checkEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			engine.Check(engine.now())
+		case <-engine.stop:
+			return
+		}
+	}
+}
+
+// at is the schedule in force at a time
+func (engine *Engine) at(when time.Time) schedule {
+	return engine.config.at(when, engine.location)
+}
+
+// snoozing is whether an alert is snoozed.  Called with mu held
+func (engine *Engine) snoozing(k key, now time.Time) bool {
+	until, ok := engine.snoozed[k]
+	if ok && !now.Before(until) {
+		delete(engine.snoozed, k)
+		return false
+	}
+	return ok
+}
+
+// notify queues an alert for whoever the schedule says, unless it's
+// snoozed or quiet.  Called with mu held
+func (engine *Engine) notify(at schedule, k key, f *firing, state State, now time.Time) {
+	if engine.snoozing(k, now) || at.quiet(k.kind) {
+		return
+	}
+	alert := f.alert
+	alert.State = state
+	f.notified = now
+	engine.send(alert, at.notify)
+}
+
+// send queues an alert for notifiers, unless the engine is closed.  Called
+// with mu held
+func (engine *Engine) send(alert Alert, to []string) {
+	if engine.closed {
+		return
+	}
+	for _, ob := range engine.outboxes {
+		if !routed(to, nameOf(ob.notifier)) {
+			continue
+		}
+		select {
+		case ob.alerts <- alert:
+		default:
+			log.Printf("notifier is behind, dropped %v%v", alert.ID, label(ob.notifier))
+		}
+	}
+}
+
+// evaluate moves one alert on: firing it when on, clearing it when off,
+// and repeating it as the schedule's rule says in between.  A held alert is
+// tracked but not notified, for low while urgent low is on.  Called with
+// mu held
+func (engine *Engine) evaluate(at schedule, k key, on, off, held bool, alert Alert) {
+	rule := *at.rules()[k.kind]
+	f := engine.firing[k]
+	switch {
+	case f == nil && on:
+		alert.Start = alert.Time
+		alert.ID = fmt.Sprintf("%v/%v/%v", k.kind, k.serial, alert.Start.Unix())
+		f = &firing{alert: alert}
+		engine.firing[k] = f
+		if !held {
+			engine.notify(at, k, f, Firing, alert.Time)
+		}
+	case f != nil && off:
+		delete(engine.firing, k)
+		alert.ID, alert.Start, alert.Escalation = f.alert.ID, f.alert.Start, f.alert.Escalation
+		f.alert = alert
+		if !f.notified.IsZero() {
+			engine.notify(at, k, f, Cleared, alert.Time)
+		}
+	case f != nil:
+		alert.ID, alert.Start, alert.Escalation = f.alert.ID, f.alert.Start, f.alert.Escalation
+		f.alert = alert
+		if held {
+			return
+		}
+		if f.notified.IsZero() {
+			engine.notify(at, k, f, Firing, alert.Time)
+		} else if rule.Realert > 0 && alert.Time.Sub(f.notified) >= rule.Realert {
+			engine.notify(at, k, f, Repeat, alert.Time)
+		}
+	}
+}
+
+// WriteReading evaluates the latest reading of a packet, by the schedule
+// in force when it was taken
+func (engine *Engine) WriteReading(pkt miao2go.MiaoMiaoPacket) error {
+	reading, ok := pkt.Latest()
+	if !ok {
+		return nil
+	}
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	serial, glucose := reading.Serial, reading.Glucose
+	if len(serial) == 0 {
+		serial = pkt.SerialNumber
+	}
+	if last, ok := engine.last[serial]; !ok || reading.Time.After(last.time) {
+		engine.last[serial] = seen{reading.Time, pkt.Transmitter}
+	}
+	// a transmitter only reads one sensor; whatever it read before is gone
+	if len(pkt.Transmitter) > 0 {
+		for other, last := range engine.last {
+			if other != serial && last.transmitter == pkt.Transmitter {
+				engine.retire(other, reading.Time, fmt.Sprintf("sensor replaced by %v", serial))
+			}
+		}
+	}
+	base := Alert{Serial: serial, Transmitter: pkt.Transmitter, Time: reading.Time, Glucose: glucose}
+	with := func(kind Kind, severity Severity, format string, args ...interface{}) Alert {
+		alert := base
+		alert.Kind, alert.Severity, alert.Message = kind, severity, fmt.Sprintf(format, args...)
+		return alert
+	}
+	at := engine.at(reading.Time)
+
+	engine.evaluate(at, key{Missed, serial}, false, true, false, with(Missed, Warning, "readings are back"))
+
+	rule := at.UrgentLow
+	engine.evaluate(at, key{UrgentLow, serial},
+		rule.Threshold > 0 && glucose <= rule.Threshold,
+		rule.Threshold == 0 || glucose > rule.Threshold+rule.Hysteresis, false,
+		with(UrgentLow, Urgent, "urgent low: %.0f mg/dL", glucose))
+	urgent := engine.firing[key{UrgentLow, serial}] != nil
+
+	rule = at.Low
+	engine.evaluate(at, key{Low, serial},
+		rule.Threshold > 0 && glucose <= rule.Threshold,
+		rule.Threshold == 0 || glucose > rule.Threshold+rule.Hysteresis, urgent,
+		with(Low, Warning, "low: %.0f mg/dL", glucose))
+
+	rule = at.High
+	engine.evaluate(at, key{High, serial},
+		rule.Threshold > 0 && glucose >= rule.Threshold,
+		rule.Threshold == 0 || glucose < rule.Threshold-rule.Hysteresis, false,
+		with(High, Warning, "high: %.0f mg/dL", glucose))
+
+	// a predicted low is held while it's already low; it's no news then.
+	// Without a forecast (or a rate, below) there's nothing to say it's
+	// still on, so one that's firing clears
+	rule = at.Predicted
+	if forecast, ok := Predict(pkt.Readings(), reading.Time, rule.Lookback, rule.Ahead); ok && rule.Threshold > 0 {
+		bound := forecast.Bound(rule.Confidence)
+		lower, upper := forecast.Interval(rule.Confidence)
+		engine.evaluate(at, key{PredictedLow, serial},
+			bound <= rule.Threshold,
+			bound > rule.Threshold+rule.Hysteresis,
+			urgent || engine.firing[key{Low, serial}] != nil,
+			with(PredictedLow, Warning, "predicted low: %.0f mg/dL (%.0f to %.0f) in %v, now %.0f mg/dL",
+				forecast.Glucose, lower, upper, rule.Ahead, glucose))
+	} else {
+		engine.evaluate(at, key{PredictedLow, serial}, false, true, false,
+			with(PredictedLow, Warning, "no longer predicting a low, now %.0f mg/dL", glucose))
+	}
+
+	rate, ok := pkt.TrendRate()
+	if !ok {
+		engine.evaluate(at, key{Rising, serial}, false, true, false,
+			with(Rising, Warning, "no trend rate, now %.0f mg/dL", glucose))
+		engine.evaluate(at, key{Falling, serial}, false, true, false,
+			with(Falling, Warning, "no trend rate, now %.0f mg/dL", glucose))
+		return nil
+	}
+	base.Rate = &rate
+	rule = at.Rising
+	engine.evaluate(at, key{Rising, serial},
+		rule.Threshold > 0 && rate >= rule.Threshold,
+		rule.Threshold == 0 || rate < rule.Threshold-rule.Hysteresis, false,
+		with(Rising, Warning, "rising fast: %+.1f mg/dL/min at %.0f mg/dL", rate, glucose))
+	rule = at.Falling
+	engine.evaluate(at, key{Falling, serial},
+		rule.Threshold > 0 && rate <= -rule.Threshold,
+		rule.Threshold == 0 || rate > -rule.Threshold+rule.Hysteresis, false,
+		with(Falling, Warning, "falling fast: %+.1f mg/dL/min at %.0f mg/dL", rate, glucose))
+	return nil
+}
+
+// Check raises missed reading alerts for sensors quiet since before now,
+// and escalates urgent alerts nobody's acknowledged; the engine does this
+// itself every minute
+func (engine *Engine) Check(now time.Time) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	at := engine.at(now)
+	for serial, last := range engine.last {
+		if quiet := now.Sub(last.time); quiet >= forgetAfter {
+			engine.retire(serial, now, fmt.Sprintf("no readings for %v, no longer waiting for them", quiet.Truncate(time.Minute)))
+		}
+	}
+	if rule := at.Missed; rule.After > 0 {
+		for serial, last := range engine.last {
+			quiet := now.Sub(last.time)
+			if quiet < rule.After {
+				continue
+			}
+			engine.evaluate(at, key{Missed, serial}, true, false, false, Alert{
+				Kind:        Missed,
+				Severity:    Warning,
+				Serial:      serial,
+				Transmitter: last.transmitter,
+				Time:        now,
+				Message:     fmt.Sprintf("no readings for %v", quiet.Truncate(time.Minute)),
+			})
+		}
+	}
+	engine.escalate(now)
+}
+
+// retire forgets a sensor, clearing anything firing for it.  Called with
+// mu held
+func (engine *Engine) retire(serial string, now time.Time, why string) {
+	delete(engine.last, serial)
+	at := engine.at(now)
+	for k, f := range engine.firing {
+		if k.serial != serial {
+			continue
+		}
+		delete(engine.firing, k)
+		f.alert.Time, f.alert.Message = now, why
+		if !f.notified.IsZero() {
+			engine.notify(at, k, f, Cleared, now)
+		}
+		delete(engine.snoozed, k)
+	}
+}
+
+// escalate tells the next in the chain about urgent alerts that have gone
+// unacknowledged long enough.  A snooze counts as acknowledging.  Called
+// with mu held
+func (engine *Engine) escalate(now time.Time) {
+	for k, f := range engine.firing {
+		if f.alert.Severity != Urgent || f.acked || f.notified.IsZero() || engine.snoozing(k, now) {
+			continue
+		}
+		for f.alert.Escalation < len(engine.config.Escalate) {
+			step := engine.config.Escalate[f.alert.Escalation]
+			if now.Sub(f.alert.Start) < step.After {
+				break
+			}
+			f.alert.Escalation++
+			alert := f.alert
+			alert.State = Escalated
+			alert.Time = now
+			engine.send(alert, step.Notify)
+		}
+	}
+}
+
+// Snooze quiets an alert on a sensor for a while, or as long as its rule
+// says if duration is zero.  It keeps firing, and clears, as usual.  It's
+// false if the engine's never heard of the sensor
+func (engine *Engine) Snooze(kind Kind, serial string, duration time.Duration) bool {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if _, ok := engine.last[serial]; !ok {
+		return false
+	}
+	now := engine.now()
+	if duration <= 0 {
+		at := engine.at(now)
+		if rule, ok := at.rules()[kind]; ok {
+			duration = rule.Snooze
+		}
+	}
+	engine.snoozed[key{kind, serial}] = now.Add(duration)
+	return true
+}
+
+// Ack acknowledges a firing alert by ID: it goes no further up the
+// escalation chain, and is snoozed as its rule says.  It's false if no
+// such alert is firing
+func (engine *Engine) Ack(id string) bool {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	now := engine.now()
+	for k, f := range engine.firing {
+		if f.alert.ID != id {
+			continue
+		}
+		f.acked = true
+		at := engine.at(now)
+		engine.snoozed[k] = now.Add(at.rules()[k.kind].Snooze)
+		return true
+	}
+	return false
+}
+
+// Firing is every alert that's currently on
+func (engine *Engine) Firing() []Alert {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	var alerts []Alert
+	for _, f := range engine.firing {
+		alert := f.alert
+		alert.State = Firing
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+// Memory is what an engine has learned beyond its config: what's firing,
+// snoozed and acknowledged, and when each sensor was last heard from
+type Memory struct {
+	firing  map[key]firing
+	snoozed map[key]time.Time
+	last    map[string]seen
+}
+
+// Memory copies what the engine has learned, for another to Remember.  It
+// can be taken after the engine is closed
+func (engine *Engine) Memory() Memory {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	memory := Memory{make(map[key]firing), make(map[key]time.Time), make(map[string]seen)}
+	for k, f := range engine.firing {
+		memory.firing[k] = *f
+	}
+	for k, until := range engine.snoozed {
+		memory.snoozed[k] = until
+	}
+	for serial, last := range engine.last {
+		memory.last[serial] = last
+	}
+	return memory
+}
+
+// Remember takes on what another engine learned, so that a reloaded config
+// carries on where the last left off: alerts already firing keep their IDs,
+// acknowledgements, snoozes and escalations rather than being raised anew
+func (engine *Engine) Remember(memory Memory) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	for k, f := range memory.firing {
+		f := f
+		engine.firing[k] = &f
+	}
+	for k, until := range memory.snoozed {
+		engine.snoozed[k] = until
+	}
+	for serial, last := range memory.last {
+		engine.last[serial] = last
+	}
+}
+
+// WriteEvent does nothing; only readings raise alerts
+func (engine *Engine) WriteEvent(event miao2go.Event) error {
+	return nil
+}
+
+// Flush does nothing; alerts go out as they're raised
+func (engine *Engine) Flush() error {
+	return nil
+}
+
+// Close stops looking for missed readings and taking acknowledgements, and
+// returns once the notifiers have been told everything already raised
+func (engine *Engine) Close() error {
+	engine.mu.Lock()
+	if engine.closed {
+		engine.mu.Unlock()
+		return nil
+	}
+	engine.closed = true
+	close(engine.stop)
+	for _, ob := range engine.outboxes {
+		close(ob.alerts)
+	}
+	server, listener := engine.server, engine.listener
+	engine.mu.Unlock()
+	if server != nil {
+		server.Close()
+		// Serve may not have started, and taken the listener with it
+		listener.Close()
+	}
+	engine.done.Wait()
+	return nil
+}
@@ -0,0 +1,343 @@
+package alert
+
+import (
+	"github.com/thecubic/miao2go"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder keeps every alert it's told about
+type recorder struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (rec *recorder) Notify(alert Alert) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.alerts = append(rec.alerts, alert)
+	return nil
+}
+
+// told is what the recorder's been told, as kind/state/serial
+func (rec *recorder) told() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	var told []string
+	for _, alert := range rec.alerts {
+		told = append(told, string(alert.Kind)+"/"+string(alert.State)+"/"+alert.Serial)
+	}
+	return told
+}
+
+// reading is a packet whose latest reading is glucose at when
+func reading(serial, transmitter string, when time.Time, glucose float64) miao2go.MiaoMiaoPacket {
+	return miao2go.MiaoMiaoPacket{
+		SerialNumber: serial,
+		Transmitter:  transmitter,
+		EndTime:      when,
+		Processed:    []miao2go.GlucoseReading{{Serial: serial, Kind: miao2go.TrendReading, Time: when, Glucose: glucose, Raw: 1000}},
+	}
+}
+
+// trending is a packet whose trend buffer goes at rate mg/dL per minute,
+// up to glucose at when
+func trending(serial string, when time.Time, glucose, rate float64) miao2go.MiaoMiaoPacket {
+	pkt := reading(serial, "", when, glucose)
+	for idx := 1; idx < 10; idx++ {
+		pkt.Processed = append(pkt.Processed, miao2go.GlucoseReading{
+			Serial: serial, Kind: miao2go.TrendReading, Index: idx, Raw: 1000,
+			Time: when.Add(-time.Duration(idx) * time.Minute), Glucose: glucose - rate*float64(idx)})
+	}
+	return pkt
+}
+
+func equal(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for idx := range got {
+		if got[idx] != want[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNewSensorRetiresOld(t *testing.T) {
+	rec := &recorder{}
+	engine := NewEngine(&Config{Rules: Rules{Missed: Rule{After: 20 * time.Minute}}}, rec)
+	start := time.Now()
+	engine.WriteReading(reading("OLD", "mm1", start, 100))
+	engine.Check(start.Add(30 * time.Minute))
+	engine.WriteReading(reading("NEW", "mm1", start.Add(31*time.Minute), 100))
+	engine.Check(start.Add(40 * time.Minute))
+	engine.Close()
+	want := []string{"missed/firing/OLD", "missed/cleared/OLD"}
+	if got := rec.told(); !equal(got, want) {
+		t.Errorf("told %v, want %v", got, want)
+	}
+}
+
+func TestQuietSensorsForgotten(t *testing.T) {
+	rec := &recorder{}
+	engine := NewEngine(&Config{Rules: Rules{Missed: Rule{After: 20 * time.Minute}}}, rec)
+	start := time.Now()
+	engine.WriteReading(reading("OLD", "", start, 100))
+	engine.Check(start.Add(30 * time.Minute))
+	engine.Check(start.Add(forgetAfter + time.Hour))
+	engine.Check(start.Add(forgetAfter + 2*time.Hour))
+	engine.Close()
+	want := []string{"missed/firing/OLD", "missed/cleared/OLD"}
+	if got := rec.told(); !equal(got, want) {
+		t.Errorf("told %v, want %v", got, want)
+	}
+}
+
+func TestRememberCarriesOn(t *testing.T) {
+	config := &Config{Rules: Rules{UrgentLow: Rule{Threshold: 55}}}
+	first := &recorder{}
+	engine := NewEngine(config, first)
+	start := time.Now()
+	engine.WriteReading(reading("S1", "mm1", start, 50))
+	firing := engine.Firing()
+	if len(firing) != 1 || !engine.Ack(firing[0].ID) {
+		t.Fatalf("got %v firing, want one to acknowledge", firing)
+	}
+	engine.Close()
+
+	second := &recorder{}
+	reloaded := NewEngine(config, second)
+	reloaded.Remember(engine.Memory())
+	reloaded.WriteReading(reading("S1", "mm1", start.Add(time.Minute), 48))
+	if now := reloaded.Firing(); len(now) != 1 || now[0].ID != firing[0].ID {
+		t.Errorf("got %v firing after reload, want %v", now, firing[0].ID)
+	}
+	reloaded.Close()
+	if got := second.told(); len(got) != 0 {
+		t.Errorf("reloaded engine told %v, want nothing", got)
+	}
+}
+
+func TestSlowNotifierHoldsUpNobody(t *testing.T) {
+	stuck := make(chan struct{})
+	defer close(stuck)
+	slow := NotifierFunc(func(Alert) error {
+		<-stuck
+		return nil
+	})
+	told := make(chan Alert, 1)
+	fast := NotifierFunc(func(alert Alert) error {
+		told <- alert
+		return nil
+	})
+	engine := NewEngine(&Config{Rules: Rules{UrgentLow: Rule{Threshold: 55}}}, Named("slow", slow), Named("fast", fast))
+	engine.WriteReading(reading("S1", "mm1", time.Now(), 50))
+	select {
+	case alert := <-told:
+		if alert.Kind != UrgentLow {
+			t.Errorf("told %v, want urgent low", alert.Kind)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fast notifier waited on the slow one")
+	}
+}
+
+func TestClearedWithoutRateOrForecast(t *testing.T) {
+	rec := &recorder{}
+	engine := NewEngine(&Config{Rules: Rules{
+		Rising:    Rule{Threshold: 2},
+		Predicted: Rule{Threshold: 70, Ahead: 20 * time.Minute, Lookback: 15 * time.Minute, Confidence: 0.5},
+	}}, rec)
+	start := time.Now()
+	engine.WriteReading(trending("S1", start, 150, 3))
+	engine.WriteReading(trending("S2", start, 100, -2))
+	// a packet with too little in it for a rate or a forecast
+	engine.WriteReading(reading("S1", "", start.Add(time.Minute), 150))
+	engine.WriteReading(reading("S2", "", start.Add(time.Minute), 100))
+	engine.Close()
+	want := []string{"rising/firing/S1", "predicted-low/firing/S2", "rising/cleared/S1", "predicted-low/cleared/S2"}
+	if got := rec.told(); !equal(got, want) {
+		t.Errorf("told %v, want %v", got, want)
+	}
+	if firing := engine.Firing(); len(firing) != 0 {
+		t.Errorf("still firing %v", firing)
+	}
+}
+
+// step is something happening to an engine some minutes in: a reading
+// (trending at rate), a check, or a snooze of a kind
+type step struct {
+	minute  int
+	glucose float64
+	rate    float64
+	check   bool
+	snooze  Kind
+}
+
+func TestEngine(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		rules Rules
+		steps []step
+		want  []string
+	}{
+		{
+			name:  "high crosses, holds in the band and clears",
+			rules: Rules{High: Rule{Threshold: 180, Hysteresis: 10}},
+			steps: []step{{minute: 0, glucose: 170}, {minute: 1, glucose: 185}, {minute: 2, glucose: 175}, {minute: 3, glucose: 171}, {minute: 4, glucose: 169}},
+			want:  []string{"high/firing/S1", "high/cleared/S1"},
+		},
+		{
+			name:  "low crosses, holds in the band and clears",
+			rules: Rules{Low: Rule{Threshold: 70, Hysteresis: 5}},
+			steps: []step{{minute: 0, glucose: 75}, {minute: 1, glucose: 68}, {minute: 2, glucose: 72}, {minute: 3, glucose: 75}, {minute: 4, glucose: 76}},
+			want:  []string{"low/firing/S1", "low/cleared/S1"},
+		},
+		{
+			name:  "urgent low holds low until it clears",
+			rules: Rules{UrgentLow: Rule{Threshold: 55, Hysteresis: 5}, Low: Rule{Threshold: 70, Hysteresis: 5}},
+			steps: []step{{minute: 0, glucose: 50}, {minute: 1, glucose: 58}, {minute: 2, glucose: 62}, {minute: 3, glucose: 80}},
+			want:  []string{"urgent-low/firing/S1", "urgent-low/cleared/S1", "low/firing/S1", "low/cleared/S1"},
+		},
+		{
+			name:  "rising and falling go by the trend rate",
+			rules: Rules{Rising: Rule{Threshold: 2, Hysteresis: 0.5}, Falling: Rule{Threshold: 2, Hysteresis: 0.5}},
+			steps: []step{
+				{minute: 0, glucose: 150, rate: 3}, {minute: 1, glucose: 153, rate: 1.8}, {minute: 2, glucose: 154, rate: 1},
+				{minute: 3, glucose: 150, rate: -3}, {minute: 4, glucose: 147, rate: -1.8}, {minute: 5, glucose: 146, rate: 0},
+			},
+			want: []string{"rising/firing/S1", "rising/cleared/S1", "falling/firing/S1", "falling/cleared/S1"},
+		},
+		{
+			name:  "realerts as often as the rule says",
+			rules: Rules{Low: Rule{Threshold: 70, Realert: 10 * time.Minute}},
+			steps: []step{{minute: 0, glucose: 60}, {minute: 5, glucose: 60}, {minute: 10, glucose: 60}, {minute: 15, glucose: 60}, {minute: 20, glucose: 60}},
+			want:  []string{"low/firing/S1", "low/repeat/S1", "low/repeat/S1"},
+		},
+		{
+			name:  "snoozed until the snooze runs out",
+			rules: Rules{Low: Rule{Threshold: 70, Realert: 5 * time.Minute, Snooze: 30 * time.Minute}},
+			steps: []step{{minute: 0, glucose: 60}, {minute: 1, snooze: Low}, {minute: 5, glucose: 60}, {minute: 20, glucose: 60}, {minute: 31, glucose: 60}, {minute: 32, glucose: 80}},
+			want:  []string{"low/firing/S1", "low/repeat/S1", "low/cleared/S1"},
+		},
+		{
+			name:  "missed readings fire after a while and clear when they're back",
+			rules: Rules{Missed: Rule{After: 20 * time.Minute}},
+			steps: []step{{minute: 0, glucose: 100}, {minute: 15, check: true}, {minute: 20, check: true}, {minute: 25, check: true}, {minute: 26, glucose: 100}, {minute: 30, check: true}},
+			want:  []string{"missed/firing/S1", "missed/cleared/S1"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rec := &recorder{}
+			start := time.Now().Truncate(time.Minute)
+			now := start
+			engine := NewEngine(&Config{Rules: test.rules}, rec)
+			engine.now = func() time.Time { return now }
+			for _, step := range test.steps {
+				now = start.Add(time.Duration(step.minute) * time.Minute)
+				switch {
+				case step.check:
+					engine.Check(now)
+				case len(step.snooze) > 0:
+					engine.Snooze(step.snooze, "S1", 0)
+				default:
+					engine.WriteReading(trending("S1", now, step.glucose, step.rate))
+				}
+			}
+			engine.Close()
+			if got := rec.told(); !equal(got, test.want) {
+				t.Errorf("told %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		ackAt   int
+		partner []string
+		grandpa []string
+	}{
+		{
+			name:    "up the chain by after",
+			partner: []string{"urgent-low/escalated/S1"},
+			grandpa: []string{"urgent-low/escalated/S1"},
+		},
+		{
+			name:    "an ack stops the chain",
+			ackAt:   15,
+			partner: []string{"urgent-low/escalated/S1"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			phone, partner, grandpa := &recorder{}, &recorder{}, &recorder{}
+			engine := NewEngine(&Config{
+				Rules:  Rules{UrgentLow: Rule{Threshold: 55}},
+				Notify: []string{"phone"},
+				Escalate: []Escalation{
+					{After: 10 * time.Minute, Notify: []string{"partner"}},
+					{After: 20 * time.Minute, Notify: []string{"grandpa"}},
+				},
+			}, Named("phone", phone), Named("partner", partner), Named("grandpa", grandpa))
+			start := time.Now().Truncate(time.Minute)
+			now := start
+			engine.now = func() time.Time { return now }
+			engine.WriteReading(reading("S1", "", start, 50))
+			for minute := 5; minute <= 30; minute += 5 {
+				now = start.Add(time.Duration(minute) * time.Minute)
+				if minute == test.ackAt {
+					firing := engine.Firing()
+					if len(firing) != 1 || !engine.Ack(firing[0].ID) {
+						t.Fatalf("got %v firing, want one to acknowledge", firing)
+					}
+				}
+				engine.Check(now)
+			}
+			engine.Close()
+			if got, want := phone.told(), []string{"urgent-low/firing/S1"}; !equal(got, want) {
+				t.Errorf("phone told %v, want %v", got, want)
+			}
+			if got := partner.told(); !equal(got, test.partner) {
+				t.Errorf("partner told %v, want %v", got, test.partner)
+			}
+			if got := grandpa.told(); !equal(got, test.grandpa) {
+				t.Errorf("grandpa told %v, want %v", got, test.grandpa)
+			}
+		})
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	phone, bedside := &recorder{}, &recorder{}
+	engine := NewEngine(&Config{
+		Rules:    Rules{High: Rule{Threshold: 180}, Low: Rule{Threshold: 70}},
+		Timezone: "UTC",
+		Notify:   []string{"phone"},
+		Profiles: []Profile{{Name: "night", From: "22:00", To: "07:00", Quiet: []Kind{High}, Notify: []string{"bedside"}}},
+	}, Named("phone", phone), Named("bedside", bedside))
+	evening := time.Date(2026, 10, 19, 21, 50, 0, 0, time.UTC)
+	for _, step := range []struct {
+		at      time.Time
+		glucose float64
+	}{
+		{evening, 60},
+		// the night profile tells bedside
+		{evening.Add(15 * time.Minute), 80},
+		// and keeps high quiet until it's over
+		{evening.Add(20 * time.Minute), 200},
+		{evening.Add(9 * time.Hour), 200},
+		{evening.Add(9*time.Hour + 10*time.Minute), 200},
+	} {
+		engine.WriteReading(reading("S1", "", step.at, step.glucose))
+	}
+	engine.Close()
+	if got, want := phone.told(), []string{"low/firing/S1", "high/firing/S1"}; !equal(got, want) {
+		t.Errorf("phone told %v, want %v", got, want)
+	}
+	if got, want := bedside.told(), []string{"low/cleared/S1"}; !equal(got, want) {
+		t.Errorf("bedside told %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,353 @@
+package alert
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule is when one kind of alert fires and how it nags
+type Rule struct {
+	// Threshold is mg/dL for glucose alerts and mg/dL a minute for rate
+	// alerts; zero turns the alert off
+	Threshold float64 `yaml:"threshold"`
+	// Hysteresis is how far back past Threshold things have to get for
+	// the alert to clear
+	Hysteresis float64 `yaml:"hysteresis"`
+	// After is how long without a reading is missed
+	After time.Duration `yaml:"after"`
+	// Realert is how often a firing alert is repeated; zero never
+	Realert time.Duration `yaml:"realert"`
+	// Snooze is how long a snooze lasts, unless it says otherwise
+	Snooze time.Duration `yaml:"snooze"`
+	// Ahead is how far ahead a predicted low looks, Lookback how far back
+	// it fits to, and Confidence how sure it has to be
+	Ahead      time.Duration `yaml:"ahead"`
+	Lookback   time.Duration `yaml:"lookback"`
+	Confidence float64       `yaml:"confidence"`
+}
+
+// Rules are the rule for every kind of alert
+type Rules struct {
+	High      Rule `yaml:"high"`
+	Low       Rule `yaml:"low"`
+	UrgentLow Rule `yaml:"urgentlow"`
+	Rising    Rule `yaml:"rising"`
+	Falling   Rule `yaml:"falling"`
+	Missed    Rule `yaml:"missed"`
+	Predicted Rule `yaml:"predicted"`
+}
+
+// Config is the rules, when they change, and who to tell
+type Config struct {
+	Rules `yaml:",inline"`
+	// Timezone is where profiles' windows are, the local one by default
+	Timezone string    `yaml:"timezone"`
+	Profiles []Profile `yaml:"profiles"`
+	// Notify is who's told, by notifier name; everyone if nobody's named
+	Notify []string `yaml:"notify"`
+	// Escalate is who else is told, and when, about urgent alerts nobody
+	// acknowledges
+	Escalate []Escalation `yaml:"escalate"`
+	// Listen is an address to take acknowledgements and snoozes on
+	Listen string `yaml:"listen"`
+	// Token is what acknowledgements and snoozes have to carry, as a
+	// bearer token; without one, they're only taken from this machine
+	Token     string           `yaml:"token"`
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+}
+
+// Notifier types
+const (
+	NotifyLog     = "log"
+	NotifyWebhook = "webhook"
+	NotifyExec    = "exec"
+	NotifyEmail   = "email"
+	NotifyNtfy    = "ntfy"
+	NotifyGotify  = "gotify"
+)
+
+// Notifier defaults, where the config doesn't say
+const (
+	defaultRetries = 3
+	defaultBackoff = time.Second
+	defaultTimeout = 10 * time.Second
+)
+
+// NotifierConfig is someone to tell; which fields matter depends on the type
+type NotifierConfig struct {
+	Type string `yaml:"type"`
+	// Name is what profiles and escalations call it
+	Name string `yaml:"name"`
+	// URL is where a webhook posts, the ntfy topic, or the Gotify server
+	URL string `yaml:"url"`
+	// Secret signs webhook bodies
+	Secret string `yaml:"secret"`
+	// Token is an ntfy access token or Gotify application token
+	Token string `yaml:"token"`
+	// Command is what exec runs, program first
+	Command []string `yaml:"command"`
+	// Server (host:port), User, Pass, From and To are for email
+	Server string   `yaml:"server"`
+	User   string   `yaml:"user"`
+	Pass   string   `yaml:"pass"`
+	From   string   `yaml:"from"`
+	To     []string `yaml:"to"`
+	// Retries, Backoff and Timeout are how hard to try
+	Retries int           `yaml:"retries"`
+	Backoff time.Duration `yaml:"backoff"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Validate checks a notifier has what its type needs
+func (nc NotifierConfig) Validate() error {
+	if nc.Retries < 0 || nc.Backoff < 0 || nc.Timeout < 0 {
+		return fmt.Errorf("%v notifier: nothing can be negative", nc.Type)
+	}
+	switch nc.Type {
+	case NotifyLog:
+		return nil
+	case NotifyWebhook, NotifyNtfy, NotifyGotify:
+		if len(nc.URL) == 0 {
+			return fmt.Errorf("%v notifier needs a url", nc.Type)
+		}
+		if _, err := url.Parse(nc.URL); err != nil {
+			return fmt.Errorf("%v notifier: %v", nc.Type, err)
+		}
+		if nc.Type == NotifyGotify && len(nc.Token) == 0 {
+			return fmt.Errorf("gotify notifier needs a token")
+		}
+		return nil
+	case NotifyExec:
+		if len(nc.Command) == 0 || len(nc.Command[0]) == 0 {
+			return fmt.Errorf("exec notifier needs a command")
+		}
+		return nil
+	case NotifyEmail:
+		if _, _, err := net.SplitHostPort(nc.Server); err != nil {
+			return fmt.Errorf("email notifier needs a server as host:port: %v", err)
+		}
+		if len(nc.From) == 0 || len(nc.To) == 0 {
+			return fmt.Errorf("email notifier needs from and to")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown notifier %q", nc.Type)
+}
+
+// Open makes the notifier, named if it has one
+func (nc NotifierConfig) Open() (Notifier, error) {
+	if err := nc.Validate(); err != nil {
+		return nil, err
+	}
+	retries, backoff, timeout := nc.Retries, nc.Backoff, nc.Timeout
+	if retries == 0 {
+		retries = defaultRetries
+	}
+	if backoff == 0 {
+		backoff = defaultBackoff
+	}
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	var notifier Notifier
+	switch nc.Type {
+	case NotifyLog:
+		notifier = LogNotifier{}
+	case NotifyWebhook:
+		notifier = &Webhook{URL: nc.URL, Secret: nc.Secret, Client: client, Retries: retries, Backoff: backoff}
+	case NotifyNtfy:
+		notifier = &Ntfy{URL: nc.URL, Token: nc.Token, Client: client, Retries: retries, Backoff: backoff}
+	case NotifyGotify:
+		notifier = &Gotify{URL: nc.URL, Token: nc.Token, Client: client, Retries: retries, Backoff: backoff}
+	case NotifyExec:
+		notifier = &Exec{Command: nc.Command, Timeout: timeout}
+	case NotifyEmail:
+		notifier = &Email{Server: nc.Server, User: nc.User, Pass: nc.Pass, From: nc.From, To: nc.To,
+			Retries: retries, Backoff: backoff, Timeout: timeout}
+	}
+	if len(nc.Name) > 0 {
+		notifier = Named(nc.Name, notifier)
+	}
+	return notifier, nil
+}
+
+// Flags registers the alert.* flags on a flag set
+func Flags(fs *flag.FlagSet) *Config {
+	config := &Config{Rules: Rules{
+		High:      Rule{Hysteresis: 10},
+		Low:       Rule{Hysteresis: 10},
+		UrgentLow: Rule{Hysteresis: 10},
+		Rising:    Rule{Hysteresis: 0.5},
+		Falling:   Rule{Hysteresis: 0.5},
+		Predicted: Rule{Hysteresis: 10},
+	}}
+	fs.Float64Var(&config.High.Threshold, "alert.high", 180, "high alert above this mg/dL (0 for none)")
+	fs.Float64Var(&config.Low.Threshold, "alert.low", 70, "low alert below this mg/dL (0 for none)")
+	fs.Float64Var(&config.UrgentLow.Threshold, "alert.urgentlow", 55, "urgent low alert below this mg/dL (0 for none)")
+	fs.Float64Var(&config.Rising.Threshold, "alert.rising", 3, "rising alert above this many mg/dL a minute (0 for none)")
+	fs.Float64Var(&config.Falling.Threshold, "alert.falling", 3, "falling alert above this many mg/dL a minute (0 for none)")
+	fs.Float64Var(&config.Predicted.Threshold, "alert.predicted", 70, "predicted low alert when headed below this mg/dL (0 for none)")
+	fs.DurationVar(&config.Predicted.Ahead, "alert.ahead", 20*time.Minute, "how far ahead to predict lows")
+	fs.DurationVar(&config.Predicted.Lookback, "alert.lookback", 30*time.Minute, "how far back to fit predictions to")
+	fs.Float64Var(&config.Predicted.Confidence, "alert.confidence", 0.7, "how sure a predicted low has to be, 0 to 1 (0.5 is the projection itself)")
+	fs.DurationVar(&config.Missed.After, "alert.missed", 20*time.Minute, "missed readings alert after this long without one (0 for none)")
+	fs.Func("alert.hysteresis", "how many mg/dL back past a glucose threshold clears its alert (default 10)", func(value string) error {
+		hysteresis, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		config.High.Hysteresis, config.Low.Hysteresis, config.UrgentLow.Hysteresis, config.Predicted.Hysteresis =
+			hysteresis, hysteresis, hysteresis, hysteresis
+		return nil
+	})
+	fs.Func("alert.realert", "repeat firing alerts this often, urgent low excepted (default 30m)", func(value string) error {
+		realert, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		config.High.Realert, config.Low.Realert, config.Rising.Realert, config.Falling.Realert, config.Missed.Realert, config.Predicted.Realert =
+			realert, realert, realert, realert, realert, realert
+		return nil
+	})
+	fs.DurationVar(&config.UrgentLow.Realert, "alert.urgentrealert", 5*time.Minute, "repeat an urgent low alert this often")
+	fs.Func("alert.snooze", "how long a snooze lasts (default 30m)", func(value string) error {
+		snooze, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		for _, rule := range config.rules() {
+			rule.Snooze = snooze
+		}
+		return nil
+	})
+	fs.StringVar(&config.Timezone, "alert.timezone", "", "timezone of schedule profiles, e.g. Europe/London (default local)")
+	fs.StringVar(&config.Listen, "alert.listen", "", "take acknowledgements and snoozes over HTTP on this address")
+	fs.StringVar(&config.Token, "alert.token", "", "bearer token acknowledgements and snoozes need (default: only from localhost)")
+	fs.Func("alert.notify", "who to tell, comma separated: log (default log; the other notifiers need a pipeline file)", func(value string) error {
+		config.Notifiers = nil
+		for _, notifier := range strings.Split(value, ",") {
+			config.Notifiers = append(config.Notifiers, NotifierConfig{Type: strings.TrimSpace(notifier)})
+		}
+		return nil
+	})
+	for _, rule := range config.rules() {
+		rule.Snooze = 30 * time.Minute
+		if rule != &config.UrgentLow {
+			rule.Realert = 30 * time.Minute
+		}
+	}
+	config.Notifiers = []NotifierConfig{{Type: NotifyLog}}
+	return config
+}
+
+// rules is every rule, by kind
+func (rules *Rules) rules() map[Kind]*Rule {
+	return map[Kind]*Rule{
+		High:         &rules.High,
+		Low:          &rules.Low,
+		UrgentLow:    &rules.UrgentLow,
+		Rising:       &rules.Rising,
+		Falling:      &rules.Falling,
+		Missed:       &rules.Missed,
+		PredictedLow: &rules.Predicted,
+	}
+}
+
+// Validate checks the rules make sense
+func (config *Config) Validate() error {
+	for kind, rule := range config.rules() {
+		if rule.Threshold < 0 || rule.Hysteresis < 0 || rule.After < 0 || rule.Realert < 0 || rule.Snooze < 0 {
+			return fmt.Errorf("%v alert: nothing can be negative", kind)
+		}
+	}
+	if predicted := config.Predicted; predicted.Threshold > 0 {
+		if predicted.Ahead <= 0 || predicted.Lookback <= 0 {
+			return fmt.Errorf("predicted low needs ahead and lookback")
+		}
+		if predicted.Confidence <= 0 || predicted.Confidence >= 1 {
+			return fmt.Errorf("predicted low confidence must be between 0 and 1")
+		}
+	}
+	if config.UrgentLow.Threshold > 0 && config.Low.Threshold > 0 && config.UrgentLow.Threshold > config.Low.Threshold {
+		return fmt.Errorf("urgent low is above low")
+	}
+	if _, err := config.location(); err != nil {
+		return fmt.Errorf("bad timezone: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, notifier := range config.Notifiers {
+		if err := notifier.Validate(); err != nil {
+			return err
+		}
+		if len(notifier.Name) > 0 {
+			if names[notifier.Name] {
+				return fmt.Errorf("two notifiers called %q", notifier.Name)
+			}
+			names[notifier.Name] = true
+		}
+	}
+	known := func(notify []string) error {
+		for _, name := range notify {
+			if !names[name] {
+				return fmt.Errorf("no notifier called %q", name)
+			}
+		}
+		return nil
+	}
+	if err := known(config.Notify); err != nil {
+		return err
+	}
+	for idx := range config.Profiles {
+		profile := &config.Profiles[idx]
+		if err := profile.Validate(); err != nil {
+			return fmt.Errorf("profile %q: %v", profile.Name, err)
+		}
+		if err := known(profile.Notify); err != nil {
+			return fmt.Errorf("profile %q: %v", profile.Name, err)
+		}
+	}
+	var after time.Duration
+	for _, step := range config.Escalate {
+		if step.After <= after {
+			return fmt.Errorf("escalations must come one after another")
+		}
+		if len(step.Notify) == 0 {
+			return fmt.Errorf("escalation after %v notifies nobody", step.After)
+		}
+		if err := known(step.Notify); err != nil {
+			return fmt.Errorf("escalation after %v: %v", step.After, err)
+		}
+		after = step.After
+	}
+	return nil
+}
+
+// Open starts an engine following the rules, telling the notifiers
+func (config *Config) Open() (*Engine, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	var notifiers []Notifier
+	for _, nc := range config.Notifiers {
+		notifier, err := nc.Open()
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	engine := NewEngine(config, notifiers...)
+	if len(config.Listen) > 0 {
+		if err := engine.Listen(config.Listen); err != nil {
+			engine.Close()
+			return nil, err
+		}
+	}
+	return engine, nil
+}
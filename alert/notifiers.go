@@ -0,0 +1,330 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries a webhook body's HMAC-SHA256, as sha256=<hex>
+const SignatureHeader = "X-Miao2go-Signature"
+
+// urgency is how loudly to tell people about an alert: 0 for one that's
+// cleared, 1 for a warning, 2 for anything urgent
+func urgency(alert Alert) int {
+	switch {
+	case alert.State == Cleared:
+		return 0
+	case alert.Severity == Urgent || alert.State == Escalated:
+		return 2
+	}
+	return 1
+}
+
+// title is a one line summary of an alert
+func title(alert Alert) string {
+	return fmt.Sprintf("%v %v %v", alert.Kind, alert.State, alert.Serial)
+}
+
+// retry is miao2go.Retry, with the notifier defaults for zero values
+func retry(retries int, backoff time.Duration, try func() error) error {
+	if retries <= 0 {
+		retries = defaultRetries
+	}
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+	return miao2go.Retry(retries, backoff, try)
+}
+
+// post sends a request, failing on anything but a 2xx.  A nil client is
+// http.DefaultClient
+func post(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		text, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%v said %v: %s", req.URL.Host, resp.Status, bytes.TrimSpace(text))
+	}
+	return nil
+}
+
+// Sign is the signature of a webhook body with a secret, as it's sent in
+// SignatureHeader
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Webhook posts alerts as JSON, signed if there's a secret
+type Webhook struct {
+	URL     string
+	Secret  string
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+}
+
+// Notify posts an alert, retrying failures with backoff
+func (hook *Webhook) Notify(alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	return retry(hook.Retries, hook.Backoff, func() error {
+		req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if len(hook.Secret) > 0 {
+			req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+		}
+		return post(hook.Client, req)
+	})
+}
+
+// Ntfy pushes alerts to an ntfy topic, given as its whole URL
+type Ntfy struct {
+	URL     string
+	Token   string
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+}
+
+var (
+	ntfyPriorities = []string{"low", "high", "urgent"}
+	ntfyTags       = []string{"white_check_mark", "warning", "rotating_light"}
+)
+
+// Notify pushes an alert, retrying failures with backoff
+func (push *Ntfy) Notify(alert Alert) error {
+	return retry(push.Retries, push.Backoff, func() error {
+		req, err := http.NewRequest("POST", push.URL, strings.NewReader(alert.Message))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Title", title(alert))
+		req.Header.Set("Priority", ntfyPriorities[urgency(alert)])
+		req.Header.Set("Tags", ntfyTags[urgency(alert)])
+		if len(push.Token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+push.Token)
+		}
+		return post(push.Client, req)
+	})
+}
+
+// Gotify pushes alerts to a Gotify server with an application token
+type Gotify struct {
+	URL     string
+	Token   string
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+}
+
+var gotifyPriorities = []int{2, 5, 8}
+
+// Notify pushes an alert, retrying failures with backoff
+func (push *Gotify) Notify(alert Alert) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    title(alert),
+		"message":  alert.Message,
+		"priority": gotifyPriorities[urgency(alert)],
+	})
+	if err != nil {
+		return err
+	}
+	return retry(push.Retries, push.Backoff, func() error {
+		req, err := http.NewRequest("POST", strings.TrimRight(push.URL, "/")+"/message", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gotify-Key", push.Token)
+		return post(push.Client, req)
+	})
+}
+
+// Exec runs a command for each alert, with the alert in its environment as
+// M2G_ALERT_* variables (and the whole thing as JSON in M2G_ALERT)
+type Exec struct {
+	Command []string
+	Timeout time.Duration
+}
+
+// Environment is an alert as environment variables
+func Environment(alert Alert) []string {
+	rate := ""
+	if alert.Rate != nil {
+		rate = fmt.Sprintf("%.1f", *alert.Rate)
+	}
+	whole, _ := json.Marshal(alert)
+	return []string{
+		"M2G_ALERT=" + string(whole),
+		"M2G_ALERT_ID=" + alert.ID,
+		"M2G_ALERT_KIND=" + string(alert.Kind),
+		"M2G_ALERT_SEVERITY=" + string(alert.Severity),
+		"M2G_ALERT_STATE=" + string(alert.State),
+		"M2G_ALERT_SERIAL=" + alert.Serial,
+		"M2G_ALERT_XMIT=" + alert.Transmitter,
+		"M2G_ALERT_START=" + alert.Start.Format(time.RFC3339),
+		"M2G_ALERT_TIME=" + alert.Time.Format(time.RFC3339),
+		fmt.Sprintf("M2G_ALERT_GLUCOSE=%.0f", alert.Glucose),
+		"M2G_ALERT_RATE=" + rate,
+		"M2G_ALERT_MESSAGE=" + alert.Message,
+		fmt.Sprintf("M2G_ALERT_ESCALATION=%v", alert.Escalation),
+	}
+}
+
+// Notify runs the command, failing if it does or takes too long
+func (ex *Exec) Notify(alert Alert) error {
+	timeout := ex.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ex.Command[0], ex.Command[1:]...)
+	cmd.Env = append(os.Environ(), Environment(alert)...)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if output = bytes.TrimSpace(output); len(output) > 0 {
+		return fmt.Errorf("%v: %v: %s", ex.Command[0], err, output)
+	}
+	return fmt.Errorf("%v: %v", ex.Command[0], err)
+}
+
+// Email mails alerts over SMTP, with STARTTLS where the server offers it
+type Email struct {
+	// Server is host:port
+	Server  string
+	User    string
+	Pass    string
+	From    string
+	To      []string
+	Retries int
+	Backoff time.Duration
+	// Timeout bounds each attempt, from connecting to the server on
+	Timeout time.Duration
+}
+
+// message is an alert as a mail message
+func (mail *Email) message(alert Alert) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %v\r\n", mail.From)
+	fmt.Fprintf(&msg, "To: %v\r\n", strings.Join(mail.To, ", "))
+	fmt.Fprintf(&msg, "Subject: miao2go: %v\r\n", alert)
+	fmt.Fprintf(&msg, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	if urgency(alert) == 2 {
+		fmt.Fprintf(&msg, "X-Priority: 1\r\nImportance: high\r\n")
+	}
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%v\r\n\r\n", alert.Message)
+	fmt.Fprintf(&msg, "sensor:  %v\r\n", alert.Serial)
+	if alert.Glucose > 0 {
+		fmt.Fprintf(&msg, "glucose: %.0f mg/dL\r\n", alert.Glucose)
+	}
+	if alert.Rate != nil {
+		fmt.Fprintf(&msg, "rate:    %+.1f mg/dL/min\r\n", *alert.Rate)
+	}
+	fmt.Fprintf(&msg, "since:   %v\r\n", alert.Start.Format(time.RFC3339))
+	fmt.Fprintf(&msg, "id:      %v\r\n", alert.ID)
+	return msg.Bytes()
+}
+
+// Notify mails an alert, retrying failures with backoff
+func (mail *Email) Notify(alert Alert) error {
+	var auth smtp.Auth
+	if len(mail.User) > 0 {
+		host, _, err := net.SplitHostPort(mail.Server)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", mail.User, mail.Pass, host)
+	}
+	msg := mail.message(alert)
+	return retry(mail.Retries, mail.Backoff, func() error {
+		return mail.send(auth, msg)
+	})
+}
+
+// send is smtp.SendMail, but giving up on a server that takes too long
+func (mail *Email) send(auth smtp.Auth, msg []byte) error {
+	timeout := mail.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	host, _, err := net.SplitHostPort(mail.Server)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", mail.Server, timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("%v doesn't take logins", host)
+		}
+		if err = client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(mail.From); err != nil {
+		return err
+	}
+	for _, to := range mail.To {
+		if err = client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	body, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = body.Write(msg); err != nil {
+		return err
+	}
+	if err = body.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,241 @@
+package alert
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// urgentLow is an alert to send
+var urgentLow = Alert{
+	ID:       "urgent-low/0M0001/1537869600",
+	Kind:     UrgentLow,
+	Severity: Urgent,
+	State:    Firing,
+	Serial:   "0M0001",
+	Glucose:  50,
+	Message:  "urgent low: 50 mg/dL",
+}
+
+func TestSign(t *testing.T) {
+	for _, tc := range []struct {
+		secret, body, want string
+	}{
+		// RFC 4231 test case 2
+		{"Jefe", "what do ya want for nothing?", "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+		{"", "", "sha256=b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad"},
+	} {
+		if got := Sign(tc.secret, []byte(tc.body)); got != tc.want {
+			t.Errorf("Sign(%q, %q) = %v, want %v", tc.secret, tc.body, got, tc.want)
+		}
+	}
+}
+
+// capture is a server that keeps the last request, answering with status
+func capture(t *testing.T, status int) (*httptest.Server, func() (*http.Request, []byte)) {
+	var (
+		last *http.Request
+		body []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	return server, func() (*http.Request, []byte) { return last, body }
+}
+
+func TestWebhook(t *testing.T) {
+	server, got := capture(t, http.StatusOK)
+	defer server.Close()
+	// a zero Client and Retries are fine
+	hook := &Webhook{URL: server.URL + "/hook", Secret: "sssh"}
+	if err := hook.Notify(urgentLow); err != nil {
+		t.Fatal(err)
+	}
+	req, body := got()
+	if req.URL.Path != "/hook" || req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("got %v %v", req.URL.Path, req.Header.Get("Content-Type"))
+	}
+	if req.Header.Get(SignatureHeader) != Sign("sssh", body) {
+		t.Errorf("signature %v doesn't match body", req.Header.Get(SignatureHeader))
+	}
+	var sent Alert
+	if err := json.Unmarshal(body, &sent); err != nil || sent.ID != urgentLow.ID {
+		t.Errorf("sent %s (%v), want the alert", body, err)
+	}
+}
+
+func TestWebhookFailure(t *testing.T) {
+	server, _ := capture(t, http.StatusInternalServerError)
+	defer server.Close()
+	hook := &Webhook{URL: server.URL, Retries: 2, Backoff: time.Millisecond}
+	if err := hook.Notify(urgentLow); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("got %v, want a 500", err)
+	}
+}
+
+func TestNtfy(t *testing.T) {
+	server, got := capture(t, http.StatusOK)
+	defer server.Close()
+	if err := (&Ntfy{URL: server.URL + "/glucose", Token: "tk"}).Notify(urgentLow); err != nil {
+		t.Fatal(err)
+	}
+	req, body := got()
+	for header, want := range map[string]string{
+		"Title":         "urgent-low firing 0M0001",
+		"Priority":      "urgent",
+		"Tags":          "rotating_light",
+		"Authorization": "Bearer tk",
+	} {
+		if req.Header.Get(header) != want {
+			t.Errorf("%v: got %q, want %q", header, req.Header.Get(header), want)
+		}
+	}
+	if string(body) != urgentLow.Message {
+		t.Errorf("got body %q, want the message", body)
+	}
+}
+
+func TestGotify(t *testing.T) {
+	server, got := capture(t, http.StatusOK)
+	defer server.Close()
+	if err := (&Gotify{URL: server.URL + "/", Token: "app"}).Notify(urgentLow); err != nil {
+		t.Fatal(err)
+	}
+	req, body := got()
+	if req.URL.Path != "/message" || req.Header.Get("X-Gotify-Key") != "app" {
+		t.Errorf("posted to %v with key %q", req.URL.Path, req.Header.Get("X-Gotify-Key"))
+	}
+	var message struct {
+		Title    string `json:"title"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.Unmarshal(body, &message); err != nil || message.Priority != 8 || message.Title != "urgent-low firing 0M0001" {
+		t.Errorf("sent %s (%v)", body, err)
+	}
+}
+
+func TestExecZeroTimeout(t *testing.T) {
+	if err := (&Exec{Command: []string{"true"}}).Notify(urgentLow); err != nil {
+		t.Errorf("got %v, want the default timeout", err)
+	}
+}
+
+func TestExecEnvironment(t *testing.T) {
+	ex := &Exec{Command: []string{"sh", "-c", `test "$M2G_ALERT_KIND" = urgent-low && test "$M2G_ALERT_SERIAL" = 0M0001 && test "$M2G_ALERT_GLUCOSE" = 50`}}
+	if err := ex.Notify(urgentLow); err != nil {
+		t.Errorf("child didn't get the alert: %v", err)
+	}
+	ex = &Exec{Command: []string{"sh", "-c", "echo no thanks; exit 1"}}
+	if err := ex.Notify(urgentLow); err == nil || !strings.Contains(err.Error(), "no thanks") {
+		t.Errorf("got %v, want the child's output", err)
+	}
+}
+
+// smtpServer takes one message, keeping the commands and the data it was
+// sent; it doesn't offer STARTTLS or AUTH
+func smtpServer(t *testing.T) (string, <-chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan []string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		text := textproto.NewConn(conn)
+		defer text.Close()
+		var said []string
+		defer func() { got <- said }()
+		text.PrintfLine("220 localhost ready")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			said = append(said, line)
+			switch verb := strings.ToUpper(strings.Fields(line + " ")[0]); verb {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				text.PrintfLine("250 ok")
+			case "DATA":
+				text.PrintfLine("354 go on")
+				data, err := text.ReadDotLines()
+				if err != nil {
+					return
+				}
+				said = append(said, data...)
+				text.PrintfLine("250 queued")
+			case "QUIT":
+				text.PrintfLine("221 bye")
+				return
+			default:
+				text.PrintfLine("502 %v?", verb)
+			}
+		}
+	}()
+	return listener.Addr().String(), got
+}
+
+func TestEmail(t *testing.T) {
+	server, got := smtpServer(t)
+	mail := &Email{Server: server, From: "m2g@example.com", To: []string{"me@example.com", "you@example.com"}, Timeout: 5 * time.Second}
+	if err := mail.Notify(urgentLow); err != nil {
+		t.Fatal(err)
+	}
+	said := strings.Join(<-got, "\n")
+	for _, want := range []string{
+		"MAIL FROM:<m2g@example.com>",
+		"RCPT TO:<me@example.com>",
+		"RCPT TO:<you@example.com>",
+		"DATA",
+		"To: me@example.com, you@example.com",
+		"X-Priority: 1",
+		urgentLow.Message,
+		"id:      " + urgentLow.ID,
+		"QUIT",
+	} {
+		if !strings.Contains(said, want) {
+			t.Errorf("server wasn't sent %q:\n%v", want, said)
+		}
+	}
+}
+
+func TestEmailTimesOut(t *testing.T) {
+	// a server that takes the connection and never says hello
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	mail := &Email{Server: listener.Addr().String(), From: "m2g@example.com", To: []string{"me@example.com"},
+		Retries: 1, Timeout: 100 * time.Millisecond}
+	done := make(chan error)
+	go func() { done <- mail.Notify(urgentLow) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("sent to a server that never answered")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hung on a silent server")
+	}
+}
@@ -0,0 +1,98 @@
+package alert
+
+import (
+	"github.com/thecubic/miao2go"
+	"math"
+	"time"
+)
+
+// minForecastPoints is the fewest readings worth fitting a line to
+const minForecastPoints = 5
+
+// Forecast is where glucose is headed: a straight line fitted to recent
+// readings, projected forward, with how far off it's likely to be
+type Forecast struct {
+	// Time is when the forecast is for
+	Time time.Time
+	// Glucose is the projection, in mg/dL
+	Glucose float64
+	// Rate is the fitted slope, in mg/dL a minute
+	Rate float64
+	// StdErr is the standard error of the projection, in mg/dL
+	StdErr float64
+	// Points is how many readings went into it
+	Points int
+}
+
+// z is the standard normal quantile for probability p
+func z(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
+
+// Bound is the glucose the forecast is confident, to confidence, that
+// it'll be at or under: the projection at 0.5, higher for more
+func (forecast Forecast) Bound(confidence float64) float64 {
+	return forecast.Glucose + z(confidence)*forecast.StdErr
+}
+
+// Interval is the two-sided interval the forecast is confident, to
+// confidence, glucose will be in
+func (forecast Forecast) Interval(confidence float64) (float64, float64) {
+	spread := z(0.5+confidence/2) * forecast.StdErr
+	return forecast.Glucose - spread, forecast.Glucose + spread
+}
+
+// Predict fits a line to the readings taken in the lookback up to now
+// (the trend buffer, and whatever history falls in it) and projects it
+// ahead, with the standard error of a prediction from a least squares fit
+func Predict(readings []miao2go.GlucoseReading, now time.Time, lookback, ahead time.Duration) (Forecast, bool) {
+	var ts, gs []float64
+	seen := make(map[int64]bool)
+	for _, reading := range readings {
+		if reading.Raw == 0 || reading.Time.After(now) || now.Sub(reading.Time) > lookback {
+			continue
+		}
+		// the same minute in both buffers counts once
+		minute := reading.Time.Unix() / 60
+		if seen[minute] {
+			continue
+		}
+		seen[minute] = true
+		ts = append(ts, reading.Time.Sub(now).Minutes())
+		gs = append(gs, reading.Glucose)
+	}
+	n := float64(len(ts))
+	if len(ts) < minForecastPoints {
+		return Forecast{}, false
+	}
+	var tMean, gMean float64
+	for idx := range ts {
+		tMean += ts[idx]
+		gMean += gs[idx]
+	}
+	tMean, gMean = tMean/n, gMean/n
+	var stt, stg float64
+	for idx := range ts {
+		stt += (ts[idx] - tMean) * (ts[idx] - tMean)
+		stg += (ts[idx] - tMean) * (gs[idx] - gMean)
+	}
+	if stt == 0 {
+		return Forecast{}, false
+	}
+	slope := stg / stt
+	intercept := gMean - slope*tMean
+	var sse float64
+	for idx := range ts {
+		residual := gs[idx] - (intercept + slope*ts[idx])
+		sse += residual * residual
+	}
+	h := ahead.Minutes()
+	s := math.Sqrt(sse / (n - 2))
+	return Forecast{
+		Time:    now.Add(ahead),
+		Glucose: intercept + slope*h,
+		Rate:    slope,
+		StdErr:  s * math.Sqrt(1+1/n+(h-tMean)*(h-tMean)/stt),
+		Points:  len(ts),
+	}, true
+}
@@ -0,0 +1,127 @@
+package alert
+
+import (
+	"github.com/thecubic/miao2go"
+	"math"
+	"testing"
+	"time"
+)
+
+// line is a reading a minute for minutes up to now, glucose at now plus rate
+// a minute
+func line(now time.Time, minutes int, glucose, rate float64) []miao2go.GlucoseReading {
+	var readings []miao2go.GlucoseReading
+	for ago := 0; ago < minutes; ago++ {
+		readings = append(readings, miao2go.GlucoseReading{
+			Serial: "0M0001", Kind: miao2go.TrendReading,
+			Time: now.Add(-time.Duration(ago) * time.Minute), Glucose: glucose - rate*float64(ago), Raw: 1000})
+	}
+	return readings
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 0.01
+}
+
+func TestPredict(t *testing.T) {
+	now := time.Date(2018, 9, 25, 10, 0, 0, 0, time.UTC)
+	later := line(now.Add(5*time.Minute), 5, 0, 0)
+	for _, tc := range []struct {
+		name     string
+		readings []miao2go.GlucoseReading
+		ok       bool
+		glucose  float64
+		rate     float64
+		points   int
+	}{
+		{"flat", line(now, 15, 100, 0), true, 100, 0, 15},
+		{"falling", line(now, 15, 100, -2), true, 60, -2, 15},
+		{"rising", line(now, 15, 100, 1.5), true, 130, 1.5, 15},
+		{"too few", line(now, 4, 100, -2), false, 0, 0, 0},
+		{"only the lookback", line(now, 60, 100, -1), true, 80, -1, 16},
+		{"nothing from the future", append(line(now, 10, 100, -1), later...), true, 80, -1, 10},
+		{"duplicate minutes once", append(line(now, 10, 100, -1), line(now, 10, 100, -1)...), true, 80, -1, 10},
+	} {
+		forecast, ok := Predict(tc.readings, now, 15*time.Minute, 20*time.Minute)
+		if ok != tc.ok {
+			t.Errorf("%v: got ok %v, want %v", tc.name, ok, tc.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if !near(forecast.Glucose, tc.glucose) || !near(forecast.Rate, tc.rate) || forecast.Points != tc.points {
+			t.Errorf("%v: got %.2f at %+.2f from %v points, want %.2f at %+.2f from %v",
+				tc.name, forecast.Glucose, forecast.Rate, forecast.Points, tc.glucose, tc.rate, tc.points)
+		}
+		if !near(forecast.StdErr, 0) || !forecast.Time.Equal(now.Add(20*time.Minute)) {
+			t.Errorf("%v: got error %v at %v for a straight line", tc.name, forecast.StdErr, forecast.Time)
+		}
+	}
+}
+
+func TestPredictNoisyHasError(t *testing.T) {
+	now := time.Date(2018, 9, 25, 10, 0, 0, 0, time.UTC)
+	readings := line(now, 15, 100, 0)
+	for idx := range readings {
+		readings[idx].Glucose += float64(idx%2*6 - 3)
+	}
+	forecast, ok := Predict(readings, now, 15*time.Minute, 20*time.Minute)
+	if !ok || forecast.StdErr <= 3 {
+		t.Errorf("got error %v (%v), want more than the noise", forecast.StdErr, ok)
+	}
+}
+
+func TestBound(t *testing.T) {
+	forecast := Forecast{Glucose: 100, StdErr: 10}
+	for _, tc := range []struct {
+		confidence float64
+		want       float64
+	}{
+		{0.5, 100},
+		{0.8413, 110},
+		{0.975, 119.6},
+		{0.025, 80.4},
+	} {
+		if got := forecast.Bound(tc.confidence); math.Abs(got-tc.want) > 0.05 {
+			t.Errorf("Bound(%v) = %.2f, want %v", tc.confidence, got, tc.want)
+		}
+	}
+	lower, upper := forecast.Interval(0.95)
+	if math.Abs(lower-80.4) > 0.05 || math.Abs(upper-119.6) > 0.05 {
+		t.Errorf("Interval(0.95) = %.2f to %.2f, want 80.4 to 119.6", lower, upper)
+	}
+}
+
+func TestBacktestDip(t *testing.T) {
+	start := time.Date(2018, 9, 25, 10, 0, 0, 0, time.UTC)
+	// an hour at 120, down 2 a minute to 60, half an hour there, and back up
+	var readings []miao2go.GlucoseReading
+	glucose := 120.0
+	for minute := 0; minute < 180; minute++ {
+		switch {
+		case minute >= 60 && minute < 90:
+			glucose -= 2
+		case minute >= 120 && minute < 150:
+			glucose += 2
+		}
+		readings = append(readings, miao2go.GlucoseReading{
+			Serial: "0M0001", Kind: miao2go.TrendReading,
+			Time: start.Add(time.Duration(minute) * time.Minute), Glucose: glucose, Raw: 1000})
+	}
+	rule := Rule{Threshold: 70, Hysteresis: 5, Lookback: 15 * time.Minute, Ahead: 20 * time.Minute, Confidence: 0.5}
+	result := Backtest(readings, rule)
+	if result.Lows != 1 || result.Caught != 1 {
+		t.Fatalf("got %v lows with %v caught, want the one caught: %v", result.Lows, result.Caught, result)
+	}
+	if result.Alerts != 1 || result.Hits != 1 {
+		t.Errorf("got %v alerts, %v hits, want one that hit", result.Alerts, result.Hits)
+	}
+	// it goes under 70 at 85 minutes; a straight line sees that coming
+	if result.Lead < 10*time.Minute || result.Lead > rule.Ahead {
+		t.Errorf("got lead %v, want 10m to %v", result.Lead, rule.Ahead)
+	}
+	if result.Forecasts == 0 || result.Error <= 0 {
+		t.Errorf("got %v forecasts off by %v, want some off by something", result.Forecasts, result.Error)
+	}
+}
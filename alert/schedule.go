@@ -0,0 +1,213 @@
+package alert
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Profile changes the rules, and who's told, at some times of the week:
+// nights, say, or school hours
+type Profile struct {
+	Name string `yaml:"name"`
+	// Days are the weekdays (mon, tue, ...) the window starts on; every
+	// day if there are none
+	Days []string `yaml:"days"`
+	// From and To bound the window, as 15:04 in the config's timezone.  A
+	// window that ends before it starts runs over midnight, and no window
+	// at all is the whole day
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	// Rules override the config's, field by field where they're set
+	Rules `yaml:",inline"`
+	// Quiet are kinds of alert nobody's told about in the window; they
+	// still fire, and are told about once it's over if they're still on
+	Quiet []Kind `yaml:"quiet"`
+	// Notify is who's told in the window, by notifier name, instead of
+	// the config's Notify
+	Notify []string `yaml:"notify"`
+}
+
+// Escalation is a step in the chain for urgent alerts nobody acknowledges
+type Escalation struct {
+	// After is how long after the alert first fired
+	After time.Duration `yaml:"after"`
+	// Notify is who's told, by notifier name
+	Notify []string `yaml:"notify"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// weekday parses a day name, abbreviated or not
+func weekday(name string) (time.Weekday, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if len(name) >= 3 {
+		if day, ok := weekdays[name[:3]]; ok && strings.HasPrefix(strings.ToLower(day.String()), name) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q", name)
+}
+
+// clock parses 15:04 as minutes into the day
+func clock(value string) (int, error) {
+	at, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("bad time of day %q", value)
+	}
+	return at.Hour()*60 + at.Minute(), nil
+}
+
+// Validate checks a profile's window makes sense
+func (profile *Profile) Validate() error {
+	for _, day := range profile.Days {
+		if _, err := weekday(day); err != nil {
+			return err
+		}
+	}
+	if (len(profile.From) == 0) != (len(profile.To) == 0) {
+		return fmt.Errorf("needs both from and to, or neither")
+	}
+	if len(profile.From) > 0 {
+		from, err := clock(profile.From)
+		if err != nil {
+			return err
+		}
+		to, err := clock(profile.To)
+		if err != nil {
+			return err
+		}
+		if from == to {
+			return fmt.Errorf("from and to are the same")
+		}
+	}
+	for _, kind := range profile.Quiet {
+		if _, ok := profile.rules()[kind]; !ok {
+			return fmt.Errorf("can't quiet unknown alert %q", kind)
+		}
+		if kind == UrgentLow {
+			return fmt.Errorf("urgent low can't be quieted")
+		}
+	}
+	return nil
+}
+
+// on is whether a profile's days include the one a time falls on
+func (profile *Profile) on(when time.Time) bool {
+	if len(profile.Days) == 0 {
+		return true
+	}
+	for _, name := range profile.Days {
+		if day, err := weekday(name); err == nil && day == when.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// Active is whether a profile's window covers a time, as a clock in the
+// profile's timezone reads it
+func (profile *Profile) Active(when time.Time) bool {
+	if len(profile.From) == 0 {
+		return profile.on(when)
+	}
+	from, _ := clock(profile.From)
+	to, _ := clock(profile.To)
+	minute := when.Hour()*60 + when.Minute()
+	if from < to {
+		return from <= minute && minute < to && profile.on(when)
+	}
+	// over midnight, the early hours belong to the day before
+	return (minute >= from && profile.on(when)) || (minute < to && profile.on(when.AddDate(0, 0, -1)))
+}
+
+// quiet is whether a profile quiets a kind of alert
+func (profile *Profile) quiet(kind Kind) bool {
+	for _, quiet := range profile.Quiet {
+		if quiet == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// over is a rule with the fields set in an override replacing its own
+func (rule Rule) over(override Rule) Rule {
+	if override.Threshold > 0 {
+		rule.Threshold = override.Threshold
+	}
+	if override.Hysteresis > 0 {
+		rule.Hysteresis = override.Hysteresis
+	}
+	if override.After > 0 {
+		rule.After = override.After
+	}
+	if override.Realert > 0 {
+		rule.Realert = override.Realert
+	}
+	if override.Snooze > 0 {
+		rule.Snooze = override.Snooze
+	}
+	if override.Ahead > 0 {
+		rule.Ahead = override.Ahead
+	}
+	if override.Lookback > 0 {
+		rule.Lookback = override.Lookback
+	}
+	if override.Confidence > 0 {
+		rule.Confidence = override.Confidence
+	}
+	return rule
+}
+
+// schedule is what's in force at some moment: the rules, and the profile
+// they came from if any
+type schedule struct {
+	Rules
+	profile *Profile
+	notify  []string
+}
+
+// location is the timezone profiles' windows are in
+func (config *Config) location() (*time.Location, error) {
+	if len(config.Timezone) == 0 {
+		return time.Local, nil
+	}
+	return time.LoadLocation(config.Timezone)
+}
+
+// at is the schedule in force at a time: the first profile whose window
+// covers it over the config's rules
+func (config *Config) at(when time.Time, location *time.Location) schedule {
+	at := schedule{Rules: config.Rules, notify: config.Notify}
+	when = when.In(location)
+	for idx := range config.Profiles {
+		profile := &config.Profiles[idx]
+		if !profile.Active(when) {
+			continue
+		}
+		at.profile = profile
+		rules, overrides := at.rules(), profile.rules()
+		for kind, rule := range rules {
+			*rule = rule.over(*overrides[kind])
+		}
+		if len(profile.Notify) > 0 {
+			at.notify = profile.Notify
+		}
+		break
+	}
+	return at
+}
+
+// quiet is whether a kind of alert is quiet in this schedule
+func (at schedule) quiet(kind Kind) bool {
+	return at.profile != nil && at.profile.quiet(kind)
+}
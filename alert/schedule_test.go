@@ -0,0 +1,94 @@
+package alert
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProfileActive(t *testing.T) {
+	// 2018-09-28 is a Friday, and the 31st is the Monday after
+	at := func(day int, clock string) time.Time {
+		when, err := time.Parse("15:04", clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Date(2018, time.September, day, when.Hour(), when.Minute(), 0, 0, time.UTC)
+	}
+	nights := &Profile{From: "22:00", To: "07:00"}
+	schoolNights := &Profile{Days: []string{"sun", "mon", "tue", "wed", "thu"}, From: "21:30", To: "06:30"}
+	school := &Profile{Days: []string{"monday", "Tue", "wed", "thu", "fri"}, From: "08:30", To: "15:00"}
+	weekends := &Profile{Days: []string{"sat", "sun"}}
+	for _, tc := range []struct {
+		name    string
+		profile *Profile
+		when    time.Time
+		want    bool
+	}{
+		{"nights before", nights, at(28, "21:59"), false},
+		{"nights from", nights, at(28, "22:00"), true},
+		{"nights past midnight", nights, at(29, "03:00"), true},
+		{"nights to", nights, at(29, "07:00"), false},
+		{"nights midday", nights, at(29, "12:00"), false},
+		{"thursday night", schoolNights, at(27, "23:00"), true},
+		{"thursday night, friday morning", schoolNights, at(28, "05:00"), true},
+		{"friday night", schoolNights, at(28, "23:00"), false},
+		{"friday night, saturday morning", schoolNights, at(29, "05:00"), false},
+		{"saturday night, sunday morning", schoolNights, at(30, "05:00"), false},
+		{"sunday night, monday morning", schoolNights, at(31, "05:00"), true},
+		{"school friday", school, at(28, "10:00"), true},
+		{"school saturday", school, at(29, "10:00"), false},
+		{"school after", school, at(28, "15:00"), false},
+		{"weekend all day", weekends, at(29, "00:00"), true},
+		{"not the weekend", weekends, at(28, "23:59"), false},
+	} {
+		if got := tc.profile.Active(tc.when); got != tc.want {
+			t.Errorf("%v: %v active %v, want %v", tc.name, tc.when.Format("Mon 15:04"), got, tc.want)
+		}
+	}
+}
+
+func TestProfileValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		profile Profile
+		ok      bool
+	}{
+		{"window", Profile{From: "22:00", To: "07:00"}, true},
+		{"whole days", Profile{Days: []string{"Saturday", "sun"}}, true},
+		{"half a window", Profile{From: "22:00"}, false},
+		{"empty window", Profile{From: "22:00", To: "22:00"}, false},
+		{"bad time", Profile{From: "25:00", To: "07:00"}, false},
+		{"bad day", Profile{Days: []string{"someday"}}, false},
+		{"quiet urgent low", Profile{Quiet: []Kind{UrgentLow}}, false},
+		{"quiet high", Profile{Quiet: []Kind{High}}, true},
+	} {
+		if err := tc.profile.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%v: got %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestScheduleAt(t *testing.T) {
+	config := &Config{
+		Rules:  Rules{High: Rule{Threshold: 180, Realert: time.Hour}},
+		Notify: []string{"everyone"},
+		Profiles: []Profile{{
+			From: "22:00", To: "07:00",
+			Rules:  Rules{High: Rule{Threshold: 250}},
+			Quiet:  []Kind{Rising},
+			Notify: []string{"bedside"},
+		}},
+	}
+	night := config.at(time.Date(2018, 9, 28, 23, 0, 0, 0, time.UTC), time.UTC)
+	if night.High.Threshold != 250 || night.High.Realert != time.Hour || !night.quiet(Rising) || night.notify[0] != "bedside" {
+		t.Errorf("at night got %+v, quiet rising %v, notify %v", night.High, night.quiet(Rising), night.notify)
+	}
+	day := config.at(time.Date(2018, 9, 28, 12, 0, 0, 0, time.UTC), time.UTC)
+	if day.High.Threshold != 180 || day.quiet(Rising) || day.notify[0] != "everyone" {
+		t.Errorf("by day got %+v, quiet rising %v, notify %v", day.High, day.quiet(Rising), day.notify)
+	}
+	// the profile doesn't leak into the config
+	if config.High.Threshold != 180 {
+		t.Errorf("config's high is now %v", config.High.Threshold)
+	}
+}
@@ -0,0 +1,115 @@
+package alert
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// authorized is whether a request may acknowledge or snooze: it carries
+// the config's token, or there isn't one and it's from this machine
+func (engine *Engine) authorized(r *http.Request) bool {
+	if token := engine.config.Token; len(token) > 0 {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServeHTTP lets people see what's firing and acknowledge or snooze it:
+//
+//	GET  /alerts                              what's firing, as JSON
+//	POST /ack?id=<id>                         acknowledge an alert
+//	POST /snooze?kind=<kind>&serial=<serial>  snooze an alert, for=<duration>
+//	                                          if not as long as its rule says
+//
+// Acknowledging and snoozing need the config's token, as a bearer token,
+// or without one have to come from this machine
+func (engine *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/alerts":
+		w.Header().Set("Content-Type", "application/json")
+		alerts := engine.Firing()
+		if alerts == nil {
+			alerts = []Alert{}
+		}
+		if err := json.NewEncoder(w).Encode(alerts); err != nil {
+			log.Printf("couldn't send alerts: %v", err)
+		}
+		return
+	case "/ack", "/snooze":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if !engine.authorized(r) {
+		http.Error(w, "not allowed", http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	if r.URL.Path == "/ack" {
+		if !engine.Ack(query.Get("id")) {
+			http.Error(w, "no such alert firing", http.StatusNotFound)
+			return
+		}
+		log.Printf("alert %v acknowledged", query.Get("id"))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	kind := Kind(query.Get("kind"))
+	if _, ok := engine.config.rules()[kind]; !ok {
+		http.Error(w, "unknown kind", http.StatusBadRequest)
+		return
+	}
+	var duration time.Duration
+	if value := query.Get("for"); len(value) > 0 {
+		var err error
+		if duration, err = time.ParseDuration(value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	serial := query.Get("serial")
+	if len(serial) == 0 {
+		http.Error(w, "no serial", http.StatusBadRequest)
+		return
+	}
+	if !engine.Snooze(kind, serial, duration) {
+		http.Error(w, "no such sensor", http.StatusNotFound)
+		return
+	}
+	log.Printf("alert %v/%v snoozed", kind, serial)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Listen serves the engine over HTTP on an address until it's closed
+func (engine *Engine) Listen(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: engine}
+	engine.mu.Lock()
+	engine.server, engine.listener = server, listener
+	engine.mu.Unlock()
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Printf("alert server stopped: %v", err)
+		}
+	}()
+	log.Printf("taking alert acknowledgements on %v", listener.Addr())
+	return nil
+}
@@ -0,0 +1,45 @@
+package alert
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		token  string
+		path   string
+		remote string
+		bearer string
+		status int
+	}{
+		{name: "snooze from localhost", path: "/snooze?kind=low&serial=S1", remote: "127.0.0.1:5000", status: http.StatusNoContent},
+		{name: "snooze from elsewhere", path: "/snooze?kind=low&serial=S1", remote: "192.0.2.1:5000", status: http.StatusUnauthorized},
+		{name: "snooze with the token", token: "sekrit", path: "/snooze?kind=low&serial=S1", remote: "192.0.2.1:5000", bearer: "sekrit", status: http.StatusNoContent},
+		{name: "snooze with the wrong token", token: "sekrit", path: "/snooze?kind=low&serial=S1", remote: "127.0.0.1:5000", bearer: "guess", status: http.StatusUnauthorized},
+		{name: "ack without the token", token: "sekrit", path: "/ack?id=nope", remote: "127.0.0.1:5000", status: http.StatusUnauthorized},
+		{name: "ack nothing firing", path: "/ack?id=nope", remote: "[::1]:5000", status: http.StatusNotFound},
+		{name: "snooze no serial", path: "/snooze?kind=low", remote: "127.0.0.1:5000", status: http.StatusBadRequest},
+		{name: "snooze unknown serial", path: "/snooze?kind=low&serial=S2", remote: "127.0.0.1:5000", status: http.StatusNotFound},
+		{name: "snooze unknown kind", path: "/snooze?kind=sideways&serial=S1", remote: "127.0.0.1:5000", status: http.StatusBadRequest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			engine := NewEngine(&Config{Rules: Rules{Low: Rule{Threshold: 70}}, Token: test.token})
+			defer engine.Close()
+			engine.WriteReading(reading("S1", "", time.Now(), 100))
+			r := httptest.NewRequest("POST", test.path, nil)
+			r.RemoteAddr = test.remote
+			if len(test.bearer) > 0 {
+				r.Header.Set("Authorization", "Bearer "+test.bearer)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("got %v, want %v", w.Code, test.status)
+			}
+		})
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"github.com/currantlabs/ble"
 	"log"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -11,8 +14,16 @@ var (
 	nrfData = ble.MustParse("6E400001-B5A3-F393-E0A9-E50E24DCCA9E")
 	nrfRecv = ble.MustParse("6E400002-B5A3-F393-E0A9-E50E24DCCA9E")
 	nrfXmit = ble.MustParse("6E400003-B5A3-F393-E0A9-E50E24DCCA9E")
+
+	macAddress = regexp.MustCompile(`^([0-9a-fA-F]{2}:){5}[0-9a-fA-F]{2}$`)
 )
 
+// miaoLocalName is what a miaomiao advertises itself as
+const miaoLocalName = "miaomiao"
+
+// SelectFirst is the selector meaning "whichever miaomiao shows up first"
+const SelectFirst = "first"
+
 // MiaoBluetoothState represents the percieved BLE state of the device
 type MiaoBluetoothState int
 
@@ -22,6 +33,7 @@ const (
 	MSConnected     MiaoBluetoothState = 1
 	MSSubscribed    MiaoBluetoothState = 2
 	MSBeingNotified MiaoBluetoothState = 3
+	MSDisconnected  MiaoBluetoothState = 4
 )
 
 // gattResponsePacket is a direct representation of a BLE read
@@ -30,21 +42,44 @@ type gattResponsePacket struct {
 	time time.Time
 }
 
-// ConnectedMiao represents a BLE connection to a miaomiao
+// gattClient is the part of a BLE connection a miaomiao actually needs,
+// which lets a recording stand in for the real thing
+type gattClient interface {
+	Address() ble.Addr
+	Subscribe(c *ble.Characteristic, ind bool, h ble.NotificationHandler) error
+	WriteDescriptor(d *ble.Descriptor, v []byte) error
+	WriteCharacteristic(c *ble.Characteristic, v []byte, noRsp bool) error
+}
+
+// ConnectedMiao represents a BLE connection to a miaomiao.  Its state is
+// updated from the BLE stack's goroutine, so read it through the accessors
 type ConnectedMiao struct {
-	client         ble.Client
+	client         gattClient
 	nrfDataService *ble.Service
 	nrfRecvChar    *ble.Characteristic
 	nrfXmitChar    *ble.Characteristic
 	clientDesc     *ble.Descriptor
-	BtState        MiaoBluetoothState
-	DevState       MiaoDeviceState
 	datachan       chan gattResponsePacket
-	LastEmit       time.Time
-	NextEmit       time.Time
-	emitInterval   time.Duration
+	hangup         chan struct{}
+	registry       *Registry
+	recorder       *Recorder
+
+	// mu guards everything below
+	mu           sync.Mutex
+	btState      MiaoBluetoothState
+	devState     MiaoDeviceState
+	lastEmit     time.Time
+	nextEmit     time.Time
+	emitInterval time.Duration
+	hooks        []TransitionHook
+	queueStats   ChunkQueueStats
 }
 
+// ChunkQueueSize is how many GATT chunks a miaomiao attached from here on
+// will hold for MiaoResponse before dropping them; a full sensor response
+// is about twenty
+var ChunkQueueSize = 128
+
 // AttachBTLE creates a connection descriptor for a miaomiao based on input
 // of a legitimate BLE-layer connected device.  It will fail if you give it
 // a BT mouse or whatever
@@ -90,17 +125,90 @@ func AttachBTLE(blec ble.Client) (*ConnectedMiao, error) {
 		return nil, fmt.Errorf("miaoClientDesc missing")
 	}
 	// we're in business!
+	lcm := newConnectedMiao(blec, nrfDataService, nrfDataRecv, nrfDataXmit, miaoClientDesc)
+	lcm.hangup = make(chan struct{})
+	go func() {
+		<-blec.Disconnected()
+		lcm.setBtState(MSDisconnected, time.Now())
+		// the BLE stack may yet call back, so datachan stays open
+		close(lcm.hangup)
+	}()
+	return lcm, nil
+}
+
+func newConnectedMiao(client gattClient, service *ble.Service, recv *ble.Characteristic, xmit *ble.Characteristic, desc *ble.Descriptor) *ConnectedMiao {
 	return &ConnectedMiao{
-		blec,
-		nrfDataService,
-		nrfDataRecv,
-		nrfDataXmit,
-		miaoClientDesc,
-		MSConnected,
-		MPDeclared,
-		make(chan gattResponsePacket),
-		zeroTime,
-		zeroTime,
-		zeroDuration,
-	}, nil
+		client:         client,
+		nrfDataService: service,
+		nrfRecvChar:    recv,
+		nrfXmitChar:    xmit,
+		clientDesc:     desc,
+		datachan:       make(chan gattResponsePacket, ChunkQueueSize),
+		btState:        MSConnected,
+		devState:       MPDeclared,
+		lastEmit:       zeroTime,
+		nextEmit:       zeroTime,
+		emitInterval:   zeroDuration,
+	}
+}
+
+// writeRecv writes a command to the miaomiao
+func (lcm *ConnectedMiao) writeRecv(data []byte) error {
+	if lcm.recorder != nil {
+		lcm.recorder.Record(RecordWrite, targetRecv, data)
+	}
+	return lcm.client.WriteCharacteristic(lcm.nrfRecvChar, data, false)
+}
+
+// writeDesc writes the notification config descriptor of the miaomiao
+func (lcm *ConnectedMiao) writeDesc(data []byte) error {
+	if lcm.recorder != nil {
+		lcm.recorder.Record(RecordWrite, targetDesc, data)
+	}
+	return lcm.client.WriteDescriptor(lcm.clientDesc, data)
+}
+
+// Address is the BLE address of the connected miaomiao
+func (lcm *ConnectedMiao) Address() string {
+	return strings.ToLower(lcm.client.Address().String())
+}
+
+// UseRegistry makes readings from this miaomiao update the registry
+// with the sensor serial it's reporting
+func (lcm *ConnectedMiao) UseRegistry(reg *Registry) {
+	lcm.registry = reg
+}
+
+// MiaoFilter builds an advertisement filter out of a selector, which can be
+// a BLE address, an alias or sensor serial known to the registry, an
+// advertised name, or empty / SelectFirst for the first miaomiao found.
+// reg may be nil, in which case aliases and serials won't resolve
+func MiaoFilter(selector string, reg *Registry) ble.AdvFilter {
+	target := ""
+	if macAddress.MatchString(selector) {
+		target = strings.ToLower(selector)
+	} else if reg != nil && len(selector) > 0 {
+		if ent := reg.Lookup(selector); ent != nil {
+			target = ent.Address
+		}
+	}
+	return func(adv ble.Advertisement) bool {
+		address := strings.ToLower(adv.Address().String())
+		name := adv.LocalName()
+		isMiao := strings.HasPrefix(strings.ToLower(name), miaoLocalName)
+		if isMiao {
+			log.Printf("found a miao: %v", address)
+			if reg != nil {
+				reg.SawAdvertisement(address, name)
+			}
+		}
+		switch {
+		case len(target) > 0:
+			return address == target
+		case len(selector) == 0 || selector == SelectFirst:
+			return isMiao
+		default:
+			return len(name) > 0 && name == selector
+		}
+	}
 }
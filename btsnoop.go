@@ -0,0 +1,345 @@
+package miao2go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// btsnoop datalink types we understand
+const (
+	btsnoopHCI  = 1001
+	btsnoopUART = 1002
+)
+
+// H4 packet indicators
+const (
+	hciCommand = 0x01
+	hciACL     = 0x02
+	hciEvent   = 0x04
+)
+
+// ATT opcodes of interest
+const (
+	attFindInfoResp   = 0x05
+	attReadByTypeResp = 0x09
+	attWriteReq       = 0x12
+	attNotify         = 0x1b
+	attWriteCmd       = 0x52
+)
+
+const (
+	// l2capATT is the fixed L2CAP channel that carries ATT
+	l2capATT = 0x0004
+	// btsnoopEpochDelta is 1970-01-01 in btsnoop's microseconds since 0 AD
+	btsnoopEpochDelta = 0x00dcddb30f2f8000
+	// gattCCCD is the client characteristic configuration descriptor
+	gattCCCD = 0x2902
+	// btsnoopMaxRecord is more than any HCI packet can be; a record
+	// claiming more is corrupt
+	btsnoopMaxRecord = 64 * 1024
+)
+
+var btsnoopMagic = []byte("btsnoop\x00")
+
+// attUUID renders a textual UUID in the little-endian order ATT uses
+func attUUID(uuid string) []byte {
+	data, _ := hex.DecodeString(strings.Replace(uuid, "-", "", -1))
+	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+		data[i], data[j] = data[j], data[i]
+	}
+	return data
+}
+
+var (
+	attNrfRecv = attUUID("6E400002-B5A3-F393-E0A9-E50E24DCCA9E")
+	attNrfXmit = attUUID("6E400003-B5A3-F393-E0A9-E50E24DCCA9E")
+)
+
+// btsnoopConn is what we've learned about one ACL connection
+type btsnoopConn struct {
+	// address is who the connection is to, made when and attachAt where
+	// its attach goes in the events once it turns out to be a miaomiao
+	address   string
+	connected time.Time
+	attachAt  int
+	attached  bool
+
+	recvHandle uint16
+	xmitHandle uint16
+	descHandle uint16
+	// ACL fragments being reassembled into L2CAP, per direction
+	pending [2][]byte
+}
+
+// btsnoopReader walks a btsnoop capture, turning miaomiao ATT traffic
+// into recorded events
+type btsnoopReader struct {
+	datalink uint32
+	conns    map[uint16]*btsnoopConn
+	events   []RecordedEvent
+}
+
+// ReadBtsnoop extracts miaomiao traffic from a btsnoop HCI log, as written
+// by Android's "Bluetooth HCI snoop log", into a recording for ReplayMiao.
+// Characteristic handles are learned from GATT discovery in the capture;
+// failing that, they are guessed from what the traffic looks like
+func ReadBtsnoop(r io.Reader) ([]RecordedEvent, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("couldn't read btsnoop header: %v", err)
+	}
+	if !bytes.Equal(header[0:8], btsnoopMagic) {
+		return nil, fmt.Errorf("not a btsnoop file")
+	}
+	if version := binary.BigEndian.Uint32(header[8:12]); version != 1 {
+		return nil, fmt.Errorf("unsupported btsnoop version %v", version)
+	}
+	bsr := &btsnoopReader{
+		datalink: binary.BigEndian.Uint32(header[12:16]),
+		conns:    make(map[uint16]*btsnoopConn),
+	}
+	if bsr.datalink != btsnoopHCI && bsr.datalink != btsnoopUART {
+		return nil, fmt.Errorf("unsupported btsnoop datalink %v", bsr.datalink)
+	}
+	record := make([]byte, 24)
+	for {
+		_, err := io.ReadFull(r, record)
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			// captures cut off mid-record are par for the course
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		original := binary.BigEndian.Uint32(record[0:4])
+		included := binary.BigEndian.Uint32(record[4:8])
+		if included > original || included > btsnoopMaxRecord {
+			return nil, fmt.Errorf("corrupt btsnoop record of %v bytes (%v originally)", included, original)
+		}
+		flags := binary.BigEndian.Uint32(record[8:12])
+		micros := int64(binary.BigEndian.Uint64(record[16:24])) - btsnoopEpochDelta
+		packet := make([]byte, included)
+		if _, err = io.ReadFull(r, packet); err != nil {
+			break
+		}
+		bsr.packet(time.Unix(0, micros*int64(time.Microsecond)), flags, packet)
+	}
+	return bsr.events, nil
+}
+
+// OpenBtsnoop extracts miaomiao traffic from a btsnoop file
+func OpenBtsnoop(path string) ([]RecordedEvent, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return ReadBtsnoop(fh)
+}
+
+func (bsr *btsnoopReader) conn(handle uint16) *btsnoopConn {
+	conn, ok := bsr.conns[handle]
+	if !ok {
+		conn = &btsnoopConn{}
+		bsr.conns[handle] = conn
+	}
+	return conn
+}
+
+func (bsr *btsnoopReader) emit(when time.Time, kind RecordKind, target string, data []byte) {
+	copied := make(HexBytes, len(data))
+	copy(copied, data)
+	bsr.events = append(bsr.events, RecordedEvent{when, kind, target, copied})
+}
+
+// packet handles a single HCI packet
+func (bsr *btsnoopReader) packet(when time.Time, flags uint32, packet []byte) {
+	received := flags&0x01 == 0x01
+	var kind byte
+	if bsr.datalink == btsnoopUART {
+		if len(packet) < 1 {
+			return
+		}
+		kind, packet = packet[0], packet[1:]
+	} else if flags&0x02 == 0x02 {
+		kind = hciCommand
+		if received {
+			kind = hciEvent
+		}
+	} else {
+		kind = hciACL
+	}
+	switch kind {
+	case hciEvent:
+		bsr.event(when, packet)
+	case hciACL:
+		bsr.acl(when, received, packet)
+	}
+}
+
+// event picks up LE connections so we know who we're talking to
+func (bsr *btsnoopReader) event(when time.Time, packet []byte) {
+	// LE meta event, connection complete or enhanced connection complete
+	if len(packet) < 14 || packet[0] != 0x3e || (packet[2] != 0x01 && packet[2] != 0x0a) {
+		return
+	}
+	if packet[3] != 0x00 {
+		// connection failed
+		return
+	}
+	handle := binary.LittleEndian.Uint16(packet[4:6]) & 0x0fff
+	addr := packet[8:14]
+	bsr.conns[handle] = &btsnoopConn{
+		address:   fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", addr[5], addr[4], addr[3], addr[2], addr[1], addr[0]),
+		connected: when,
+		// events of other connections may come in between; they're later
+		attachAt: len(bsr.events),
+	}
+}
+
+// miao notes a connection is to a miaomiao, now that it's carried NRF UART
+// traffic, by putting its attach where the connection was made
+func (bsr *btsnoopReader) miao(conn *btsnoopConn) {
+	if conn.attached || len(conn.address) == 0 {
+		return
+	}
+	conn.attached = true
+	attach := RecordedEvent{conn.connected, RecordAttach, conn.address, HexBytes{}}
+	bsr.events = append(bsr.events, RecordedEvent{})
+	copy(bsr.events[conn.attachAt+1:], bsr.events[conn.attachAt:])
+	bsr.events[conn.attachAt] = attach
+	// later connections' attaches have moved along one
+	for _, other := range bsr.conns {
+		if other != conn && !other.attached && other.attachAt >= conn.attachAt {
+			other.attachAt++
+		}
+	}
+}
+
+// acl reassembles L2CAP out of ACL fragments, passing on ATT
+func (bsr *btsnoopReader) acl(when time.Time, received bool, packet []byte) {
+	if len(packet) < 4 {
+		return
+	}
+	handle := binary.LittleEndian.Uint16(packet[0:2]) & 0x0fff
+	boundary := (packet[1] >> 4) & 0x03
+	conn := bsr.conn(handle)
+	direction := 0
+	if received {
+		direction = 1
+	}
+	data := packet[4:]
+	if boundary == 0x01 {
+		// continuation of whatever we were putting together
+		if conn.pending[direction] == nil {
+			return
+		}
+		conn.pending[direction] = append(conn.pending[direction], data...)
+	} else {
+		conn.pending[direction] = append([]byte{}, data...)
+	}
+	l2cap := conn.pending[direction]
+	if len(l2cap) < 4 {
+		return
+	}
+	length := int(binary.LittleEndian.Uint16(l2cap[0:2]))
+	if len(l2cap)-4 < length {
+		// more fragments to come
+		return
+	}
+	conn.pending[direction] = nil
+	if binary.LittleEndian.Uint16(l2cap[2:4]) == l2capATT {
+		bsr.att(when, conn, l2cap[4:4+length])
+	}
+}
+
+// att turns the ATT PDUs we care about into recorded events
+func (bsr *btsnoopReader) att(when time.Time, conn *btsnoopConn, pdu []byte) {
+	if len(pdu) < 1 {
+		return
+	}
+	switch pdu[0] {
+	case attReadByTypeResp:
+		conn.discoverCharacteristics(pdu[1:])
+	case attFindInfoResp:
+		conn.discoverDescriptors(pdu[1:])
+	case attNotify:
+		if len(pdu) < 3 {
+			return
+		}
+		handle, value := binary.LittleEndian.Uint16(pdu[1:3]), pdu[3:]
+		if conn.xmitHandle == 0 && len(value) > 0 {
+			// no discovery captured; lock on to whatever looks like a miaomiao
+			switch MiaoDeviceState(value[0]) {
+			case MPLibre, MPNewSensor, MPNoSensor:
+				conn.xmitHandle = handle
+			}
+		}
+		if handle == conn.xmitHandle {
+			bsr.miao(conn)
+			bsr.emit(when, RecordNotify, targetXmit, value)
+		}
+	case attWriteReq, attWriteCmd:
+		if len(pdu) < 3 {
+			return
+		}
+		handle, value := binary.LittleEndian.Uint16(pdu[1:3]), pdu[3:]
+		if conn.recvHandle == 0 && len(value) > 0 {
+			switch value[0] {
+			case 0xf0, 0xd3, 0xd1:
+				conn.recvHandle = handle
+			}
+		}
+		if handle == conn.recvHandle {
+			bsr.miao(conn)
+			bsr.emit(when, RecordWrite, targetRecv, value)
+		} else if handle == conn.descHandle || (conn.descHandle == 0 && conn.xmitHandle != 0 && handle == conn.xmitHandle+1) {
+			bsr.miao(conn)
+			bsr.emit(when, RecordWrite, targetDesc, value)
+		}
+	}
+}
+
+// discoverCharacteristics learns value handles from a Read By Type response
+// to a characteristic declaration discovery
+func (conn *btsnoopConn) discoverCharacteristics(data []byte) {
+	if len(data) < 1 {
+		return
+	}
+	size := int(data[0])
+	if size < 7 {
+		return
+	}
+	for entry := data[1:]; len(entry) >= size; entry = entry[size:] {
+		valueHandle := binary.LittleEndian.Uint16(entry[3:5])
+		uuid := entry[5:size]
+		if bytes.Equal(uuid, attNrfRecv) {
+			conn.recvHandle = valueHandle
+		} else if bytes.Equal(uuid, attNrfXmit) {
+			conn.xmitHandle = valueHandle
+		}
+	}
+}
+
+// discoverDescriptors finds the CCCD following the xmit characteristic
+func (conn *btsnoopConn) discoverDescriptors(data []byte) {
+	// only 16-bit UUIDs (format 1) can be a CCCD
+	if len(data) < 1 || data[0] != 0x01 || conn.xmitHandle == 0 {
+		return
+	}
+	for entry := data[1:]; len(entry) >= 4; entry = entry[4:] {
+		handle := binary.LittleEndian.Uint16(entry[0:2])
+		if binary.LittleEndian.Uint16(entry[2:4]) == gattCCCD && handle > conn.xmitHandle {
+			if conn.descHandle == 0 || handle < conn.descHandle {
+				conn.descHandle = handle
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package miao2go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// btsnoopFile builds a capture out of (flags, packet) records
+func btsnoopFile(records ...[]byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(btsnoopMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(1))
+	binary.Write(&buf, binary.BigEndian, uint32(btsnoopHCI))
+	when := uint64(btsnoopEpochDelta + time.Date(2018, 9, 25, 10, 0, 0, 0, time.UTC).UnixNano()/1000)
+	for idx := 0; idx < len(records); idx += 2 {
+		flags, packet := records[idx], records[idx+1]
+		binary.Write(&buf, binary.BigEndian, uint32(len(packet)))
+		binary.Write(&buf, binary.BigEndian, uint32(len(packet)))
+		binary.Write(&buf, binary.BigEndian, uint32(flags[0]))
+		binary.Write(&buf, binary.BigEndian, uint32(0))
+		binary.Write(&buf, binary.BigEndian, when+uint64(idx)*1000000)
+		buf.Write(packet)
+	}
+	return buf.Bytes()
+}
+
+// connected is an LE connection complete event
+func connected(handle byte, last byte) []byte {
+	packet := []byte{0x3e, 0x13, 0x01, 0x00, handle, 0x00, 0x00, 0x00, last, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa}
+	return append(packet, make([]byte, 7)...)
+}
+
+// notified is an ATT notification received over ACL
+func notified(handle byte, attr uint16, value ...byte) []byte {
+	pdu := append([]byte{attNotify, byte(attr), byte(attr >> 8)}, value...)
+	l2cap := append([]byte{byte(len(pdu)), 0x00, byte(l2capATT), 0x00}, pdu...)
+	return append([]byte{handle, 0x20, byte(len(l2cap)), 0x00}, l2cap...)
+}
+
+var (
+	flagEvent = []byte{0x03}
+	flagACLIn = []byte{0x01}
+)
+
+func TestBtsnoopAttachesOnlyMiaomiaos(t *testing.T) {
+	capture := btsnoopFile(
+		// something else first, that never talks like a miaomiao
+		flagEvent, connected(0x40, 0x01),
+		flagACLIn, notified(0x40, 0x0020, 0x99, 0x01),
+		flagEvent, connected(0x41, 0x02),
+		flagACLIn, notified(0x41, 0x000e, byte(MPLibre), 0x01, 0x6b),
+	)
+	events, err := ReadBtsnoop(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %v events, want attach and notify: %v", len(events), events)
+	}
+	if events[0].Kind != RecordAttach || events[0].Target != "aa:aa:aa:aa:aa:02" {
+		t.Errorf("attached to %v %v, want aa:aa:aa:aa:aa:02", events[0].Kind, events[0].Target)
+	}
+	if events[1].Kind != RecordNotify || events[1].Time.Before(events[0].Time) {
+		t.Errorf("second event %v at %v, after attach at %v", events[1].Kind, events[1].Time, events[0].Time)
+	}
+}
+
+func TestBtsnoopRejectsHugeRecords(t *testing.T) {
+	capture := btsnoopFile(flagEvent, connected(0x40, 0x01))
+	// claim a 4GB packet
+	binary.BigEndian.PutUint32(capture[16:20], 0xffffffff)
+	binary.BigEndian.PutUint32(capture[20:24], 0xffffffff)
+	if _, err := ReadBtsnoop(bytes.NewReader(capture)); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("got %v, want a corrupt record error", err)
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+// m2g-collect: read transcievers and send measurements everywhere at once
+// (now m2g collect)
+
+import (
+	"github.com/thecubic/miao2go/internal/cli"
+	"os"
+)
+
+func main() {
+	cli.Run("collect", os.Args[1:])
+}
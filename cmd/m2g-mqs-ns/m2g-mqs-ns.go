@@ -0,0 +1,13 @@
+package main
+
+// m2g-mqs-ns: MQ subscribe and upload received measurements to Nightscout
+// (now m2g subscribe-nightscout)
+
+import (
+	"github.com/thecubic/miao2go/internal/cli"
+	"os"
+)
+
+func main() {
+	cli.Run("subscribe-nightscout", os.Args[1:])
+}
@@ -0,0 +1,13 @@
+package main
+
+// m2g-ns: read transciever and upload measurements to Nightscout
+// (now m2g nightscout)
+
+import (
+	"github.com/thecubic/miao2go/internal/cli"
+	"os"
+)
+
+func main() {
+	cli.Run("nightscout", os.Args[1:])
+}
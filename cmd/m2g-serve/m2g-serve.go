@@ -0,0 +1,13 @@
+package main
+
+// m2g-serve: read transciever and serve measurements like a Nightscout site
+// (now m2g serve)
+
+import (
+	"github.com/thecubic/miao2go/internal/cli"
+	"os"
+)
+
+func main() {
+	cli.Run("serve", os.Args[1:])
+}
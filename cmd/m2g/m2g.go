@@ -0,0 +1,13 @@
+package main
+
+// m2g: every miao2go command in one binary; run it as m2g <command>, or
+// link it to one of the old m2g-* names
+
+import (
+	"github.com/thecubic/miao2go/internal/cli"
+	"os"
+)
+
+func main() {
+	cli.Main(os.Args)
+}
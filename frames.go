@@ -0,0 +1,57 @@
+package miao2go
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// MiaoFrameLength is the size of a whole miaomiao sensor response
+	MiaoFrameLength = 363
+	// LibreFRAMLength is the size of the sensor FRAM the miaomiao relays
+	LibreFRAMLength = 344
+)
+
+// ParseMiaoResponse wraps a captured miaomiao frame up as though it had
+// just come off the air, finishing at captureTime
+func ParseMiaoResponse(data []byte, captureTime time.Time) (*MiaoResponsePacket, error) {
+	if len(data) != MiaoFrameLength {
+		return nil, fmt.Errorf("miaomiao frame is %v bytes, not %v", len(data), MiaoFrameLength)
+	}
+	mr := &MiaoResponsePacket{MiaoDeviceState(data[0]), [363]byte{}, nil, captureTime, captureTime}
+	copy(mr.Data[:], data)
+	return mr, nil
+}
+
+// DecodeFrame decodes a captured miaomiao frame or a bare Libre FRAM dump.
+// A FRAM dump has no miaomiao around it, so only the LibrePacket (and the
+// times) of the result are filled in
+func DecodeFrame(data []byte, captureTime time.Time) (*MiaoMiaoPacket, error) {
+	switch len(data) {
+	case MiaoFrameLength:
+		mr, err := ParseMiaoResponse(data, captureTime)
+		if err != nil {
+			return nil, err
+		}
+		if mr.Type != MPLibre {
+			return nil, fmt.Errorf("not a sensor response: type %#02x", byte(mr.Type))
+		}
+		pkt := CreateMiaoMiaoPacket(mr)
+		return &pkt, nil
+	case LibreFRAMLength:
+		var fram [344]byte
+		copy(fram[:], data)
+		lp := CreateLibrePacket(fram, "", captureTime)
+		return &MiaoMiaoPacket{StartTime: captureTime, EndTime: captureTime, LibrePacket: &lp}, nil
+	}
+	return nil, fmt.Errorf("don't know what a %v byte frame is", len(data))
+}
+
+// Redecode runs the raw data of an already-decoded packet (say, one that
+// went through JSON) through the decoder again, keeping its provenance
+func (mmp MiaoMiaoPacket) Redecode() MiaoMiaoPacket {
+	mr := &MiaoResponsePacket{MiaoDeviceState(mmp.Data[0]), mmp.Data, nil, mmp.StartTime, mmp.EndTime}
+	pkt := CreateMiaoMiaoPacket(mr)
+	pkt.Transmitter = mmp.Transmitter
+	return pkt
+}
@@ -0,0 +1,95 @@
+package inf
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"time"
+)
+
+// Output kinds
+const (
+	OutputV1   = "v1"
+	OutputV2   = "v2"
+	OutputLine = "line"
+)
+
+// Config is everything needed to pick and open a Writer
+type Config struct {
+	Output      string        `yaml:"output"`
+	URL         string        `yaml:"url"`
+	User        string        `yaml:"user"`
+	Pass        string        `yaml:"pass"`
+	NoVerifySSL bool          `yaml:"noverifyssl"`
+	Prefix      string        `yaml:"prefix"`
+	Database    string        `yaml:"db"`
+	Org         string        `yaml:"org"`
+	Bucket      string        `yaml:"bucket"`
+	Token       string        `yaml:"token"`
+	LineOut     string        `yaml:"out"`
+	Retries     int           `yaml:"retries"`
+	Backoff     time.Duration `yaml:"backoff"`
+	Timeout     time.Duration `yaml:"timeout"`
+	UserAgent   string        `yaml:"-"`
+}
+
+// Flags registers the inf.* flags on a flag set
+func Flags(fs *flag.FlagSet, userAgent string) *Config {
+	config := &Config{UserAgent: userAgent}
+	fs.StringVar(&config.Output, "inf.output", OutputV1, "where points go: v1 (database), v2 (bucket) or line (line protocol)")
+	fs.StringVar(&config.URL, "inf.url", "http://localhost:8086", "influxdb address")
+	fs.StringVar(&config.User, "inf.user", "", "influxdb user (v1)")
+	fs.StringVar(&config.Pass, "inf.pass", "", "influxdb password (v1)")
+	fs.BoolVar(&config.NoVerifySSL, "inf.noverifyssl", false, "don't verify certs / hostname")
+	fs.StringVar(&config.Prefix, "inf.prefix", "", "influxdb reporting prefix")
+	fs.StringVar(&config.Database, "inf.db", "sweet", "influxdb database name (v1)")
+	fs.StringVar(&config.Org, "inf.org", "", "influxdb organization (v2)")
+	fs.StringVar(&config.Bucket, "inf.bucket", "sweet", "influxdb bucket (v2)")
+	fs.StringVar(&config.Token, "inf.token", "", "influxdb API token (v2)")
+	fs.StringVar(&config.LineOut, "inf.out", "-", "line protocol destination: -, a file, or udp://host:port (line)")
+	fs.IntVar(&config.Retries, "inf.retries", 5, "write attempts before giving up on a packet")
+	fs.DurationVar(&config.Backoff, "inf.backoff", time.Second, "wait before the first write retry")
+	fs.DurationVar(&config.Timeout, "inf.timeout", 10*time.Second, "influxdb request timeout")
+	return config
+}
+
+// Open opens the configured writer, checking it's usable where possible
+func (config *Config) Open() (Writer, error) {
+	var writer Writer
+	switch config.Output {
+	case OutputV1:
+		v1, err := NewV1Writer(influx.HTTPConfig{
+			Addr:               config.URL,
+			Username:           config.User,
+			Password:           config.Pass,
+			UserAgent:          config.UserAgent,
+			Timeout:            config.Timeout,
+			InsecureSkipVerify: config.NoVerifySSL,
+		}, config.Database)
+		if err != nil {
+			return nil, fmt.Errorf("can't influx: %v", err)
+		}
+		if err = v1.Check(config.Timeout); err != nil {
+			v1.Close()
+			return nil, err
+		}
+		writer = v1
+	case OutputV2:
+		client := &http.Client{Timeout: config.Timeout}
+		if config.NoVerifySSL {
+			client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+		}
+		writer = NewV2Writer(config.URL, config.Org, config.Bucket, config.Token, client)
+	case OutputLine:
+		lw, err := OpenLineWriter(config.LineOut)
+		if err != nil {
+			return nil, err
+		}
+		writer = lw
+	default:
+		return nil, fmt.Errorf("unknown inf.output %q", config.Output)
+	}
+	return Retrying(writer, config.Retries, config.Backoff), nil
+}
@@ -0,0 +1,83 @@
+package inf
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// LineProtocol renders a point as a line of InfluxDB line protocol, with
+// a nanosecond timestamp.  Tags and fields are sorted so the same point
+// always renders the same way
+func (point Point) LineProtocol() string {
+	var line strings.Builder
+	line.WriteString(measurementEscaper.Replace(point.Measurement))
+
+	tagKeys := make([]string, 0, len(point.Tags))
+	for key := range point.Tags {
+		tagKeys = append(tagKeys, key)
+	}
+	sort.Strings(tagKeys)
+	for _, key := range tagKeys {
+		if len(point.Tags[key]) == 0 {
+			continue
+		}
+		fmt.Fprintf(&line, ",%s=%s", keyEscaper.Replace(key), keyEscaper.Replace(point.Tags[key]))
+	}
+
+	fieldKeys := make([]string, 0, len(point.Fields))
+	for key := range point.Fields {
+		fieldKeys = append(fieldKeys, key)
+	}
+	sort.Strings(fieldKeys)
+	for idx, key := range fieldKeys {
+		separator := ","
+		if idx == 0 {
+			separator = " "
+		}
+		fmt.Fprintf(&line, "%s%s=%s", separator, keyEscaper.Replace(key), fieldValue(point.Fields[key]))
+	}
+
+	if !point.Time.IsZero() {
+		fmt.Fprintf(&line, " %d", point.Time.UnixNano())
+	}
+	return line.String()
+}
+
+// fieldValue renders a field value the way line protocol wants it
+func fieldValue(value interface{}) string {
+	switch v := value.(type) {
+	case int:
+		return strconv.FormatInt(int64(v), 10) + "i"
+	case int64:
+		return strconv.FormatInt(v, 10) + "i"
+	case uint8:
+		return strconv.FormatInt(int64(v), 10) + "i"
+	case uint16:
+		return strconv.FormatInt(int64(v), 10) + "i"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return `"` + stringEscaper.Replace(v) + `"`
+	}
+	return `"` + stringEscaper.Replace(fmt.Sprint(value)) + `"`
+}
+
+// LineProtocol renders points as a block of line protocol
+func LineProtocol(points []Point) []byte {
+	var block strings.Builder
+	for _, point := range points {
+		block.WriteString(point.LineProtocol())
+		block.WriteByte('\n')
+	}
+	return []byte(block.String())
+}
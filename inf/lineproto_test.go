@@ -0,0 +1,45 @@
+package inf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLineProtocol(t *testing.T) {
+	when := time.Date(2018, 9, 25, 10, 0, 0, 123, time.UTC)
+	for _, tc := range []struct {
+		name  string
+		point Point
+		want  string
+	}{
+		{
+			"sorted",
+			Point{"glucose", map[string]string{"serial": "0M0001", "kind": "trend"}, map[string]interface{}{"raw": uint16(1234), "glucose": 101.5}, when},
+			"glucose,kind=trend,serial=0M0001 glucose=101.5,raw=1234i 1537869600000000123",
+		},
+		{
+			"escaped",
+			Point{"my glucose", map[string]string{"a,b": "c=d e"}, map[string]interface{}{"note": `say "hi"`}, when},
+			`my\ glucose,a\,b=c\=d\ e note="say \"hi\"" 1537869600000000123`,
+		},
+		{
+			"empty tags and no time",
+			Point{"transmitter", map[string]string{"serial": ""}, map[string]interface{}{"battery": 90, "ok": true}, time.Time{}},
+			"transmitter battery=90i,ok=true",
+		},
+	} {
+		if got := tc.point.LineProtocol(); got != tc.want {
+			t.Errorf("%v: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLineProtocolBlock(t *testing.T) {
+	points := []Point{
+		{"a", nil, map[string]interface{}{"x": 1}, time.Unix(1, 0)},
+		{"b", nil, map[string]interface{}{"y": 2.0}, time.Unix(2, 0)},
+	}
+	if got, want := string(LineProtocol(points)), "a x=1i 1000000000\nb y=2 2000000000\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
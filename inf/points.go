@@ -0,0 +1,87 @@
+// Package inf turns miaomiao packets into InfluxDB points, so that every
+// command writing to InfluxDB produces the same series
+package inf
+
+import (
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"time"
+)
+
+// Measurement names, before any prefix
+const (
+	GlucoseMeasurement     = "glucose"
+	TransmitterMeasurement = "transmitter"
+)
+
+// Point is a single InfluxDB point, independent of any client library
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// packetTags are the tags every point from a packet carries
+func packetTags(pkt miao2go.MiaoMiaoPacket) map[string]string {
+	tags := make(map[string]string)
+	if len(pkt.SerialNumber) > 0 {
+		tags["serial"] = pkt.SerialNumber
+	}
+	if len(pkt.Transmitter) > 0 {
+		tags["transmitter"] = pkt.Transmitter
+	}
+	if pkt.FimrwareVersion > 0 {
+		tags["firmware"] = fmt.Sprintf("%04x", pkt.FimrwareVersion)
+	}
+	return tags
+}
+
+// ReadingPoint is the point for a single glucose reading
+func ReadingPoint(pkt miao2go.MiaoMiaoPacket, reading miao2go.GlucoseReading, prefix string) Point {
+	tags := packetTags(pkt)
+	tags["kind"] = string(reading.Kind)
+	return Point{
+		prefix + GlucoseMeasurement,
+		tags,
+		map[string]interface{}{
+			"raw":         reading.Raw,
+			"glucose":     reading.Glucose,
+			"temperature": reading.Temperature,
+		},
+		reading.Time,
+	}
+}
+
+// TransmitterPoint is the point describing the miaomiao itself
+func TransmitterPoint(pkt miao2go.MiaoMiaoPacket, prefix string) Point {
+	fields := map[string]interface{}{
+		"battery": int(pkt.BatteryPercentage),
+	}
+	if pkt.LibrePacket != nil {
+		fields["sensor_minutes"] = pkt.LibrePacket.SensorMinutes()
+	}
+	return Point{prefix + TransmitterMeasurement, packetTags(pkt), fields, pkt.EndTime}
+}
+
+// Points is every point a packet makes: one per trend and history reading,
+// each at its own time, plus one for the transmitter.  Empty entries (a
+// sensor that hasn't filled its buffers yet) are left out
+func Points(pkt miao2go.MiaoMiaoPacket, prefix string) []Point {
+	return PacketPoints(pkt, pkt.Readings(), prefix)
+}
+
+// PacketPoints is Points for just some of the readings of a packet
+func PacketPoints(pkt miao2go.MiaoMiaoPacket, readings []miao2go.GlucoseReading, prefix string) []Point {
+	var points []Point
+	for _, reading := range readings {
+		if reading.Raw == 0 {
+			continue
+		}
+		points = append(points, ReadingPoint(pkt, reading, prefix))
+	}
+	if len(pkt.SerialNumber) > 0 || len(pkt.Transmitter) > 0 {
+		points = append(points, TransmitterPoint(pkt, prefix))
+	}
+	return points
+}
@@ -0,0 +1,45 @@
+package inf
+
+import (
+	"github.com/thecubic/miao2go"
+	"time"
+)
+
+// Sink writes packets to a Writer, as a miao2go.Sink
+type Sink struct {
+	writer  Writer
+	prefix  string
+	written *miao2go.Deduper
+}
+
+// NewSink writes packets' points to writer, prefixing measurements
+func NewSink(writer Writer, prefix string) *Sink {
+	return &Sink{writer, prefix, miao2go.NewDeduper(12 * time.Hour)}
+}
+
+// WriteReading writes the readings of a packet that haven't been written
+// yet as one batch.  History timestamps move a little with each packet, so
+// writing them all again would leave near duplicate points
+func (sink *Sink) WriteReading(pkt miao2go.MiaoMiaoPacket) error {
+	fresh := sink.written.Fresh(pkt.Readings())
+	if err := sink.writer.Write(PacketPoints(pkt, fresh, sink.prefix)); err != nil {
+		return err
+	}
+	sink.written.Mark(fresh)
+	return nil
+}
+
+// WriteEvent does nothing; events aren't measurements
+func (sink *Sink) WriteEvent(event miao2go.Event) error {
+	return nil
+}
+
+// Flush does nothing; every packet is written as it comes
+func (sink *Sink) Flush() error {
+	return nil
+}
+
+// Close closes the writer
+func (sink *Sink) Close() error {
+	return sink.writer.Close()
+}
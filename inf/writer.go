@@ -0,0 +1,215 @@
+package inf
+
+import (
+	"bytes"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	"github.com/thecubic/miao2go"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Writer sends points to an InfluxDB, or something that speaks its language
+type Writer interface {
+	Write(points []Point) error
+	Close() error
+}
+
+// V1Writer writes to an InfluxDB 1.x database
+type V1Writer struct {
+	client   influx.Client
+	database string
+}
+
+// NewV1Writer connects to an InfluxDB 1.x server
+func NewV1Writer(config influx.HTTPConfig, database string) (*V1Writer, error) {
+	client, err := influx.NewHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &V1Writer{client, database}, nil
+}
+
+// Check makes sure the server is up and the database exists
+func (v1 *V1Writer) Check(timeout time.Duration) error {
+	latency, version, err := v1.client.Ping(timeout)
+	if err != nil {
+		return fmt.Errorf("can't influx ping: %v", err)
+	}
+	log.Printf("took %v to reach influxdb %v", latency, version)
+	result, err := v1.client.Query(influx.NewQuery("SHOW DATABASES", "", ""))
+	if err != nil {
+		return err
+	} else if result.Error() != nil {
+		return result.Error()
+	}
+	for _, qresult := range result.Results {
+		for _, series := range qresult.Series {
+			for _, row := range series.Values {
+				if row[0] == v1.database {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("inf.db %v not present", v1.database)
+}
+
+// Write writes points as a single batch
+func (v1 *V1Writer) Write(points []Point) error {
+	bp, err := influx.NewBatchPoints(influx.BatchPointsConfig{Database: v1.database, Precision: "ns"})
+	if err != nil {
+		return err
+	}
+	for _, point := range points {
+		ipt, err := influx.NewPoint(point.Measurement, point.Tags, point.Fields, point.Time)
+		if err != nil {
+			return fmt.Errorf("bad point: %v", err)
+		}
+		bp.AddPoint(ipt)
+	}
+	return v1.client.Write(bp)
+}
+
+// Close closes the client
+func (v1 *V1Writer) Close() error {
+	return v1.client.Close()
+}
+
+// V2Writer writes to an InfluxDB 2.x bucket over its HTTP API
+type V2Writer struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+// NewV2Writer targets a bucket of an InfluxDB 2.x server
+func NewV2Writer(server, org, bucket, token string, client *http.Client) *V2Writer {
+	query := url.Values{}
+	query.Set("org", org)
+	query.Set("bucket", bucket)
+	query.Set("precision", "ns")
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &V2Writer{
+		strings.TrimRight(server, "/") + "/api/v2/write?" + query.Encode(),
+		token,
+		client,
+	}
+}
+
+// Write posts points as line protocol
+func (v2 *V2Writer) Write(points []Point) error {
+	req, err := http.NewRequest("POST", v2.endpoint, bytes.NewReader(LineProtocol(points)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if len(v2.token) > 0 {
+		req.Header.Set("Authorization", "Token "+v2.token)
+	}
+	resp, err := v2.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influxdb said %v: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// Close is a no-op; there's nothing to hang up
+func (v2 *V2Writer) Close() error {
+	return nil
+}
+
+// LineWriter writes raw line protocol to a file, stdout or a UDP listener
+type LineWriter struct {
+	out io.WriteCloser
+	udp bool
+}
+
+// nopCloser keeps us from closing stdout
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// NewLineWriter writes line protocol to w
+func NewLineWriter(w io.Writer) *LineWriter {
+	if closer, ok := w.(io.WriteCloser); ok {
+		return &LineWriter{closer, false}
+	}
+	return &LineWriter{nopCloser{w}, false}
+}
+
+// OpenLineWriter opens a line protocol destination: "-" for stdout,
+// udp://host:port for an InfluxDB UDP listener, or a file to append to
+func OpenLineWriter(target string) (*LineWriter, error) {
+	if target == "-" {
+		return &LineWriter{nopCloser{os.Stdout}, false}, nil
+	}
+	if strings.HasPrefix(target, "udp://") {
+		conn, err := net.Dial("udp", strings.TrimPrefix(target, "udp://"))
+		if err != nil {
+			return nil, err
+		}
+		return &LineWriter{conn, true}, nil
+	}
+	fh, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &LineWriter{fh, false}, nil
+}
+
+// Write writes points as line protocol.  Over UDP every point is its own
+// datagram, so no batch can outgrow a packet
+func (lw *LineWriter) Write(points []Point) error {
+	if lw.udp {
+		for _, point := range points {
+			if _, err := lw.out.Write([]byte(point.LineProtocol() + "\n")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, err := lw.out.Write(LineProtocol(points))
+	return err
+}
+
+// Close closes the destination
+func (lw *LineWriter) Close() error {
+	return lw.out.Close()
+}
+
+// retryWriter retries failed writes with backoff
+type retryWriter struct {
+	Writer
+	attempts int
+	backoff  time.Duration
+}
+
+// Retrying wraps a writer so each Write is retried, as in miao2go.Retry
+func Retrying(w Writer, attempts int, backoff time.Duration) Writer {
+	return &retryWriter{w, attempts, backoff}
+}
+
+func (rw *retryWriter) Write(points []Point) error {
+	return miao2go.Retry(rw.attempts, rw.backoff, func() error {
+		return rw.Writer.Write(points)
+	})
+}
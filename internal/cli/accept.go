@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"github.com/thecubic/miao2go"
+	"log"
+)
+
+// logStatus logs what mode the miaomiao says it's in
+func logStatus(mls miao2go.MiaoDeviceState) {
+	switch mls {
+	case miao2go.MPDeclared:
+		log.Printf("miaomiao: unknown")
+	case miao2go.MPLibre:
+		log.Printf("miaomiao: reporting mode")
+	case miao2go.MPNoSensor:
+		log.Printf("miaomiao: no sensor")
+	case miao2go.MPNewSensor:
+		log.Printf("miaomiao: new sensor")
+	}
+}
+
+// accept accepts a new sensor (when relevant)
+func accept(args []string) {
+	fs, lg := newFlagSet("accept")
+	cn := connFlags(fs)
+	nocheck := fs.Bool("nocheck", false, "don't check for NewSensor condition")
+	parse(fs, lg, args)
+
+	miao := cn.Open()
+	defer cn.Close()
+
+	mls, err := miao.MiaoLibreStatus()
+	if err != nil {
+		log.Printf("couldn't get new status: %v", err)
+	} else {
+		logStatus(mls)
+	}
+
+	if !*nocheck && mls != miao2go.MPNewSensor {
+		cn.Close()
+		log.Fatalf("check failed: sensor not in new sensor mode")
+	}
+
+	log.Printf("accepting sensor...")
+	if err = miao.AcceptNewSensor(); err != nil {
+		log.Printf("couldn't accept new sensor: %v", err)
+	}
+
+	mls, err = miao.MiaoLibreStatus()
+	if err != nil {
+		log.Printf("couldn't get new status: %v", err)
+	} else {
+		logStatus(mls)
+	}
+}
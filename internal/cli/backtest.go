@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/thecubic/miao2go/alert"
+	"github.com/thecubic/miao2go/store"
+	"log"
+	"time"
+)
+
+// backtest replays stored readings through the predicted low rule, to see
+// how its forecasts and alerts would have done
+func backtest(args []string) {
+	fs, lg := newFlagSet("backtest")
+	stconfig := store.Flags(fs)
+	alconfig := alert.Flags(fs)
+	serial := fs.String("serial", "", "only this sensor (default every sensor in the store)")
+	var from, to time.Time
+	fs.Func("from", "only readings since this RFC3339 time", func(value string) (err error) {
+		from, err = time.Parse(time.RFC3339, value)
+		return err
+	})
+	fs.Func("to", "only readings until this RFC3339 time", func(value string) (err error) {
+		to, err = time.Parse(time.RFC3339, value)
+		return err
+	})
+	parse(fs, lg, args)
+
+	if alconfig.Predicted.Threshold <= 0 {
+		log.Fatalf("nothing to backtest with --alert.predicted=0")
+	}
+	if err := alconfig.Validate(); err != nil {
+		log.Fatalf("bad alert config: %v", err)
+	}
+	st, err := stconfig.Open()
+	if err != nil {
+		log.Fatalf("can't open store: %v", err)
+	}
+	if st == nil {
+		log.Fatalf("need a --store to backtest against")
+	}
+	defer st.Close()
+
+	serials := st.Serials()
+	if len(*serial) > 0 {
+		serials = []string{*serial}
+	}
+	for _, name := range serials {
+		readings := st.Range(name, from, to)
+		if len(readings) == 0 {
+			log.Printf("%v: no readings", name)
+			continue
+		}
+		fmt.Printf("%v: %v\n", name, alert.Backtest(readings, alconfig.Predicted))
+	}
+	if len(serials) > 1 {
+		fmt.Printf("all: %v\n", alert.Backtest(st.Range("", from, to), alconfig.Predicted))
+	}
+}
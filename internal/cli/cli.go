@@ -0,0 +1,127 @@
+package cli
+
+// cli: the m2g subcommands, shared by the m2g binary and the old m2g-* names
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Command is one m2g subcommand
+type Command struct {
+	Name    string
+	Alias   string
+	Summary string
+	Run     func(args []string)
+}
+
+// Commands are every m2g subcommand; Alias is the binary it used to be, if
+// there was one
+var Commands = []*Command{
+	{"scan", "m2g-scan", "find miaomiao transcievers and name them", scan},
+	{"decode", "m2g-decode", "read a miaomiao (or a capture) and show measurements", decode},
+	{"accept", "m2g-accept", "accept a new sensor", accept},
+	{"publish", "m2g-mqp", "read a miaomiao and MQ publish measurements", publish},
+	{"subscribe", "m2g-mqs", "MQ subscribe and show measurements", subscribe},
+	{"influx", "m2g-influx", "read a miaomiao and send measurements to InfluxDB", influx},
+	{"subscribe-influx", "m2g-mqs-influx", "MQ subscribe and send measurements to InfluxDB", subscribeInflux},
+	{"nightscout", "m2g-ns", "read a miaomiao and upload measurements to Nightscout", nightscoutUpload},
+	{"subscribe-nightscout", "m2g-mqs-ns", "MQ subscribe and upload measurements to Nightscout", subscribeNightscout},
+	{"serve", "m2g-serve", "read a miaomiao and serve measurements like a Nightscout site", serve},
+	{"collect", "m2g-collect", "read miaomiaos and send measurements everywhere at once", collect},
+	{"backtest", "", "replay stored readings through the predicted low alert", backtest},
+	{"notify", "", "send a test alert to every notifier", notifyTest},
+}
+
+// Lookup finds a command by name or old binary name
+func Lookup(name string) *Command {
+	for _, cmd := range Commands {
+		if cmd.Name == name || cmd.Alias == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// usage lists the commands
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: m2g <command> [flags]\n\ncommands:\n")
+	for _, cmd := range Commands {
+		if len(cmd.Alias) == 0 {
+			fmt.Fprintf(w, "  %-22s %v\n", cmd.Name, cmd.Summary)
+			continue
+		}
+		fmt.Fprintf(w, "  %-22s %v (was %v)\n", cmd.Name, cmd.Summary, cmd.Alias)
+	}
+	fmt.Fprintf(w, "\nm2g <command> -h shows a command's flags\n")
+}
+
+// Main runs m2g with os.Args: the command is the name we were run as (for
+// links named after the old binaries) or else the first argument
+func Main(args []string) {
+	if cmd := Lookup(filepath.Base(args[0])); cmd != nil {
+		cmd.Run(args[1:])
+		return
+	}
+	if len(args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	switch args[1] {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return
+	}
+	cmd := Lookup(args[1])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "m2g: unknown command %q\n\n", args[1])
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	cmd.Run(args[2:])
+}
+
+// Run runs a command by name, for the old binaries
+func Run(name string, args []string) {
+	cmd := Lookup(name)
+	if cmd == nil {
+		log.Fatalf("no such command %q", name)
+	}
+	cmd.Run(args)
+}
+
+// logging is how every command logs
+type logging struct {
+	file    string
+	notime  bool
+	verbose bool
+}
+
+// newFlagSet makes a command's flag set, with the logging flags on it
+func newFlagSet(name string) (*flag.FlagSet, *logging) {
+	fs := flag.NewFlagSet("m2g "+name, flag.ExitOnError)
+	lg := &logging{}
+	fs.StringVar(&lg.file, "logfile", "", "log to this file instead of stderr")
+	fs.BoolVar(&lg.notime, "notime", false, "don't timestamp log lines (say, under journald)")
+	fs.BoolVar(&lg.verbose, "verbose", false, "log more about what's going on")
+	return fs, lg
+}
+
+// parse parses a command's flags and sets up logging
+func parse(fs *flag.FlagSet, lg *logging, args []string) {
+	fs.Parse(args)
+	if lg.notime {
+		log.SetFlags(0)
+	}
+	if len(lg.file) > 0 {
+		file, err := os.OpenFile(lg.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatalf("can't log to %v: %v", lg.file, err)
+		}
+		log.SetOutput(file)
+	}
+}
@@ -0,0 +1,162 @@
+package cli
+
+import (
+	"github.com/thecubic/miao2go/alert"
+	"github.com/thecubic/miao2go/inf"
+	"github.com/thecubic/miao2go/mq"
+	"github.com/thecubic/miao2go/nightscout"
+	"github.com/thecubic/miao2go/pipeline"
+	"github.com/thecubic/miao2go/queue"
+	"github.com/thecubic/miao2go/store"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// collectFlags are m2g collect's flags
+type collectFlags struct {
+	config    string
+	sinks     string
+	cn        *conn
+	mqconfig  *mq.Config
+	infconfig *inf.Config
+	nsconfig  *nightscout.Config
+	stconfig  *store.Config
+	qconfig   *queue.Config
+	alconfig  *alert.Config
+}
+
+// fromFlags is the pipeline the flags describe
+func (cf *collectFlags) fromFlags() *pipeline.Config {
+	cn := cf.cn
+	source := pipeline.SourceConfig{Type: pipeline.SourceBLE, Miao: cn.miao, Accept: !cn.noaccept, Timeout: cn.timeout}
+	if len(cn.replay) > 0 {
+		source = pipeline.SourceConfig{Type: pipeline.SourceReplay, File: cn.replay, Format: pipeline.FormatRecording, Realtime: cn.realtime}
+	} else if len(cn.btsnoop) > 0 {
+		source = pipeline.SourceConfig{Type: pipeline.SourceReplay, File: cn.btsnoop, Format: pipeline.FormatBtsnoop, Realtime: cn.realtime}
+	}
+	plc := &pipeline.Config{
+		Registry: cn.registry,
+		Queue:    cf.qconfig,
+		Sources:  []pipeline.SourceConfig{source},
+	}
+	for _, name := range strings.Split(cf.sinks, ",") {
+		sink := pipeline.SinkConfig{Type: strings.TrimSpace(name)}
+		switch sink.Type {
+		case "":
+			continue
+		case pipeline.SinkMQTT:
+			sink.MQTT = cf.mqconfig
+		case pipeline.SinkInflux:
+			sink.Influx = cf.infconfig
+		case pipeline.SinkNightscout:
+			sink.Nightscout = cf.nsconfig
+		case pipeline.SinkStore:
+			sink.Store = cf.stconfig
+		case pipeline.SinkAlert:
+			sink.Alert = cf.alconfig
+		}
+		plc.Sinks = append(plc.Sinks, sink)
+	}
+	return plc
+}
+
+// load is the pipeline we've been asked for
+func (cf *collectFlags) load() (*pipeline.Config, error) {
+	if len(cf.config) > 0 {
+		return pipeline.Load(cf.config)
+	}
+	plc := cf.fromFlags()
+	return plc, plc.Validate()
+}
+
+// usesBLE is whether any source is a live miaomiao
+func usesBLE(plc *pipeline.Config) bool {
+	for _, source := range plc.Sources {
+		if source.Type == pipeline.SourceBLE {
+			return true
+		}
+	}
+	return false
+}
+
+// report logs how each sink got on
+func report(pl *pipeline.Pipeline) {
+	for name, stats := range pl.Stats() {
+		log.Printf("%v: %v written, %v failed, %v dropped", name, stats.Written, stats.Failed, stats.Dropped)
+	}
+}
+
+// collect reads miaomiaos and sends measurements everywhere at once
+func collect(args []string) {
+	fs, lg := newFlagSet("collect")
+	cf := &collectFlags{cn: connFlags(fs)}
+	fs.StringVar(&cf.config, "config", "", "pipeline file; the rest of the flags are ignored if given")
+	fs.StringVar(&cf.sinks, "sinks", "print", "where packets go: any of print, json, store, mqtt, influx, nightscout, alert")
+	cf.mqconfig = mq.Flags(fs, "m2g-collect", 0)
+	cf.infconfig = inf.Flags(fs, "m2g-collect")
+	cf.nsconfig = nightscout.Flags(fs)
+	cf.stconfig = store.Flags(fs)
+	cf.qconfig = queue.Flags(fs)
+	cf.alconfig = alert.Flags(fs)
+	parse(fs, lg, args)
+
+	plc, err := cf.load()
+	if err != nil {
+		log.Fatalf("bad pipeline: %v", err)
+	}
+	configureBLE := func(plc *pipeline.Config) {
+		if usesBLE(plc) {
+			setupBLE()
+		}
+	}
+	configureBLE(plc)
+
+	pl, err := pipeline.Start(plc)
+	if err != nil {
+		log.Fatalf("can't start pipeline: %v", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case <-pl.Done():
+			report(pl)
+			return
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				log.Printf("%v, stopping", sig)
+				pl.Stop()
+				report(pl)
+				return
+			}
+			if len(cf.config) == 0 {
+				log.Printf("SIGHUP, but there's no --config to reload")
+				continue
+			}
+			// a broken file keeps the pipeline we have
+			next, err := pipeline.Load(cf.config)
+			if err != nil {
+				log.Printf("not reloading: %v", err)
+				continue
+			}
+			log.Printf("reloading %v", cf.config)
+			pl.Stop()
+			report(pl)
+			configureBLE(next)
+			// alerts carry on from the old pipeline's, rather than starting over
+			stopped := pl
+			if pl, err = pipeline.StartAfter(next, stopped); err != nil {
+				log.Printf("can't start reloaded pipeline, going back: %v", err)
+				if pl, err = pipeline.StartAfter(plc, stopped); err != nil {
+					log.Fatalf("can't start pipeline: %v", err)
+				}
+				continue
+			}
+			plc = next
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"github.com/currantlabs/ble"
+	"github.com/currantlabs/ble/linux"
+	"github.com/thecubic/miao2go"
+	"golang.org/x/net/context"
+	"log"
+	"sync"
+	"time"
+)
+
+// conn is how a command gets hold of a miaomiao: live, or from a capture
+type conn struct {
+	timeout  time.Duration
+	miao     string
+	registry string
+	noaccept bool
+	once     bool
+	record   string
+	replay   string
+	realtime bool
+	btsnoop  string
+
+	reg    *miao2go.Registry
+	rec    *miao2go.Recorder
+	opened []func(*miao2go.ConnectedMiao)
+
+	// mu guards the live connection, which commands reach in from elsewhere
+	mu          sync.Mutex
+	cln         ble.Client
+	live        *miao2go.ConnectedMiao
+	reconnected bool
+}
+
+// connFlags registers the connection flags on a flag set
+func connFlags(fs *flag.FlagSet) *conn {
+	cn := &conn{}
+	fs.DurationVar(&cn.timeout, "timeout", 60*time.Second, "timeout")
+	fs.StringVar(&cn.miao, "miao", "", "address, alias, serial or name of the miaomiao (default: first found)")
+	fs.StringVar(&cn.registry, "registry", miao2go.DefaultRegistryPath(), "miaomiao registry file")
+	fs.BoolVar(&cn.noaccept, "noaccept", false, "don't accept new sensors")
+	fs.BoolVar(&cn.once, "once", false, "don't continue after first read")
+	fs.StringVar(&cn.record, "record", "", "record GATT traffic to this file")
+	fs.StringVar(&cn.replay, "replay", "", "read a GATT recording instead of a live miaomiao")
+	fs.BoolVar(&cn.realtime, "realtime", false, "replay with the recorded timing")
+	fs.StringVar(&cn.btsnoop, "btsnoop", "", "read miaomiao traffic from an Android btsnoop HCI log")
+	return cn
+}
+
+// Registry loads the registry, once
+func (cn *conn) Registry() *miao2go.Registry {
+	if cn.reg == nil {
+		reg, err := miao2go.LoadRegistry(cn.registry)
+		if err != nil {
+			log.Fatalf("can't load registry: %v", err)
+		}
+		cn.reg = reg
+	}
+	return cn.reg
+}
+
+// bleOnce makes sure the HCI device is only opened once
+var bleOnce sync.Once
+
+// setupBLE makes the first HCI device the default, if it isn't already
+func setupBLE() {
+	bleOnce.Do(func() {
+		d, err := linux.NewDevice()
+		if err != nil {
+			log.Fatalf("can't new device : %s", err)
+		}
+		ble.SetDefaultDevice(d)
+	})
+}
+
+// reconnectBackoff and maxReconnectBackoff pace connecting again after a
+// Reconnect, which keeps trying however long the miaomiao is away
+const (
+	reconnectBackoff    = 5 * time.Second
+	maxReconnectBackoff = 5 * time.Minute
+)
+
+// OnOpen has fn called with the miaomiao as soon as Open has it, before
+// any packets
+func (cn *conn) OnOpen(fn func(*miao2go.ConnectedMiao)) {
+	cn.opened = append(cn.opened, fn)
+}
+
+// Open gets hold of the miaomiao we were asked for
+func (cn *conn) Open() *miao2go.ConnectedMiao {
+	var miao *miao2go.ConnectedMiao
+	if len(cn.replay) > 0 {
+		events, err := miao2go.OpenRecording(cn.replay)
+		if err != nil {
+			log.Fatalf("couldn't read recording: %v", err)
+		}
+		log.Printf("replaying %v events from %v", len(events), cn.replay)
+		miao = miao2go.ReplayMiao(events, cn.realtime)
+	} else if len(cn.btsnoop) > 0 {
+		events, err := miao2go.OpenBtsnoop(cn.btsnoop)
+		if err != nil {
+			log.Fatalf("couldn't read btsnoop log: %v", err)
+		}
+		log.Printf("found %v miaomiao events in %v", len(events), cn.btsnoop)
+		miao = miao2go.ReplayMiao(events, cn.realtime)
+	} else {
+		setupBLE()
+		var err error
+		if miao, err = cn.dial(); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	return cn.attach(miao)
+}
+
+// dial connects to the miaomiao over BLE
+func (cn *conn) dial() (*miao2go.ConnectedMiao, error) {
+	ctx := ble.WithSigHandler(context.WithTimeout(context.Background(), cn.timeout))
+	log.Printf("connecting to %v", cn.miao)
+	cln, err := ble.Connect(ctx, miao2go.MiaoFilter(cn.miao, cn.Registry()))
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			log.Fatalf("interrupted connecting to %v", cn.miao)
+		}
+		return nil, fmt.Errorf("couldn't connect to %v: %v", cn.miao, err)
+	}
+	log.Printf("connected to %v", cln.Address())
+	cn.mu.Lock()
+	cn.cln = cln
+	cn.mu.Unlock()
+
+	go func() {
+		<-cln.Disconnected()
+		log.Printf("disconnected from %v", cln.Address())
+	}()
+
+	miao, err := miao2go.AttachBTLE(cln)
+	if err != nil {
+		cln.CancelConnection()
+		return nil, fmt.Errorf("couldn't get Miao descriptor: %v", err)
+	}
+	return miao, nil
+}
+
+// redial connects again after a Reconnect, backing off between attempts
+// rather than giving up
+func (cn *conn) redial() *miao2go.ConnectedMiao {
+	wait := reconnectBackoff
+	for {
+		miao, err := cn.dial()
+		if err == nil {
+			return cn.attach(miao)
+		}
+		log.Printf("%v, trying again in %v", err, wait)
+		time.Sleep(wait)
+		if wait *= 2; wait > maxReconnectBackoff {
+			wait = maxReconnectBackoff
+		}
+	}
+}
+
+// attach finishes getting hold of a miaomiao: registry, recording, and
+// whoever wants to know it's there
+func (cn *conn) attach(miao *miao2go.ConnectedMiao) *miao2go.ConnectedMiao {
+	miao.UseRegistry(cn.Registry())
+
+	// a reconnect carries on the same recording
+	if len(cn.record) > 0 && cn.rec == nil {
+		rec, err := miao2go.CreateRecorder(cn.record)
+		if err != nil {
+			log.Fatalf("couldn't start recording: %v", err)
+		}
+		cn.rec = rec
+	}
+	if cn.rec != nil {
+		miao.Record(cn.rec)
+	}
+	cn.mu.Lock()
+	cn.live = miao
+	cn.mu.Unlock()
+	for _, fn := range cn.opened {
+		fn(miao)
+	}
+	return miao
+}
+
+// Close lets go of the miaomiao
+func (cn *conn) Close() {
+	if cn.rec != nil {
+		cn.rec.Close()
+	}
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	if cn.cln != nil {
+		cn.cln.CancelConnection()
+	}
+}
+
+// Live is the miaomiao Each is currently reading, if any
+func (cn *conn) Live() *miao2go.ConnectedMiao {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.live
+}
+
+// Reconnect drops the live BLE connection, for Each to connect again
+func (cn *conn) Reconnect() error {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	if cn.cln == nil {
+		return fmt.Errorf("can't reconnect a replay")
+	}
+	cn.reconnected = true
+	return cn.cln.CancelConnection()
+}
+
+// reconnecting is whether the last disconnect was a Reconnect, clearing it
+func (cn *conn) reconnecting() bool {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	reconnected := cn.reconnected
+	cn.reconnected = false
+	return reconnected
+}
+
+// Each opens the miaomiao and hands each packet it sends to fn: just the
+// one with --once, otherwise for as long as it keeps sending them, or
+// after a Reconnect, the next connection does
+func (cn *conn) Each(fn func(*miao2go.ConnectedMiao, miao2go.MiaoMiaoPacket)) {
+	miao := cn.Open()
+	defer cn.Close()
+	if cn.once {
+		pkt, err := miao.ReadSensor()
+		if err != nil {
+			log.Printf("error in read attempt: %v", err)
+			return
+		}
+		fn(miao, *pkt)
+		return
+	}
+	for {
+		for pkt := range miao.ReadingEmitter(!cn.noaccept) {
+			log.Printf("packet captured in %v", pkt.EndTime.Sub(pkt.StartTime))
+			fn(miao, pkt)
+			log.Printf("next data emission scheduled for: %v", miao.NextEmit())
+		}
+		if !cn.reconnecting() {
+			return
+		}
+		log.Printf("reconnecting")
+		miao = cn.redial()
+	}
+}
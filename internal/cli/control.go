@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/thecubic/miao2go/mq"
+	"strings"
+	"sync"
+)
+
+// control carries out MQTT commands on whichever miaomiao cn is reading,
+// one at a time
+func control(cn *conn) mq.Execute {
+	var mu sync.Mutex
+	return func(cmd mq.Command) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		miao := cn.Live()
+		if miao == nil {
+			return "", fmt.Errorf("no miaomiao connected")
+		}
+		address := miao.Address()
+		if len(cmd.Transmitter) > 0 && !strings.EqualFold(cmd.Transmitter, address) {
+			// could be an alias or serial
+			if ent := cn.Registry().Lookup(cmd.Transmitter); ent == nil || ent.Address != address {
+				return address, mq.ErrNotOurs
+			}
+		}
+		switch cmd.Command {
+		case mq.CommandAccept:
+			return address, miao.RequestAccept()
+		case mq.CommandSetInterval:
+			return address, miao.SetInterval(cmd.Minutes)
+		case mq.CommandReadNow:
+			return address, miao.RequestReading()
+		case mq.CommandReconnect:
+			return address, cn.Reconnect()
+		}
+		return address, fmt.Errorf("unknown command %q", cmd.Command)
+	}
+}
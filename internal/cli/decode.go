@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"github.com/thecubic/miao2go"
+	"github.com/thecubic/miao2go/store"
+	"log"
+)
+
+// keep stores a decoded packet, if we were asked to
+func keep(st *store.Store, pkt miao2go.MiaoMiaoPacket) {
+	if _, err := st.Put(pkt); err != nil {
+		log.Printf("couldn't store packet: %v", err)
+	}
+}
+
+// decode reads a miaomiao (or a capture of one) and shows measurements
+func decode(args []string) {
+	fs, lg := newFlagSet("decode")
+	cn := connFlags(fs)
+	out := outputFlags(fs)
+	stconfig := store.Flags(fs)
+	off := offlineFlags(fs)
+	parse(fs, lg, args)
+
+	st, err := stconfig.Open()
+	if err != nil {
+		log.Fatalf("can't open store: %v", err)
+	}
+	defer st.Close()
+
+	if off.offline() {
+		off.decode(out, st)
+		return
+	}
+
+	cn.Each(func(miao *miao2go.ConnectedMiao, pkt miao2go.MiaoMiaoPacket) {
+		keep(st, pkt)
+		out.Show(pkt)
+		if stats := miao.QueueStats(); stats.Dropped > 0 || lg.verbose {
+			log.Printf("chunk queue: %v/%v deep (high water %v), %v queued, %v dropped",
+				stats.Depth, stats.Capacity, stats.HighWater, stats.Queued, stats.Dropped)
+		}
+	})
+}
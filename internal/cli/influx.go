@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"github.com/thecubic/miao2go/inf"
+	"github.com/thecubic/miao2go/queue"
+	"github.com/thecubic/miao2go/store"
+	"log"
+)
+
+// influx reads a miaomiao and sends measurements to InfluxDB
+func influx(args []string) {
+	fs, lg := newFlagSet("influx")
+	cn := connFlags(fs)
+	out := outputFlags(fs)
+	infconfig := inf.Flags(fs, "m2g-influx")
+	stconfig := store.Flags(fs)
+	qconfig := queue.Flags(fs)
+	parse(fs, lg, args)
+
+	writer, err := infconfig.Open()
+	if err != nil {
+		log.Fatalf("can't influx: %v", err)
+	}
+	sink, err := qconfig.Sink("influx", inf.NewSink(writer, infconfig.Prefix))
+	if err != nil {
+		log.Fatalf("can't open queue: %v", err)
+	}
+	deliver(cn, out, stconfig, sink)
+}
@@ -0,0 +1,221 @@
+package cli
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"github.com/thecubic/miao2go/mq"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Subscriber output formats
+const (
+	formatPrint  = "print"
+	formatTable  = "table"
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+// row is what the table and CSV formats show of a message
+type row struct {
+	time        time.Time
+	serial      string
+	transmitter string
+	kind        string
+	glucose     float64
+	rate        *float64
+	battery     int
+	problems    []miao2go.Problem
+}
+
+// rowOf is the row for a message; battery is -1 where it isn't known
+func rowOf(msg mq.Message, problems []miao2go.Problem) row {
+	switch {
+	case msg.Packet != nil:
+		pkt := msg.Packet
+		r := row{time: pkt.EndTime, serial: pkt.SerialNumber, transmitter: pkt.Transmitter,
+			kind: "packet", battery: int(pkt.BatteryPercentage), problems: problems}
+		if latest, ok := pkt.Latest(); ok {
+			r.time, r.glucose = latest.Time, latest.Glucose
+		}
+		if rate, ok := pkt.TrendRate(); ok {
+			r.rate = &rate
+		}
+		return r
+	case msg.Latest != nil:
+		return row{msg.Latest.Time, msg.Latest.Serial, msg.Latest.Transmitter, "latest",
+			msg.Latest.Glucose, msg.Latest.Rate, int(msg.Latest.Battery), nil}
+	case msg.Reading != nil:
+		return row{msg.Reading.Time, msg.Reading.Serial, "", string(msg.Reading.Kind),
+			msg.Reading.Glucose, nil, -1, nil}
+	}
+	return row{}
+}
+
+// fields are a row as text, in columns order
+func (r row) fields() []string {
+	rate, battery := "", ""
+	if r.rate != nil {
+		rate = fmt.Sprintf("%.1f", *r.rate)
+	}
+	if r.battery >= 0 {
+		battery = fmt.Sprint(r.battery)
+	}
+	var problems []string
+	for _, problem := range r.problems {
+		problems = append(problems, problem.String())
+	}
+	return []string{r.time.Format(time.RFC3339), r.serial, r.transmitter, r.kind,
+		fmt.Sprintf("%.0f", r.glucose), rate, battery, strings.Join(problems, "; ")}
+}
+
+var columns = []string{"time", "serial", "xmit", "kind", "glucose", "rate", "battery", "problems"}
+
+// tally counts what a subscriber has seen
+type tally struct {
+	received    int
+	undecodable int
+	filtered    int
+	invalid     int
+	gaps        int
+	checks      map[string]int
+}
+
+func (t tally) String() string {
+	var checks []string
+	for check, count := range t.checks {
+		checks = append(checks, fmt.Sprintf("%v %v", check, count))
+	}
+	sort.Strings(checks)
+	return fmt.Sprintf("received %v, undecodable %v, filtered %v, invalid %v (%v), gaps %v",
+		t.received, t.undecodable, t.filtered, t.invalid, strings.Join(checks, ", "), t.gaps)
+}
+
+// inspection filters, checks, counts and shows what a subscriber receives
+type inspection struct {
+	serial     string
+	xmit       string
+	minBattery int
+	format     string
+	gap        time.Duration
+	stats      time.Duration
+
+	csv     *csv.Writer
+	table   *tabwriter.Writer
+	started bool
+	last    map[string]time.Time
+	tally   tally
+}
+
+// inspectFlags registers the subscriber's filter, format and check flags
+func inspectFlags(fs *flag.FlagSet) *inspection {
+	in := &inspection{last: make(map[string]time.Time), tally: tally{checks: make(map[string]int)}}
+	fs.StringVar(&in.serial, "serial", "", "only sensors with this serial, comma separated for several")
+	fs.StringVar(&in.xmit, "xmit", "", "only miaomiaos with this address, comma separated for several")
+	fs.IntVar(&in.minBattery, "minbattery", 0, "only miaomiaos with at least this battery percentage")
+	fs.StringVar(&in.format, "format", formatPrint, "output format: print, table, ndjson or csv")
+	fs.DurationVar(&in.gap, "gap", 11*time.Minute, "report readings from a sensor further apart than this")
+	fs.DurationVar(&in.stats, "stats", time.Hour, "how often to log counts (0 for only on exit)")
+	return in
+}
+
+// Validate checks the flags make sense
+func (in *inspection) Validate() error {
+	switch in.format {
+	case formatPrint, formatTable, formatNDJSON, formatCSV:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q", in.format)
+}
+
+// oneOf is whether value is in a comma separated list, empty matching all
+func oneOf(list, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Wanted is whether a message gets past the filters; anything a message
+// doesn't say (a reading's battery, say) doesn't filter it out
+func (in *inspection) Wanted(msg mq.Message) bool {
+	r := rowOf(msg, nil)
+	wanted := oneOf(in.serial, r.serial) &&
+		(len(r.transmitter) == 0 || oneOf(in.xmit, r.transmitter)) &&
+		(r.battery < 0 || r.battery >= in.minBattery)
+	if !wanted {
+		in.tally.filtered++
+	}
+	return wanted
+}
+
+// Check validates a packet and looks for a gap since the sensor's last
+// one, counting and logging whatever's wrong
+func (in *inspection) Check(msg mq.Message) []miao2go.Problem {
+	var problems []miao2go.Problem
+	if msg.Packet != nil {
+		problems = msg.Packet.Problems()
+		if len(problems) > 0 {
+			in.tally.invalid++
+			seen := make(map[string]bool)
+			for _, problem := range problems {
+				if !seen[problem.Check] {
+					in.tally.checks[problem.Check]++
+					seen[problem.Check] = true
+				}
+				log.Printf("%v: %v", msg.Packet.SerialNumber, problem)
+			}
+		}
+	}
+	r := rowOf(msg, nil)
+	if (msg.Packet != nil || msg.Latest != nil) && len(r.serial) > 0 && !r.time.IsZero() {
+		if last, ok := in.last[r.serial]; ok && r.time.Sub(last) > in.gap {
+			in.tally.gaps++
+			log.Printf("%v: gap of %v since %v", r.serial, r.time.Sub(last), last.Format(time.RFC3339))
+		}
+		if r.time.After(in.last[r.serial]) {
+			in.last[r.serial] = r.time
+		}
+	}
+	return problems
+}
+
+// Show shows a message in the chosen format
+func (in *inspection) Show(out *output, msg mq.Message, problems []miao2go.Problem) {
+	switch in.format {
+	case formatNDJSON:
+		out.json = true
+		out.ShowMessage(msg)
+	case formatTable:
+		// rows are flushed as they come, so they line up by the columns'
+		// minimum width rather than waiting to see every row
+		if !in.started {
+			in.table = tabwriter.NewWriter(os.Stdout, 8, 8, 2, ' ', 0)
+			fmt.Fprintln(in.table, strings.ToUpper(strings.Join(columns, "\t")))
+			in.started = true
+		}
+		fmt.Fprintln(in.table, strings.Join(rowOf(msg, problems).fields(), "\t"))
+		in.table.Flush()
+	case formatCSV:
+		if !in.started {
+			in.csv = csv.NewWriter(os.Stdout)
+			in.csv.Write(columns)
+			in.started = true
+		}
+		in.csv.Write(rowOf(msg, problems).fields())
+		in.csv.Flush()
+	default:
+		out.ShowMessage(msg)
+	}
+}
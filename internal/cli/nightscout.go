@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"github.com/thecubic/miao2go/nightscout"
+	"github.com/thecubic/miao2go/queue"
+	"github.com/thecubic/miao2go/store"
+	"log"
+)
+
+// nightscoutUpload reads a miaomiao and uploads measurements to Nightscout
+func nightscoutUpload(args []string) {
+	fs, lg := newFlagSet("nightscout")
+	cn := connFlags(fs)
+	out := outputFlags(fs)
+	nsconfig := nightscout.Flags(fs)
+	stconfig := store.Flags(fs)
+	qconfig := queue.Flags(fs)
+	parse(fs, lg, args)
+
+	client, err := nsconfig.Open()
+	if err != nil {
+		log.Fatalf("can't nightscout: %v", err)
+	}
+	sink, err := qconfig.Sink("nightscout", nightscout.NewSink(client, nsconfig.Device, nsconfig.Backfill))
+	if err != nil {
+		log.Fatalf("can't open queue: %v", err)
+	}
+	deliver(cn, out, stconfig, sink)
+}
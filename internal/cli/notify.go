@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"github.com/thecubic/miao2go/alert"
+	"github.com/thecubic/miao2go/pipeline"
+	"log"
+	"os"
+	"time"
+)
+
+// notifyTest sends a made up alert to every notifier, to check they work
+func notifyTest(args []string) {
+	fs, lg := newFlagSet("notify")
+	config := fs.String("config", "", "pipeline file whose alert sinks' notifiers to try")
+	alconfig := alert.Flags(fs)
+	urgent := fs.Bool("urgent", false, "send it as urgent")
+	parse(fs, lg, args)
+
+	configs := []*alert.Config{alconfig}
+	if len(*config) > 0 {
+		plc, err := pipeline.Load(*config)
+		if err != nil {
+			log.Fatalf("bad pipeline: %v", err)
+		}
+		configs = nil
+		for _, sink := range plc.Sinks {
+			if sink.Type == pipeline.SinkAlert {
+				configs = append(configs, sink.Alert)
+			}
+		}
+		if len(configs) == 0 {
+			log.Fatalf("no alert sinks in %v", *config)
+		}
+	}
+	now := time.Now()
+	test := alert.Alert{
+		ID:       "test/" + now.Format(time.RFC3339),
+		Kind:     alert.Low,
+		Severity: alert.Warning,
+		State:    alert.Firing,
+		Serial:   "test",
+		Start:    now,
+		Time:     now,
+		Glucose:  69,
+		Message:  "test alert from miao2go, nothing's wrong",
+	}
+	if *urgent {
+		test.Kind, test.Severity, test.Glucose = alert.UrgentLow, alert.Urgent, 54
+	}
+	failed := false
+	for _, ac := range configs {
+		for _, nc := range ac.Notifiers {
+			label := nc.Type
+			if len(nc.Name) > 0 {
+				label = nc.Name
+			}
+			notifier, err := nc.Open()
+			if err == nil {
+				err = notifier.Notify(test)
+			}
+			if err != nil {
+				log.Printf("%v: %v", label, err)
+				failed = true
+				continue
+			}
+			log.Printf("%v: sent", label)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
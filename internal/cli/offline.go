@@ -0,0 +1,145 @@
+package cli
+
+// offline decoding of captured frames, for triaging other people's data
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"github.com/thecubic/miao2go/store"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// offline is what to decode, if not a miaomiao
+type offline struct {
+	file    string
+	hexdata string
+	stdin   bool
+	at      string
+}
+
+// offlineFlags registers the offline decoding flags on a flag set
+func offlineFlags(fs *flag.FlagSet) *offline {
+	off := &offline{}
+	fs.StringVar(&off.file, "file", "", "decode binary miaomiao frames or a Libre FRAM dump from this file")
+	fs.StringVar(&off.hexdata, "hex", "", "decode a hex miaomiao frame or Libre FRAM dump (- for stdin)")
+	fs.BoolVar(&off.stdin, "stdin", false, "decode NDJSON MiaoMiaoPackets from stdin")
+	fs.StringVar(&off.at, "at", "", "capture time (RFC3339) for frames that don't carry one")
+	return off
+}
+
+// offline is whether we've been asked to decode something other than a miao
+func (off *offline) offline() bool {
+	return len(off.file) > 0 || len(off.hexdata) > 0 || off.stdin
+}
+
+// splitFrames breaks captured bytes into frames; a capture is either one
+// FRAM dump or any number of miaomiao frames back-to-back
+func splitFrames(data []byte) ([][]byte, error) {
+	if len(data) == miao2go.LibreFRAMLength {
+		return [][]byte{data}, nil
+	}
+	if len(data) == 0 || len(data)%miao2go.MiaoFrameLength != 0 {
+		return nil, fmt.Errorf("%v bytes is neither a FRAM dump nor whole miaomiao frames", len(data))
+	}
+	var frames [][]byte
+	for ; len(data) > 0; data = data[miao2go.MiaoFrameLength:] {
+		frames = append(frames, data[:miao2go.MiaoFrameLength])
+	}
+	return frames, nil
+}
+
+// decode decodes whichever of --file, --hex or --stdin was given; decoded
+// packets are always shown
+func (off *offline) decode(out *output, st *store.Store) {
+	var (
+		data []byte
+		err  error
+	)
+	if !out.json {
+		out.print = true
+	}
+	captureTime := time.Now()
+	if len(off.at) > 0 {
+		captureTime, err = time.Parse(time.RFC3339, off.at)
+		if err != nil {
+			log.Fatalf("bad capture time: %v", err)
+		}
+	}
+
+	if off.stdin {
+		decodeNDJSON(os.Stdin, out, st)
+		return
+	}
+
+	if len(off.file) > 0 {
+		data, err = ioutil.ReadFile(off.file)
+		if err != nil {
+			log.Fatalf("couldn't read %v: %v", off.file, err)
+		}
+		if len(off.at) == 0 {
+			if info, err := os.Stat(off.file); err == nil {
+				captureTime = info.ModTime()
+			}
+		}
+	} else {
+		text := off.hexdata
+		if text == "-" {
+			raw, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatalf("couldn't read stdin: %v", err)
+			}
+			text = string(raw)
+		}
+		data, err = hex.DecodeString(strings.Join(strings.Fields(text), ""))
+		if err != nil {
+			log.Fatalf("couldn't decode hex: %v", err)
+		}
+	}
+
+	frames, err := splitFrames(data)
+	if err != nil {
+		log.Fatalf("couldn't decode: %v", err)
+	}
+	for idx, frame := range frames {
+		pkt, err := miao2go.DecodeFrame(frame, captureTime)
+		if err != nil {
+			log.Printf("frame %v: %v", idx, err)
+			continue
+		}
+		keep(st, *pkt)
+		out.Show(*pkt)
+	}
+}
+
+// decodeNDJSON re-decodes MiaoMiaoPackets, one JSON object per line
+func decodeNDJSON(r io.Reader, out *output, st *store.Store) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var mmp miao2go.MiaoMiaoPacket
+		if err := json.Unmarshal(scanner.Bytes(), &mmp); err != nil {
+			log.Printf("line %v: err in Unmarshal: %v", line, err)
+			continue
+		}
+		pkt := mmp.Redecode()
+		keep(st, pkt)
+		out.Show(pkt)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("couldn't read stdin: %v", err)
+	}
+}
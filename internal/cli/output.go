@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"github.com/thecubic/miao2go/mq"
+	"log"
+	"os"
+)
+
+// output is how a command shows the packets it handles
+type output struct {
+	print   bool
+	json    bool
+	encoder *json.Encoder
+}
+
+// outputFlags registers the output flags on a flag set
+func outputFlags(fs *flag.FlagSet) *output {
+	out := &output{encoder: json.NewEncoder(os.Stdout)}
+	fs.BoolVar(&out.print, "print", false, "print out packet details")
+	fs.BoolVar(&out.json, "json", false, "output packets as NDJSON")
+	return out
+}
+
+// ShowMessage shows a message in any MQTT payload schema
+func (out *output) ShowMessage(msg mq.Message) {
+	switch {
+	case msg.Packet != nil:
+		out.Show(*msg.Packet)
+	case out.json && msg.Latest != nil:
+		out.encode(msg.Latest)
+	case out.json && msg.Reading != nil:
+		out.encode(msg.Reading)
+	case out.print && msg.Latest != nil:
+		fmt.Printf("Latest: %v %v mg/dL (raw %v) at %v, battery %v%%\n",
+			msg.Latest.Serial, msg.Latest.Glucose, msg.Latest.Raw, msg.Latest.Time, msg.Latest.Battery)
+	case out.print && msg.Reading != nil:
+		fmt.Printf("Reading: %v %v #%v %v mg/dL (raw %v) at %v\n",
+			msg.Reading.Serial, msg.Reading.Kind, msg.Reading.Index, msg.Reading.Glucose, msg.Reading.Raw, msg.Reading.Time)
+	}
+}
+
+// encode writes a value as a line of NDJSON
+func (out *output) encode(value interface{}) {
+	if err := out.encoder.Encode(value); err != nil {
+		log.Printf("couldn't encode: %v", err)
+	}
+}
+
+// Show shows a packet, if we were asked to
+func (out *output) Show(pkt miao2go.MiaoMiaoPacket) {
+	if out.json {
+		out.encode(pkt)
+	} else if out.print {
+		pkt.Print()
+		if pkt.LibrePacket != nil {
+			pkt.LibrePacket.Print()
+		}
+	}
+}
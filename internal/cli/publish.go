@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"github.com/thecubic/miao2go/mq"
+	"github.com/thecubic/miao2go/queue"
+	"github.com/thecubic/miao2go/store"
+	"log"
+)
+
+// publish reads a miaomiao and MQ publishes measurements
+func publish(args []string) {
+	fs, lg := newFlagSet("publish")
+	cn := connFlags(fs)
+	out := outputFlags(fs)
+	mqconfig := mq.Flags(fs, "m2g-mqp", 0)
+	stconfig := store.Flags(fs)
+	qconfig := queue.Flags(fs)
+	parse(fs, lg, args)
+
+	// the broker is connected to in the background; packets queue up meanwhile
+	var execute mq.Execute
+	if mqconfig.Commands {
+		execute = control(cn)
+	}
+	client, err := mqconfig.ConnectCommands(execute)
+	if err != nil {
+		log.Fatalf("can't MQTT: %v", err)
+	}
+	sink, err := qconfig.Sink("mqtt", mq.NewSink(client, mqconfig, cn.timeout))
+	if err != nil {
+		log.Fatalf("can't open queue: %v", err)
+	}
+	deliver(cn, out, stconfig, sink)
+}
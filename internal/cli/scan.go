@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/currantlabs/ble"
+	"github.com/thecubic/miao2go"
+	"golang.org/x/net/context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// scan finds miaomiao transcievers, or names one
+func scan(args []string) {
+	fs, lg := newFlagSet("scan")
+	timeout := fs.Duration("timeout", 10*time.Second, "how long to scan for")
+	miao := fs.String("miao", "", "address of the miaomiao to alias")
+	alias := fs.String("alias", "", "give the --miao address this alias in the registry")
+	registry := fs.String("registry", miao2go.DefaultRegistryPath(), "miaomiao registry file")
+	parse(fs, lg, args)
+
+	reg, err := miao2go.LoadRegistry(*registry)
+	if err != nil {
+		log.Fatalf("can't load registry: %v", err)
+	}
+
+	if len(*alias) > 0 {
+		if len(*miao) == 0 {
+			log.Fatalf("must pass miao to alias")
+		}
+		if err = reg.SetAlias(*miao, *alias); err != nil {
+			log.Fatalf("couldn't set alias: %v", err)
+		}
+		log.Printf("%v is now known as %v", *miao, *alias)
+		return
+	}
+
+	setupBLE()
+	ctx := ble.WithSigHandler(context.WithTimeout(context.Background(), *timeout))
+
+	log.Printf("scanning for %v", *timeout)
+	found := make(map[string]bool)
+	filter := miao2go.MiaoFilter(miao2go.SelectFirst, reg)
+	handler := func(adv ble.Advertisement) {
+		found[strings.ToLower(adv.Address().String())] = true
+	}
+	err = ble.Scan(ctx, false, handler, filter)
+	if err != nil && err != context.DeadlineExceeded && err != context.Canceled {
+		log.Printf("scan ended: %v", err)
+	}
+	if err = reg.Save(); err != nil {
+		log.Printf("couldn't save registry: %v", err)
+	}
+
+	entries := reg.Entries()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Address < entries[j].Address })
+	for _, ent := range entries {
+		seen := " "
+		if found[ent.Address] {
+			seen = "*"
+		}
+		fmt.Printf("%s %v\talias=%q\tname=%q\tserial=%q\tlast seen %v\n",
+			seen, ent.Address, ent.Alias, ent.Name, ent.Serial, ent.LastSeen.Format(time.RFC3339))
+	}
+}
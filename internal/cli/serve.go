@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"github.com/thecubic/miao2go"
+	"github.com/thecubic/miao2go/nightscout"
+	"github.com/thecubic/miao2go/store"
+	"log"
+	"net/http"
+	"time"
+)
+
+// serve reads a miaomiao and serves measurements like a Nightscout site
+func serve(args []string) {
+	fs, lg := newFlagSet("serve")
+	cn := connFlags(fs)
+	out := outputFlags(fs)
+	stconfig := store.Flags(fs)
+	listen := fs.String("listen", ":1337", "address to serve the Nightscout API on")
+	keepFor := fs.Duration("keep", 24*time.Hour, "how long to keep readings around for")
+	device := fs.String("device", "miao2go", "device name to report readings as from")
+	name := fs.String("name", "miao2go", "site name to report")
+	parse(fs, lg, args)
+
+	st, err := stconfig.Open()
+	if err != nil {
+		log.Fatalf("can't open store: %v", err)
+	}
+	defer st.Close()
+
+	recent := nightscout.NewRecent(*keepFor, *device)
+	if st != nil {
+		// pick up where we left off
+		packets, err := st.Packets("", time.Now().Add(-*keepFor), time.Time{})
+		if err != nil {
+			log.Fatalf("can't read store: %v", err)
+		}
+		for _, pkt := range packets {
+			recent.Add(pkt)
+		}
+		log.Printf("loaded %v packets from %v", len(packets), stconfig.Dir)
+	}
+	go func() {
+		log.Printf("serving on %v", *listen)
+		log.Fatal(http.ListenAndServe(*listen, nightscout.NewServer(recent, *name)))
+	}()
+
+	cn.Each(func(miao *miao2go.ConnectedMiao, pkt miao2go.MiaoMiaoPacket) {
+		out.Show(pkt)
+		keep(st, pkt)
+		recent.Add(pkt)
+	})
+}
@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"github.com/thecubic/miao2go"
+	"github.com/thecubic/miao2go/queue"
+	"github.com/thecubic/miao2go/store"
+	"log"
+)
+
+// deliver reads the miaomiao and hands its packets to a queued sink,
+// storing and showing them on the way.  BLE state changes go to the sink
+// as events
+func deliver(cn *conn, out *output, stconfig *store.Config, sink *queue.Sink) {
+	defer sink.Close()
+	st, err := stconfig.Open()
+	if err != nil {
+		log.Fatalf("can't open store: %v", err)
+	}
+	defer st.Close()
+
+	cn.OnOpen(func(miao *miao2go.ConnectedMiao) {
+		events := miao.Events()
+		go func() {
+			for event := range events {
+				if err := sink.WriteEvent(event); err != nil {
+					log.Printf("couldn't deliver event: %v", err)
+				}
+			}
+		}()
+	})
+	cn.Each(func(miao *miao2go.ConnectedMiao, pkt miao2go.MiaoMiaoPacket) {
+		out.Show(pkt)
+		keep(st, pkt)
+		if err := sink.WriteReading(pkt); err != nil {
+			log.Printf("couldn't queue packet: %v", err)
+		}
+		log.Printf("%v packets waiting to be delivered", sink.Queue().Depth())
+	})
+	if cn.once && !sink.Queue().Wait(cn.timeout) {
+		log.Printf("gave up delivering, %v packets left queued", sink.Queue().Depth())
+	}
+}
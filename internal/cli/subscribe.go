@@ -0,0 +1,307 @@
+package cli
+
+import (
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/thecubic/miao2go"
+	"github.com/thecubic/miao2go/inf"
+	"github.com/thecubic/miao2go/mq"
+	"github.com/thecubic/miao2go/nightscout"
+	"github.com/thecubic/miao2go/store"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// relay passes messages on in order without ever blocking the sender for
+// long, queueing them while the receiver is busy; a handler that blocks
+// holds up paho's router, keepalives and all
+func relay(incoming <-chan mqtt.Message, messages chan<- mqtt.Message) {
+	var queued []mqtt.Message
+	for {
+		var out chan<- mqtt.Message
+		var next mqtt.Message
+		if len(queued) > 0 {
+			out, next = messages, queued[0]
+		}
+		select {
+		case msg := <-incoming:
+			queued = append(queued, msg)
+		case out <- next:
+			queued = queued[1:]
+		}
+	}
+}
+
+// subscription subscribes to every packet topic, (re)subscribing whenever
+// the broker is (re)connected.  With manualAck the session persists and
+// messages are only acknowledged when the caller says so, so anything
+// that arrived while the caller was away is delivered on reconnect
+func subscription(config *mq.Config, manualAck bool) (mqtt.Client, <-chan mqtt.Message) {
+	incoming := make(chan mqtt.Message)
+	messages := make(chan mqtt.Message)
+	go relay(incoming, messages)
+	topic := config.SubscribeTopic()
+	opts, err := config.Options()
+	if err != nil {
+		log.Fatalf("can't MQTT: %v", err)
+	}
+	if manualAck {
+		opts.SetCleanSession(false)
+		opts.SetAutoAckDisabled(true)
+	}
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		token := client.Subscribe(topic, byte(config.QoS), func(client mqtt.Client, msg mqtt.Message) {
+			incoming <- msg
+		})
+		if token.Wait() && token.Error() != nil {
+			log.Printf("couldn't subscribe to %v: %v", topic, token.Error())
+		}
+	})
+	client := mqtt.NewClient(opts)
+	client.Connect()
+	return client, messages
+}
+
+// unmarshal decodes a published payload, whatever its schema; one that
+// won't decode never will, so it's acknowledged and forgotten
+func unmarshal(msg mqtt.Message) (mq.Message, bool) {
+	log.Printf("[%v] %v", msg.MessageID(), msg.Topic())
+	decoded, err := mq.Decode(msg.Payload())
+	if err != nil {
+		log.Printf("err in Decode: %v", err)
+		msg.Ack()
+		return decoded, false
+	}
+	return decoded, true
+}
+
+// unmarshalPacket is unmarshal for subscribers that need whole packets;
+// compact and per-reading payloads don't carry enough, so they're skipped
+func unmarshalPacket(msg mqtt.Message) (miao2go.MiaoMiaoPacket, bool) {
+	decoded, ok := unmarshal(msg)
+	if !ok {
+		return miao2go.MiaoMiaoPacket{}, false
+	}
+	if decoded.Packet == nil {
+		log.Printf("skipping, only full and binary payloads carry packets")
+		msg.Ack()
+		return miao2go.MiaoMiaoPacket{}, false
+	}
+	return *decoded.Packet, true
+}
+
+// subscribe MQ subscribes and shows measurements
+func subscribe(args []string) {
+	fs, lg := newFlagSet("subscribe")
+	out := outputFlags(fs)
+	in := inspectFlags(fs)
+	mqconfig := mq.Flags(fs, "m2g-mqs", 0)
+	stconfig := store.Flags(fs)
+	parse(fs, lg, args)
+	if out.json && in.format == formatPrint {
+		in.format = formatNDJSON
+	}
+	if err := in.Validate(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	out.print = true
+
+	st, err := stconfig.Open()
+	if err != nil {
+		log.Fatalf("can't open store: %v", err)
+	}
+	defer st.Close()
+
+	client, messages := subscription(mqconfig, false)
+	defer client.Disconnect(250)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	var ticks <-chan time.Time
+	if in.stats > 0 {
+		ticker := time.NewTicker(in.stats)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case msg := <-messages:
+			in.tally.received++
+			decoded, ok := unmarshal(msg)
+			if !ok {
+				in.tally.undecodable++
+				continue
+			}
+			if !in.Wanted(decoded) {
+				continue
+			}
+			problems := in.Check(decoded)
+			in.Show(out, decoded, problems)
+			// bad packets are shown, but not kept
+			if decoded.Packet != nil && len(problems) == 0 {
+				keep(st, *decoded.Packet)
+			}
+		case <-ticks:
+			log.Printf("%v", in.tally)
+		case <-signals:
+			log.Printf("%v", in.tally)
+			return
+		}
+	}
+}
+
+// subscribeInflux MQ subscribes and sends measurements to InfluxDB
+func subscribeInflux(args []string) {
+	fs, lg := newFlagSet("subscribe-influx")
+	out := outputFlags(fs)
+	mqconfig := mq.Flags(fs, "m2g-mqs-influx", 1)
+	infconfig := inf.Flags(fs, "m2g-mqs-influx")
+	stconfig := store.Flags(fs)
+	dedupe := fs.Duration("dedupe", 12*time.Hour, "how long to remember readings already written")
+	retry := fs.Duration("retry", 30*time.Second, "how often to try points influxdb refused again")
+	backlog := fs.Int("backlog", 100000, "most points to hold unacknowledged before pausing the subscription")
+	parse(fs, lg, args)
+
+	writer, err := infconfig.Open()
+	if err != nil {
+		log.Fatalf("can't influx: %v", err)
+	}
+	defer writer.Close()
+
+	st, err := stconfig.Open()
+	if err != nil {
+		log.Fatalf("can't open store: %v", err)
+	}
+	defer st.Close()
+
+	client, messages := subscription(mqconfig, true)
+	defer client.Disconnect(250)
+	written := miao2go.NewDeduper(*dedupe)
+	// a message is only marked and acknowledged once the write covering
+	// its points succeeds; until then it's held with its readings, and the
+	// broker redelivers it if we go away first
+	type held struct {
+		msg      mqtt.Message
+		readings []miao2go.GlucoseReading
+	}
+	var pending []held
+	var points []inf.Point
+	holding := map[string]bool{}
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if len(points) > 0 {
+			if err := writer.Write(points); err != nil {
+				log.Printf("couldn't write %v points to influxdb, holding %v messages: %v", len(points), len(pending), err)
+				return
+			}
+		}
+		for _, h := range pending {
+			written.Mark(h.readings)
+			h.msg.Ack()
+		}
+		log.Printf("wrote %v points, acknowledged %v messages", len(points), len(pending))
+		pending, points, holding = nil, nil, map[string]bool{}
+	}
+	ticker := time.NewTicker(*retry)
+	defer ticker.Stop()
+	for {
+		// stop taking messages while too much is waiting on influxdb;
+		// they stay unacknowledged rather than being dropped
+		incoming := messages
+		if len(points) >= *backlog {
+			incoming = nil
+		}
+		select {
+		case msg := <-incoming:
+			mmp, ok := unmarshalPacket(msg)
+			if !ok {
+				continue
+			}
+			out.Show(mmp)
+			keep(st, mmp)
+			var fresh []miao2go.GlucoseReading
+			for _, reading := range written.Fresh(mmp.Readings()) {
+				if !holding[reading.Key()] {
+					holding[reading.Key()] = true
+					fresh = append(fresh, reading)
+				}
+			}
+			pending = append(pending, held{msg, fresh})
+			points = append(points, inf.PacketPoints(mmp, fresh, infconfig.Prefix)...)
+			log.Printf("%v readings already written", len(mmp.Readings())-len(fresh))
+			flush()
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// subscribeNightscout MQ subscribes and uploads measurements to Nightscout
+func subscribeNightscout(args []string) {
+	fs, lg := newFlagSet("subscribe-nightscout")
+	out := outputFlags(fs)
+	mqconfig := mq.Flags(fs, "m2g-mqs-ns", 1)
+	nsconfig := nightscout.Flags(fs)
+	stconfig := store.Flags(fs)
+	retry := fs.Duration("retry", 30*time.Second, "how often to try uploads nightscout refused again")
+	backlog := fs.Int("backlog", 1000, "most packets to hold unacknowledged before pausing the subscription")
+	parse(fs, lg, args)
+
+	nsc, err := nsconfig.Open()
+	if err != nil {
+		log.Fatalf("can't nightscout: %v", err)
+	}
+
+	st, err := stconfig.Open()
+	if err != nil {
+		log.Fatalf("can't open store: %v", err)
+	}
+	defer st.Close()
+
+	client, messages := subscription(mqconfig, true)
+	defer client.Disconnect(250)
+	// paho won't redeliver a message it's already handed over, so packets
+	// that couldn't be uploaded are held, unacknowledged, and tried again
+	// in order; each is acknowledged once it's on the site
+	type held struct {
+		msg mqtt.Message
+		mmp miao2go.MiaoMiaoPacket
+	}
+	var pending []held
+	flush := func() {
+		for len(pending) > 0 {
+			posted, err := nsc.Upload(pending[0].mmp, nsconfig.Device, nsconfig.Backfill)
+			if err != nil {
+				log.Printf("couldn't upload to nightscout, holding %v packets: %v", len(pending), err)
+				return
+			}
+			pending[0].msg.Ack()
+			pending = pending[1:]
+			log.Printf("uploaded %v entries", posted)
+		}
+	}
+	ticker := time.NewTicker(*retry)
+	defer ticker.Stop()
+	for {
+		incoming := messages
+		if len(pending) >= *backlog {
+			incoming = nil
+		}
+		select {
+		case msg := <-incoming:
+			mmp, ok := unmarshalPacket(msg)
+			if !ok {
+				continue
+			}
+			out.Show(mmp)
+			keep(st, mmp)
+			pending = append(pending, held{msg, mmp})
+			flush()
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
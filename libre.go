@@ -109,14 +109,10 @@ func CreateLibrePacket(data [344]byte, serialNumber string, captureTime time.Tim
 		thisHistory  int
 	)
 
-	// TODO: actually figure out CRC
+	// each covers the rest of its block; see CheckCRCs
 	xmit_crcs[0] = binary.LittleEndian.Uint16(data[0:2])
 	xmit_crcs[1] = binary.LittleEndian.Uint16(data[24:26])
 	xmit_crcs[2] = binary.LittleEndian.Uint16(data[320:322])
-	// 2:24
-	// 26:320
-	// 322:344
-	//import "github.com/howeyc/crc16"
 
 	minutes = binary.LittleEndian.Uint16(data[335:337])
 	trendIndex = int(data[26])
@@ -180,6 +176,34 @@ const (
 	SSFailed SensorStatus = 0x06
 )
 
+// sensorStatusOffset is where the sensor keeps its SensorStatus
+const sensorStatusOffset = 4
+
+func (ss SensorStatus) String() string {
+	switch ss {
+	case SSUnknown:
+		return "unknown"
+	case SSNotStarted:
+		return "not-started"
+	case SSStarting:
+		return "starting"
+	case SSReady:
+		return "ready"
+	case SSExpired:
+		return "expired"
+	case SSShutdown:
+		return "shutdown"
+	case SSFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("SensorStatus(%#02x)", byte(ss))
+}
+
+// Status is what the sensor says it's up to
+func (lpkt *LibrePacket) Status() SensorStatus {
+	return SensorStatus(lpkt.Data[sensorStatusOffset])
+}
+
 func (lpkt *LibrePacket) Print() {
 	fmt.Printf("LibrePacket:\n")
 	fmt.Printf("  SerialNumber: %v\n", lpkt.SerialNumber)
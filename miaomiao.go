@@ -50,19 +50,98 @@ type MiaoMiaoPacket struct {
 	StartTime         time.Time    `json:"start"`
 	EndTime           time.Time    `json:"end"`
 	LibrePacket       *LibrePacket `json:"libre"`
+	Transmitter       string       `json:"xmit,omitempty"`
+	// Processed, if set, stands in for the readings decoded from the
+	// sensor, after calibration, smoothing and the like.  Glucose is
+	// always in mg/dL; Units is only how it should be shown
+	Processed []GlucoseReading `json:"readings,omitempty"`
+	Units     string           `json:"units,omitempty"`
 }
 
 // gattDataCallback handles the trigger of data callback and shuffles said data
-// to the objects data channel for deserialization elsewhere
+// to the objects data channel for deserialization elsewhere.  It runs on
+// the BLE stack's goroutine, so must never block; if the chunk queue is
+// full the chunk is dropped and counted
 func (lcm *ConnectedMiao) gattDataCallback(data []byte) {
-	if lcm.BtState == MSSubscribed {
-		// log.Printf("MSSubscribed -> MSBeingNotified")
-		lcm.BtState = MSBeingNotified
-		// log.Printf("lastEmit: %v", lcm.LastEmit)
-		lcm.LastEmit = time.Now()
-		// log.Printf("thisEmit: %v", lcm.LastEmit)
+	lcm.gattData(data, time.Now(), false)
+}
+
+// gattData is gattDataCallback for data that arrived at a given time,
+// optionally waiting for room in the chunk queue rather than dropping
+func (lcm *ConnectedMiao) gattData(data []byte, when time.Time, block bool) {
+	if lcm.recorder != nil {
+		lcm.recorder.Record(RecordNotify, targetXmit, data)
+	}
+	lcm.mu.Lock()
+	starting := lcm.btState == MSSubscribed
+	if starting {
+		lcm.lastEmit = when
+	}
+	lcm.mu.Unlock()
+	if starting {
+		lcm.setBtState(MSBeingNotified, when)
+	}
+	// the BLE stack may well reuse its buffer once we return
+	copied := make([]byte, len(data))
+	copy(copied, data)
+	chunk := gattResponsePacket{copied, when}
+	if block {
+		lcm.datachan <- chunk
+	} else {
+		select {
+		case lcm.datachan <- chunk:
+		default:
+			lcm.mu.Lock()
+			lcm.queueStats.Dropped++
+			lcm.mu.Unlock()
+			return
+		}
 	}
-	lcm.datachan <- gattResponsePacket{data, time.Now()}
+	lcm.mu.Lock()
+	lcm.queueStats.Queued++
+	if depth := len(lcm.datachan); depth > lcm.queueStats.HighWater {
+		lcm.queueStats.HighWater = depth
+	}
+	lcm.mu.Unlock()
+}
+
+// ChunkQueueStats accounts for GATT chunks on their way to MiaoResponse
+type ChunkQueueStats struct {
+	// Queued is how many chunks made it into the queue
+	Queued uint64
+	// Dropped is how many chunks were thrown away as the queue was full
+	Dropped uint64
+	// Depth is how many chunks are waiting right now, out of Capacity
+	Depth    int
+	Capacity int
+	// HighWater is the deepest the queue has been
+	HighWater int
+}
+
+// QueueStats returns the current chunk queue accounting
+func (lcm *ConnectedMiao) QueueStats() ChunkQueueStats {
+	lcm.mu.Lock()
+	defer lcm.mu.Unlock()
+	stats := lcm.queueStats
+	stats.Depth = len(lcm.datachan)
+	stats.Capacity = cap(lcm.datachan)
+	return stats
+}
+
+// droppedChunks is how many chunks have been dropped so far
+func (lcm *ConnectedMiao) droppedChunks() uint64 {
+	lcm.mu.Lock()
+	defer lcm.mu.Unlock()
+	return lcm.queueStats.Dropped
+}
+
+// knownResponseType is whether a byte could be the start of a response
+func knownResponseType(first byte) bool {
+	switch MiaoDeviceState(first) {
+	case MPLibre, MPNewSensor, MPNoSensor:
+		return true
+	}
+	return false
 }
 
 // MiaoResponse reads an active BTLE datastream to a packet structure
@@ -73,9 +152,18 @@ func (lcm *ConnectedMiao) MiaoResponse() (*MiaoResponsePacket, error) {
 	var packetData [363]byte
 	packetOffset := 0
 	packetFinished := false
-	response = &MiaoResponsePacket{MPDeclared, packetData, nil, lcm.LastEmit, time.Time{}}
+	response = &MiaoResponsePacket{MPDeclared, packetData, nil, lcm.LastEmit(), time.Time{}}
+	dropped := lcm.droppedChunks()
 	for packetFinished == false {
-		gattpacket, ok := <-lcm.datachan
+		var (
+			gattpacket gattResponsePacket
+			ok         bool
+		)
+		select {
+		case gattpacket, ok = <-lcm.datachan:
+		case <-lcm.hangup:
+			return nil, fmt.Errorf("disconnected")
+		}
 		if response.StartTime.IsZero() {
 			response.StartTime = gattpacket.time
 		}
@@ -83,24 +171,39 @@ func (lcm *ConnectedMiao) MiaoResponse() (*MiaoResponsePacket, error) {
 		if !ok {
 			return nil, fmt.Errorf("datachan hangup")
 		}
+		if nowDropped := lcm.droppedChunks(); nowDropped != dropped {
+			if packetOffset > 0 {
+				// the rest of this one is gone; start over at the next header
+				log.Printf("chunk queue overflowed, lost %v chunks mid-packet; resyncing", nowDropped-dropped)
+				packetData = [363]byte{}
+				response = &MiaoResponsePacket{MPDeclared, packetData, nil, lcm.LastEmit(), time.Time{}}
+				packetOffset = 0
+			}
+			dropped = nowDropped
+		}
+		if packetOffset == 0 && gattpacketlength > 0 && !knownResponseType(gattpacket.data[0]) {
+			// the tail of a packet we lost the start of; wait for the next
+			log.Printf("skipping chunk not at the start of a packet")
+			continue
+		}
 		// log.Printf("recv'd from %v - %v", packetOffset, packetOffset+gattpacketlength)
 		if gattpacketlength < 10 && response.Type == MPDeclared {
 			log.Printf("got: %v", gattpacket.data)
 		}
-		copied := copy(response.Data[packetOffset:packetOffset+gattpacketlength], gattpacket.data)
+		copied := copy(response.Data[packetOffset:], gattpacket.data)
 		if packetOffset == 0 && copied >= 1 {
 			switch response.Data[0] {
 			case byte(MPNoSensor):
 				response.Type = MPNoSensor
-				lcm.DevState = MPNoSensor
+				lcm.setDevState(MPNoSensor)
 				packetFinished = true
 			case byte(MPNewSensor):
 				response.Type = MPNewSensor
-				lcm.DevState = MPNewSensor
+				lcm.setDevState(MPNewSensor)
 				packetFinished = true
 			case byte(MPLibre):
 				response.Type = MPLibre
-				lcm.DevState = MPLibre
+				lcm.setDevState(MPLibre)
 				packetFinished = false
 			}
 		}
@@ -111,7 +214,9 @@ func (lcm *ConnectedMiao) MiaoResponse() (*MiaoResponsePacket, error) {
 			response.EndTime = gattpacket.time
 		}
 	}
-	lcm.BtState = MSSubscribed
+	if err := lcm.setBtState(MSSubscribed, time.Now()); err != nil && lcm.BtState() != MSDisconnected {
+		log.Printf("after response: %v", err)
+	}
 	return response, nil
 }
 
@@ -122,14 +227,18 @@ func (lcm *ConnectedMiao) Subscribe() error {
 	if err = lcm.client.Subscribe(lcm.nrfXmitChar, false, lcm.gattDataCallback); err != nil {
 		log.Fatalf("XMIT subscribe failed: %s", err)
 	}
-	lcm.BtState = MSSubscribed
+	if err = lcm.setBtState(MSSubscribed, time.Now()); err != nil {
+		return err
+	}
 	// only know the one
+	lcm.mu.Lock()
 	lcm.emitInterval = time.Duration(5 * time.Minute)
-	err = lcm.client.WriteDescriptor(lcm.clientDesc, []byte{0x01, 0x00})
+	lcm.mu.Unlock()
+	err = lcm.writeDesc([]byte{0x01, 0x00})
 	if err != nil {
 		return fmt.Errorf("error in first write: %v", err)
 	}
-	err = lcm.client.WriteCharacteristic(lcm.nrfRecvChar, []byte{0xf0}, false)
+	err = lcm.writeRecv([]byte{0xf0})
 	if err != nil {
 		return fmt.Errorf("error in hollaback write: %v", err)
 	}
@@ -140,28 +249,67 @@ func (lcm *ConnectedMiao) Subscribe() error {
 // which has not yet been read by this device (it's like pairing)
 // note: does not work
 func (lcm *ConnectedMiao) AcceptNewSensor() error {
+	if err := lcm.RequestAccept(); err != nil {
+		return err
+	}
+	// eat two GATT responses
+	for eaten := 0; eaten < 2; eaten++ {
+		select {
+		case _, ok := <-lcm.datachan:
+			if !ok {
+				return fmt.Errorf("datachan hangup")
+			}
+		case <-lcm.hangup:
+			return fmt.Errorf("disconnected")
+		}
+	}
+	return nil
+}
+
+// RequestAccept sends the accept-new-sensor commands, leaving the
+// responses to whoever is reading packets (a ReadingEmitter, say)
+func (lcm *ConnectedMiao) RequestAccept() error {
 	var err error
-	err = lcm.client.WriteCharacteristic(lcm.nrfRecvChar, []byte{0xd3, 0xd1}, false)
+	err = lcm.writeRecv([]byte{0xd3, 0xd1})
 	if err != nil {
 		return fmt.Errorf("error in accept sensor write: %v", err)
 	}
-	err = lcm.client.WriteCharacteristic(lcm.nrfRecvChar, []byte{0xd1, 0x05}, false)
+	err = lcm.writeRecv([]byte{0xd1, 0x05})
 	if err != nil {
 		return fmt.Errorf("error in tradition write: %v", err)
 	}
-	err = lcm.client.WriteCharacteristic(lcm.nrfRecvChar, []byte{0xf0}, false)
-	if err != nil {
+	return lcm.RequestReading()
+}
+
+// SetInterval asks the device to send a reading every so many minutes
+func (lcm *ConnectedMiao) SetInterval(minutes int) error {
+	if minutes < 1 || minutes > 255 {
+		return fmt.Errorf("interval must be 1 to 255 minutes, not %v", minutes)
+	}
+	if err := lcm.writeRecv([]byte{0xd1, byte(minutes)}); err != nil {
+		return fmt.Errorf("error in interval write: %v", err)
+	}
+	lcm.mu.Lock()
+	defer lcm.mu.Unlock()
+	lcm.emitInterval = time.Duration(minutes) * time.Minute
+	if !lcm.lastEmit.IsZero() {
+		lcm.nextEmit = lcm.lastEmit.Add(lcm.emitInterval)
+	}
+	return nil
+}
+
+// RequestReading asks the device for a reading now rather than when it's
+// next due
+func (lcm *ConnectedMiao) RequestReading() error {
+	if err := lcm.writeRecv([]byte{0xf0}); err != nil {
 		return fmt.Errorf("error in hollaback write: %v", err)
 	}
-	// eat two GATT responses
-	<-lcm.datachan
-	<-lcm.datachan
 	return nil
 }
 
 // PollResponse assures that a subscription is active and returns one reading
 func (lcm *ConnectedMiao) PollResponse() (*MiaoResponsePacket, error) {
-	if lcm.BtState != MSSubscribed {
+	if lcm.BtState() == MSConnected {
 		err := lcm.Subscribe()
 		if err != nil {
 			return nil, err
@@ -177,7 +325,7 @@ func (lcm *ConnectedMiao) MiaoLibreStatus() (MiaoDeviceState, error) {
 	if err != nil {
 		return MPDeclared, err
 	}
-	lcm.DevState = mp.Type
+	lcm.setDevState(mp.Type)
 	return mp.Type, err
 }
 
@@ -204,10 +352,27 @@ func CreateMiaoMiaoPacket(mmr *MiaoResponsePacket) MiaoMiaoPacket {
 	batteryPercentage = uint8(mmr.Data[13])
 	var lpData [344]byte
 	copy(lpData[:], mmr.Data[18:362])
-	lp := CreateLibrePacketNow(lpData, serialNumber)
+	captureTime := mmr.EndTime
+	if captureTime.IsZero() {
+		captureTime = time.Now()
+	}
+	lp := CreateLibrePacket(lpData, serialNumber, captureTime)
 
 	return MiaoMiaoPacket{
-		mmr.Data, pktLength, serialNumber, firmwareVersion, hardwareVersion, batteryPercentage, mmr.StartTime, mmr.EndTime, &lp}
+		mmr.Data, pktLength, serialNumber, firmwareVersion, hardwareVersion, batteryPercentage, mmr.StartTime, mmr.EndTime, &lp, "", nil, ""}
+}
+
+// createPacket deserializes a response from this miaomiao, stamping it with
+// the transmitter address and noting the sensor in the registry (if any)
+func (lcm *ConnectedMiao) createPacket(mmr *MiaoResponsePacket) MiaoMiaoPacket {
+	pkt := CreateMiaoMiaoPacket(mmr)
+	pkt.Transmitter = lcm.Address()
+	if lcm.registry != nil {
+		if err := lcm.registry.Observe(pkt.Transmitter, pkt.SerialNumber); err != nil {
+			log.Printf("couldn't update registry: %v", err)
+		}
+	}
+	return pkt
 }
 
 // Print just gives you the deets of a miaomiao packet reading
@@ -217,6 +382,7 @@ func (mmp MiaoMiaoPacket) Print() {
 	fmt.Printf("  EndTime: %v\n", mmp.EndTime)
 	fmt.Printf("  PktLength: %v\n", mmp.PktLength)
 	fmt.Printf("  SerialNumber: %v\n", mmp.SerialNumber)
+	fmt.Printf("  Transmitter: %v\n", mmp.Transmitter)
 	fmt.Printf("  FirmwareVersion: %v\n", mmp.FimrwareVersion)
 	fmt.Printf("  HardwareVersion: %v\n", mmp.HardwareVersion)
 	fmt.Printf("  BatteryPercentage: %v\n", mmp.BatteryPercentage)
@@ -229,7 +395,7 @@ func (lcm *ConnectedMiao) ReadSensor() (*MiaoMiaoPacket, error) {
 		return nil, err
 	}
 	if mp.Type == MPLibre {
-		reading := CreateMiaoMiaoPacket(mp)
+		reading := lcm.createPacket(mp)
 		return &reading, err
 	}
 	return nil, fmt.Errorf("did not recieve sensor response")
@@ -251,12 +417,15 @@ func (lcm *ConnectedMiao) ReadingEmitter(accept bool) chan MiaoMiaoPacket {
 			mr, err = lcm.PollResponse()
 			if err != nil {
 				close(emitter)
+				return
 			}
-			lcm.NextEmit = lcm.LastEmit.Add(lcm.emitInterval)
+			lcm.mu.Lock()
+			lcm.nextEmit = lcm.lastEmit.Add(lcm.emitInterval)
+			lcm.mu.Unlock()
 			// log.Printf("RE LastEmit: %v", lcm.LastEmit)
 			switch mr.Type {
 			case MPLibre:
-				emitter <- CreateMiaoMiaoPacket(mr)
+				emitter <- lcm.createPacket(mr)
 			case MPNewSensor:
 				log.Printf("MPNewSensor")
 				if accept {
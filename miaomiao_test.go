@@ -0,0 +1,64 @@
+package miao2go
+
+import (
+	"testing"
+	"time"
+)
+
+// chunked splits a frame into GATT sized chunks
+func chunked(frame []byte) [][]byte {
+	var chunks [][]byte
+	for len(frame) > 20 {
+		chunks = append(chunks, frame[:20])
+		frame = frame[20:]
+	}
+	return append(chunks, frame)
+}
+
+func TestMiaoResponseResyncsAfterOverflow(t *testing.T) {
+	lcm := newConnectedMiao(nil, nil, nil, nil, nil)
+	frame := make([]byte, 363)
+	frame[0] = byte(MPLibre)
+	for idx := 1; idx < len(frame); idx++ {
+		frame[idx] = byte(idx)
+	}
+	now := time.Now()
+	// the start of a packet, then the queue overflows
+	lcm.datachan <- gattResponsePacket{chunked(frame)[0], now}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		lcm.mu.Lock()
+		lcm.queueStats.Dropped += 3
+		lcm.mu.Unlock()
+		// the tail of the broken packet, then a whole one
+		lcm.datachan <- gattResponsePacket{chunked(frame)[5], now}
+		for _, chunk := range chunked(frame) {
+			lcm.datachan <- gattResponsePacket{chunk, now}
+		}
+	}()
+	response, err := lcm.MiaoResponse()
+	if err != nil {
+		t.Fatalf("got %v, want a resync", err)
+	}
+	if response.Type != MPLibre || string(response.Data[:]) != string(frame) {
+		t.Errorf("got a %v packet that isn't the frame sent", response.Type)
+	}
+}
+
+func TestAcceptNewSensorGivesUpOnHangup(t *testing.T) {
+	lcm := newConnectedMiao(&replayClient{}, nil, nil, nil, nil)
+	lcm.hangup = make(chan struct{})
+	close(lcm.hangup)
+	done := make(chan error)
+	go func() {
+		done <- lcm.AcceptNewSensor()
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("accepted with nobody there")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked after hangup")
+	}
+}
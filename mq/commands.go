@@ -0,0 +1,103 @@
+package mq
+
+// mq: commands for a running miaomiao
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eclipse/paho.mqtt.golang"
+	"log"
+	"time"
+)
+
+// Commands a running miaomiao can be sent
+const (
+	// CommandAccept starts reading a new sensor
+	CommandAccept = "accept"
+	// CommandSetInterval changes how often readings are sent
+	CommandSetInterval = "set-interval"
+	// CommandReadNow asks for a reading right away
+	CommandReadNow = "read-now"
+	// CommandReconnect drops the BLE connection and connects again
+	CommandReconnect = "reconnect"
+)
+
+// ErrNotOurs is what an Execute returns for a command meant for some other
+// transmitter; those get no result
+var ErrNotOurs = errors.New("command is for another transmitter")
+
+// Command is a request to do something to a miaomiao
+type Command struct {
+	ID          string `json:"id,omitempty"`
+	Command     string `json:"command"`
+	Transmitter string `json:"xmit,omitempty"`
+	Minutes     int    `json:"minutes,omitempty"`
+}
+
+// Validate checks a command is one we know, with what it needs
+func (cmd Command) Validate() error {
+	switch cmd.Command {
+	case CommandAccept, CommandReadNow, CommandReconnect:
+	case CommandSetInterval:
+		if cmd.Minutes < 1 || cmd.Minutes > 255 {
+			return fmt.Errorf("set-interval needs minutes, 1 to 255")
+		}
+	default:
+		return fmt.Errorf("unknown command %q", cmd.Command)
+	}
+	return nil
+}
+
+// CommandResult is how a command went
+type CommandResult struct {
+	ID          string    `json:"id,omitempty"`
+	Command     string    `json:"command"`
+	Transmitter string    `json:"xmit,omitempty"`
+	Time        time.Time `json:"time"`
+	OK          bool      `json:"ok"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Execute carries out a command, saying which transmitter it went to
+type Execute func(Command) (string, error)
+
+// CommandTopic is where commands are taken from
+func (config *Config) CommandTopic() string {
+	return config.FullTopic() + "/command"
+}
+
+// ResultTopic is where command results are published
+func (config *Config) ResultTopic() string {
+	return config.CommandTopic() + "/result"
+}
+
+// command handles one message from the command topic, one at a time as
+// paho calls handlers in order
+func (config *Config) command(client mqtt.Client, msg mqtt.Message, execute Execute) {
+	var cmd Command
+	result := CommandResult{Time: time.Now()}
+	err := json.Unmarshal(msg.Payload(), &cmd)
+	if err == nil {
+		result.ID, result.Command = cmd.ID, cmd.Command
+		err = cmd.Validate()
+	}
+	if err == nil {
+		log.Printf("command %v %v", cmd.Command, cmd.ID)
+		result.Transmitter, err = execute(cmd)
+	}
+	if err == ErrNotOurs {
+		return
+	}
+	result.OK = err == nil
+	if err != nil {
+		log.Printf("command %v %v failed: %v", cmd.Command, cmd.ID, err)
+		result.Error = err.Error()
+	}
+	payload, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("couldn't encode command result: %v", err)
+		return
+	}
+	client.Publish(config.ResultTopic(), byte(config.QoS), false, payload)
+}
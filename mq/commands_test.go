@@ -0,0 +1,102 @@
+package mq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eclipse/paho.mqtt.golang"
+	"testing"
+)
+
+// message is a message off the command topic
+type message struct {
+	mqtt.Message
+	payload []byte
+}
+
+func (msg message) Payload() []byte { return msg.payload }
+
+func TestCommand(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		payload  string
+		err      error
+		executed string
+		result   *CommandResult
+	}{
+		{
+			name:     "accept",
+			payload:  `{"id": "1", "command": "accept"}`,
+			executed: "accept",
+			result:   &CommandResult{ID: "1", Command: "accept", Transmitter: "aa:bb", OK: true},
+		},
+		{
+			name:     "set interval",
+			payload:  `{"command": "set-interval", "minutes": 3}`,
+			executed: "set-interval 3",
+			result:   &CommandResult{Command: "set-interval", Transmitter: "aa:bb", OK: true},
+		},
+		{
+			name:    "set interval without minutes",
+			payload: `{"id": "2", "command": "set-interval"}`,
+			result:  &CommandResult{ID: "2", Command: "set-interval", Error: "set-interval needs minutes, 1 to 255"},
+		},
+		{
+			name:    "unknown command",
+			payload: `{"id": "3", "command": "self-destruct"}`,
+			result:  &CommandResult{ID: "3", Command: "self-destruct", Error: `unknown command "self-destruct"`},
+		},
+		{
+			name:    "not json",
+			payload: `accept`,
+			result:  &CommandResult{Error: "invalid character 'a' looking for beginning of value"},
+		},
+		{
+			name:     "failed",
+			payload:  `{"id": "4", "command": "read-now"}`,
+			err:      errors.New("no miaomiao connected"),
+			executed: "read-now",
+			result:   &CommandResult{ID: "4", Command: "read-now", Transmitter: "aa:bb", Error: "no miaomiao connected"},
+		},
+		{
+			name:     "someone else's",
+			payload:  `{"command": "accept", "xmit": "cc:dd"}`,
+			err:      ErrNotOurs,
+			executed: "accept",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := &broker{}
+			config := &Config{Topic: "mm"}
+			executed := ""
+			config.command(b, message{payload: []byte(test.payload)}, func(cmd Command) (string, error) {
+				executed = cmd.Command
+				if cmd.Minutes > 0 {
+					executed = fmt.Sprintf("%v %v", cmd.Command, cmd.Minutes)
+				}
+				return "aa:bb", test.err
+			})
+			if executed != test.executed {
+				t.Errorf("executed %q, want %q", executed, test.executed)
+			}
+			if test.result == nil {
+				if len(b.published) != 0 {
+					t.Errorf("published %v, want nothing", b.published)
+				}
+				return
+			}
+			if len(b.published) != 1 || b.published[0] != "mm/command/result" {
+				t.Fatalf("published to %v, want the result topic", b.published)
+			}
+			var result CommandResult
+			if err := json.Unmarshal(b.payloads[0].([]byte), &result); err != nil {
+				t.Fatal(err)
+			}
+			want := *test.result
+			want.Time = result.Time
+			if result != want || result.Time.IsZero() {
+				t.Errorf("got result %+v, want %+v", result, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package mq
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/thecubic/miao2go"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Availability payloads
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// Config is everything needed to talk to a broker
+type Config struct {
+	Broker        string `yaml:"broker"`
+	Prefix        string `yaml:"prefix"`
+	Topic         string `yaml:"topic"`
+	ClientID      string `yaml:"clientid"`
+	QoS           int    `yaml:"qos"`
+	Retain        bool   `yaml:"retain"`
+	Debug         bool   `yaml:"mqdebug"`
+	Username      string `yaml:"user"`
+	Password      string `yaml:"pass"`
+	CAFile        string `yaml:"ca"`
+	CertFile      string `yaml:"cert"`
+	KeyFile       string `yaml:"key"`
+	NoVerifySSL   bool   `yaml:"noverifyssl"`
+	Status        string `yaml:"status"`
+	Latest        string `yaml:"latest"`
+	Template      string `yaml:"template"`
+	Schema        string `yaml:"schema"`
+	HomeAssistant bool   `yaml:"homeassistant"`
+	Discovery     string `yaml:"discovery"`
+	Commands      bool   `yaml:"commands"`
+}
+
+// Flags registers the broker flags on a flag set
+func Flags(fs *flag.FlagSet, clientID string, qos int) *Config {
+	config := &Config{}
+	fs.StringVar(&config.Broker, "broker", "tcp://localhost:1883", "MQTT broker address (ssl:// for TLS)")
+	fs.StringVar(&config.Prefix, "prefix", "", "topic prefix")
+	fs.StringVar(&config.Topic, "topic", "mmpackets", "packet topic")
+	fs.StringVar(&config.ClientID, "clientid", clientID, "MQTT Client ID")
+	fs.IntVar(&config.QoS, "qos", qos, "MQTT QoS; 1 or 2 gets redelivery")
+	fs.BoolVar(&config.Retain, "retain", false, "have the broker retain published packets")
+	fs.BoolVar(&config.Debug, "mqdebug", false, "MQ debugging output")
+	fs.StringVar(&config.Username, "mq.user", "", "MQTT username")
+	fs.StringVar(&config.Password, "mq.pass", "", "MQTT password")
+	fs.StringVar(&config.CAFile, "mq.ca", "", "CA certificate(s) to verify the broker with")
+	fs.StringVar(&config.CertFile, "mq.cert", "", "client certificate, for brokers that want one")
+	fs.StringVar(&config.KeyFile, "mq.key", "", "client certificate key")
+	fs.BoolVar(&config.NoVerifySSL, "mq.noverifyssl", false, "don't verify the broker's certificate / hostname")
+	fs.StringVar(&config.Status, "mq.status", "", "availability topic, online or offline, retained (default: <topic>/status)")
+	fs.StringVar(&config.Latest, "mq.latest", "", "latest reading topic, retained; takes placeholders like mq.template (default: <topic>/latest)")
+	fs.StringVar(&config.Template, "mq.template", "", "packet topic template using {prefix}, {topic}, {transmitter} and {serial} (default: <prefix><topic>)")
+	fs.StringVar(&config.Schema, "mq.schema", SchemaFull, "payload schema: full, compact, readings or binary")
+	fs.BoolVar(&config.HomeAssistant, "mq.hass", false, "publish Home Assistant discovery and entity state")
+	fs.StringVar(&config.Discovery, "mq.hass.discovery", "homeassistant", "Home Assistant discovery prefix")
+	fs.BoolVar(&config.Commands, "mq.commands", false, "take miaomiao commands from <topic>/command")
+	return config
+}
+
+// FullTopic is the packet topic under the prefix
+func (config *Config) FullTopic() string {
+	return config.Prefix + config.Topic
+}
+
+// StatusTopic is where availability is published
+func (config *Config) StatusTopic() string {
+	if len(config.Status) > 0 {
+		return config.Status
+	}
+	return config.FullTopic() + "/status"
+}
+
+// LatestTopic is where a packet's latest reading is published
+func (config *Config) LatestTopic(pkt miao2go.MiaoMiaoPacket) string {
+	if len(config.Latest) > 0 {
+		return config.render(config.Latest, pkt)
+	}
+	return config.FullTopic() + "/latest"
+}
+
+// usesTLS is whether the broker should be spoken to over TLS
+func (config *Config) usesTLS() bool {
+	for _, scheme := range []string{"ssl://", "tls://", "mqtts://", "tcps://", "wss://"} {
+		if strings.HasPrefix(config.Broker, scheme) {
+			return true
+		}
+	}
+	return len(config.CAFile) > 0 || len(config.CertFile) > 0
+}
+
+// TLSConfig is the TLS setup the config asks for
+func (config *Config) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.NoVerifySSL}
+	if len(config.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %v", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(config.CertFile) > 0 || len(config.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Options are the client options for the broker; connections are retried
+// in the background, initially and after being lost
+func (config *Config) Options() (*mqtt.ClientOptions, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.Debug {
+		mqtt.DEBUG = log.New(os.Stderr, "", 0)
+	}
+	mqtt.ERROR = log.New(os.Stderr, "", 0)
+	opts := mqtt.NewClientOptions().AddBroker(config.Broker).SetClientID(config.ClientID)
+	opts.SetKeepAlive(2 * time.Second)
+	opts.SetPingTimeout(1 * time.Second)
+	opts.SetConnectRetry(true)
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(time.Minute)
+	if len(config.Username) > 0 {
+		opts.SetUsername(config.Username)
+		opts.SetPassword(config.Password)
+	}
+	if config.usesTLS() {
+		tlsConfig, err := config.TLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		log.Printf("lost connection to %v, reconnecting: %v", config.Broker, err)
+	})
+	return opts, nil
+}
+
+// Connect starts connecting to the broker as a publisher, returning
+// straight away.  The broker is told to mark us offline if we vanish, and
+// we mark ourselves online each time we (re)connect
+func (config *Config) Connect() (mqtt.Client, error) {
+	return config.ConnectCommands(nil)
+}
+
+// ConnectCommands is Connect, also (re)subscribing to CommandTopic on every
+// connect and handing each command to execute, if it's not nil
+func (config *Config) ConnectCommands(execute Execute) (mqtt.Client, error) {
+	opts, err := config.Options()
+	if err != nil {
+		return nil, err
+	}
+	status := config.StatusTopic()
+	opts.SetWill(status, Offline, byte(config.QoS), true)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		log.Printf("connected to %v", config.Broker)
+		client.Publish(status, byte(config.QoS), true, Online)
+		if execute == nil {
+			return
+		}
+		topic := config.CommandTopic()
+		token := client.Subscribe(topic, byte(config.QoS), func(client mqtt.Client, msg mqtt.Message) {
+			config.command(client, msg, execute)
+		})
+		if token.Wait() && token.Error() != nil {
+			log.Printf("couldn't subscribe to %v: %v", topic, token.Error())
+		}
+	})
+	client := mqtt.NewClient(opts)
+	client.Connect()
+	return client, nil
+}
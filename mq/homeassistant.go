@@ -0,0 +1,208 @@
+package mq
+
+// mq: Home Assistant MQTT discovery
+
+import (
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"strings"
+	"time"
+)
+
+// haEntity is one Home Assistant sensor a miaomiao shows up as
+type haEntity struct {
+	key         string
+	name        string
+	unit        string
+	deviceClass string
+	stateClass  string
+	icon        string
+}
+
+// haEntities are the sensors every miaomiao gets; sensor serial and
+// transmitter ride along as attributes, so a new sensor doesn't leave a
+// trail of dead entities behind
+var haEntities = []haEntity{
+	{"glucose", "Glucose", miao2go.MgDL, "", "measurement", "mdi:diabetes"},
+	{"trend", "Trend", "", "", "", "mdi:trending-up"},
+	{"sensor_age", "Sensor age", "d", "duration", "measurement", "mdi:timer-sand"},
+	{"sensor_status", "Sensor status", "", "", "", "mdi:list-status"},
+	{"battery", "Battery", "%", "battery", "measurement", ""},
+}
+
+// haState is the one retained state message every entity reads from
+type haState struct {
+	Glucose      *float64 `json:"glucose"`
+	Units        string   `json:"units"`
+	Trend        string   `json:"trend"`
+	SensorAge    *float64 `json:"sensor_age"`
+	SensorStatus string   `json:"sensor_status"`
+	Battery      uint8    `json:"battery"`
+	Serial       string   `json:"serial"`
+	Transmitter  string   `json:"transmitter"`
+	Time         string   `json:"time,omitempty"`
+}
+
+// haDevice is how Home Assistant groups a miaomiao's entities
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+	HWVersion    string   `json:"hw_version,omitempty"`
+}
+
+type haAvailability struct {
+	Topic string `json:"topic"`
+}
+
+// haConfig is a discovery config message
+type haConfig struct {
+	Name                string           `json:"name"`
+	UniqueID            string           `json:"unique_id"`
+	ObjectID            string           `json:"object_id"`
+	StateTopic          string           `json:"state_topic"`
+	ValueTemplate       string           `json:"value_template"`
+	AttributesTopic     string           `json:"json_attributes_topic"`
+	AttributesTemplate  string           `json:"json_attributes_template"`
+	Unit                string           `json:"unit_of_measurement,omitempty"`
+	DeviceClass         string           `json:"device_class,omitempty"`
+	StateClass          string           `json:"state_class,omitempty"`
+	Icon                string           `json:"icon,omitempty"`
+	Availability        []haAvailability `json:"availability"`
+	AvailabilityMode    string           `json:"availability_mode"`
+	PayloadAvailable    string           `json:"payload_available"`
+	PayloadNotAvailable string           `json:"payload_not_available"`
+	Device              haDevice         `json:"device"`
+}
+
+// haID is a transmitter (or failing that, serial) as an MQTT topic level
+// and Home Assistant object id
+func haID(transmitter, serial string) string {
+	id := transmitter
+	if len(id) == 0 {
+		id = serial
+	}
+	return strings.ToLower(strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, id))
+}
+
+// haTopic is under where a miaomiao's Home Assistant state lives
+func (config *Config) haTopic(id string) string {
+	return config.FullTopic() + "/homeassistant/" + id
+}
+
+// haStateOf is the entity state of a packet
+func haStateOf(pkt miao2go.MiaoMiaoPacket) haState {
+	state := haState{
+		Trend:       miao2go.NoDirection,
+		Battery:     pkt.BatteryPercentage,
+		Serial:      pkt.SerialNumber,
+		Transmitter: pkt.Transmitter,
+		Units:       pkt.Units,
+	}
+	if len(state.Units) == 0 {
+		state.Units = miao2go.MgDL
+	}
+	if reading, ok := pkt.Latest(); ok {
+		glucose := reading.InUnits(state.Units)
+		state.Glucose = &glucose
+		state.Time = reading.Time.Format(time.RFC3339)
+	}
+	if rate, ok := pkt.TrendRate(); ok {
+		state.Trend = miao2go.Direction(rate)
+	}
+	if pkt.LibrePacket != nil {
+		days := float64(pkt.LibrePacket.SensorMinutes()) / (24 * 60)
+		state.SensorAge = &days
+		state.SensorStatus = pkt.LibrePacket.Status().String()
+	}
+	return state
+}
+
+// haConfigs are the discovery configs for a miaomiao, keyed by topic
+func (config *Config) haConfigs(id string, pkt miao2go.MiaoMiaoPacket, units string) map[string]haConfig {
+	device := haDevice{
+		Identifiers:  []string{"miao2go_" + id},
+		Name:         "miaomiao " + id,
+		Manufacturer: "Tomato",
+		Model:        "miaomiao",
+	}
+	if pkt.FimrwareVersion > 0 {
+		device.SWVersion = fmt.Sprintf("%04x", pkt.FimrwareVersion)
+	}
+	if pkt.HardwareVersion > 0 {
+		device.HWVersion = fmt.Sprintf("%04x", pkt.HardwareVersion)
+	}
+	base := config.haTopic(id)
+	configs := make(map[string]haConfig)
+	for _, entity := range haEntities {
+		unit := entity.unit
+		if entity.key == "glucose" {
+			unit = units
+		}
+		objectID := "miao2go_" + id + "_" + entity.key
+		configs[fmt.Sprintf("%v/sensor/%v/config", config.Discovery, objectID)] = haConfig{
+			Name:               entity.name,
+			UniqueID:           objectID,
+			ObjectID:           objectID,
+			StateTopic:         base + "/state",
+			ValueTemplate:      fmt.Sprintf("{{ value_json.%v }}", entity.key),
+			AttributesTopic:    base + "/state",
+			AttributesTemplate: `{{ {"serial": value_json.serial, "transmitter": value_json.transmitter, "time": value_json.time} | tojson }}`,
+			Unit:               unit,
+			DeviceClass:        entity.deviceClass,
+			StateClass:         entity.stateClass,
+			Icon:               entity.icon,
+			// the broker has to know we're around, and we have to be
+			// talking to the miaomiao
+			Availability:        []haAvailability{{config.StatusTopic()}, {base + "/availability"}},
+			AvailabilityMode:    "all",
+			PayloadAvailable:    Online,
+			PayloadNotAvailable: Offline,
+			Device:              device,
+		}
+	}
+	return configs
+}
+
+// homeAssistant announces a miaomiao's entities the first time it (or its
+// units) are seen, then publishes their state
+func (sink *Sink) homeAssistant(pkt miao2go.MiaoMiaoPacket) error {
+	id := haID(pkt.Transmitter, pkt.SerialNumber)
+	if len(id) == 0 {
+		return nil
+	}
+	state := haStateOf(pkt)
+	if sink.announced[id] != state.Units {
+		for topic, config := range sink.config.haConfigs(id, pkt, state.Units) {
+			if err := sink.publish(topic, true, config); err != nil {
+				return err
+			}
+		}
+		sink.announced[id] = state.Units
+	}
+	// a packet means we're talking to it, whatever we last heard
+	if err := sink.send(sink.config.haTopic(id)+"/availability", true, []byte(Online)); err != nil {
+		return err
+	}
+	return sink.publish(sink.config.haTopic(id)+"/state", true, state)
+}
+
+// haAvailable follows a miaomiao's BLE state into its entities' availability
+func (sink *Sink) haAvailable(event miao2go.Event) error {
+	id := haID(event.Transmitter, "")
+	if len(id) == 0 {
+		return nil
+	}
+	availability := Online
+	if event.State == miao2go.MSDisconnected.String() {
+		availability = Offline
+	}
+	return sink.send(sink.config.haTopic(id)+"/availability", true, []byte(availability))
+}
@@ -0,0 +1,156 @@
+package mq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Payload schemas
+const (
+	// SchemaFull is the whole MiaoMiaoPacket as JSON, raw frame and all
+	SchemaFull = "full"
+	// SchemaCompact is just the Latest summary as JSON
+	SchemaCompact = "compact"
+	// SchemaReadings is one JSON GlucoseReading per message, new ones only
+	SchemaReadings = "readings"
+	// SchemaBinary is the capture time and raw frame; see EncodeBinary
+	SchemaBinary = "binary"
+)
+
+// placeholders are what topic templates can refer to
+var placeholders = regexp.MustCompile(`\{[a-z]*\}`)
+
+// Validate checks the schema and topic templates make sense
+func (config *Config) Validate() error {
+	switch config.Schema {
+	case "", SchemaFull, SchemaCompact, SchemaReadings, SchemaBinary:
+	default:
+		return fmt.Errorf("unknown payload schema %q", config.Schema)
+	}
+	for _, template := range []string{config.Template, config.Latest} {
+		for _, placeholder := range placeholders.FindAllString(template, -1) {
+			switch placeholder {
+			case "{prefix}", "{topic}", "{transmitter}", "{serial}":
+			default:
+				return fmt.Errorf("unknown placeholder %v in %q", placeholder, template)
+			}
+		}
+	}
+	return nil
+}
+
+// render fills in a topic template; fields a packet doesn't have become
+// "unknown", since an empty topic level would be ambiguous
+func (config *Config) render(template string, pkt miao2go.MiaoMiaoPacket) string {
+	transmitter, serial := pkt.Transmitter, pkt.SerialNumber
+	if pkt.LibrePacket != nil && len(pkt.LibrePacket.SerialNumber) > 0 {
+		serial = pkt.LibrePacket.SerialNumber
+	}
+	if len(transmitter) == 0 {
+		transmitter = "unknown"
+	}
+	if len(serial) == 0 {
+		serial = "unknown"
+	}
+	return strings.NewReplacer(
+		"{prefix}", config.Prefix,
+		"{topic}", config.Topic,
+		"{transmitter}", transmitter,
+		"{serial}", serial,
+	).Replace(template)
+}
+
+// PacketTopic is where a packet is published
+func (config *Config) PacketTopic(pkt miao2go.MiaoMiaoPacket) string {
+	if len(config.Template) == 0 {
+		return config.FullTopic()
+	}
+	return config.render(config.Template, pkt)
+}
+
+// SubscribeTopic is the filter that matches every PacketTopic
+func (config *Config) SubscribeTopic() string {
+	if len(config.Template) == 0 {
+		return config.FullTopic()
+	}
+	return strings.NewReplacer(
+		"{prefix}", config.Prefix,
+		"{topic}", config.Topic,
+		"{transmitter}", "+",
+		"{serial}", "+",
+	).Replace(config.Template)
+}
+
+// EncodeBinary packs a packet as the big-endian unix millisecond time it
+// was captured, the raw 363 byte frame, then the transmitter address
+func EncodeBinary(pkt miao2go.MiaoMiaoPacket) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint64(pkt.EndTime.UnixNano()/int64(time.Millisecond)))
+	buf.Write(pkt.Data[:])
+	buf.WriteString(pkt.Transmitter)
+	return buf.Bytes()
+}
+
+// DecodeBinary unpacks and decodes an EncodeBinary packet
+func DecodeBinary(payload []byte) (miao2go.MiaoMiaoPacket, error) {
+	var mmp miao2go.MiaoMiaoPacket
+	if len(payload) < 8+miao2go.MiaoFrameLength {
+		return mmp, fmt.Errorf("%v bytes is too short for a binary packet", len(payload))
+	}
+	ms := binary.BigEndian.Uint64(payload[:8])
+	mmp.EndTime = time.Unix(0, int64(ms)*int64(time.Millisecond))
+	mmp.StartTime = mmp.EndTime
+	copy(mmp.Data[:], payload[8:8+miao2go.MiaoFrameLength])
+	mmp.Transmitter = string(payload[8+miao2go.MiaoFrameLength:])
+	return mmp.Redecode(), nil
+}
+
+// Message is a decoded payload of any schema; exactly one field is set
+type Message struct {
+	Packet  *miao2go.MiaoMiaoPacket
+	Latest  *Latest
+	Reading *miao2go.GlucoseReading
+}
+
+// Decode works out which schema a payload is in and decodes it
+func Decode(payload []byte) (Message, error) {
+	if len(payload) == 0 || payload[0] != '{' {
+		pkt, err := DecodeBinary(payload)
+		if err != nil {
+			return Message{}, err
+		}
+		return Message{Packet: &pkt}, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return Message{}, err
+	}
+	if _, ok := fields["raw_data"]; ok {
+		var pkt miao2go.MiaoMiaoPacket
+		if err := json.Unmarshal(payload, &pkt); err != nil {
+			return Message{}, err
+		}
+		return Message{Packet: &pkt}, nil
+	}
+	if _, ok := fields["kind"]; ok {
+		var reading miao2go.GlucoseReading
+		if err := json.Unmarshal(payload, &reading); err != nil {
+			return Message{}, err
+		}
+		return Message{Reading: &reading}, nil
+	}
+	if _, ok := fields["glucose"]; ok {
+		var latest Latest
+		if err := json.Unmarshal(payload, &latest); err != nil {
+			return Message{}, err
+		}
+		return Message{Latest: &latest}, nil
+	}
+	return Message{}, fmt.Errorf("payload isn't any schema we know")
+}
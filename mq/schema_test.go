@@ -0,0 +1,86 @@
+package mq
+
+import (
+	"encoding/json"
+	"github.com/thecubic/miao2go"
+	"testing"
+	"time"
+)
+
+// frame is a packet with just enough of a frame to decode
+func frame() miao2go.MiaoMiaoPacket {
+	var pkt miao2go.MiaoMiaoPacket
+	pkt.Data[0], pkt.Data[miao2go.MiaoFrameLength-1] = byte(miao2go.MPLibre), 0x29
+	pkt.Data[13] = 80
+	pkt.EndTime = time.Date(2018, 9, 25, 10, 0, 0, 123000000, time.UTC)
+	pkt.Transmitter = "aa:bb:cc:dd:ee:ff"
+	return pkt
+}
+
+func TestDecode(t *testing.T) {
+	marshal := func(value interface{}) []byte {
+		payload, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return payload
+	}
+	for _, tc := range []struct {
+		name    string
+		payload []byte
+		check   func(Message) bool
+	}{
+		{"full", marshal(frame()), func(msg Message) bool {
+			return msg.Packet != nil && msg.Packet.Transmitter == "aa:bb:cc:dd:ee:ff"
+		}},
+		{"binary", EncodeBinary(frame()), func(msg Message) bool {
+			return msg.Packet != nil && msg.Packet.EndTime.Equal(frame().EndTime) &&
+				msg.Packet.Transmitter == "aa:bb:cc:dd:ee:ff" && msg.Packet.BatteryPercentage == 80
+		}},
+		{"compact", marshal(Latest{Serial: "0M0001", Glucose: 100, Raw: 850}), func(msg Message) bool {
+			return msg.Latest != nil && msg.Latest.Glucose == 100
+		}},
+		{"reading", marshal(miao2go.GlucoseReading{Serial: "0M0001", Kind: miao2go.HistoryReading, Glucose: 90}), func(msg Message) bool {
+			return msg.Reading != nil && msg.Reading.Kind == miao2go.HistoryReading
+		}},
+		{"short binary", []byte{0, 1, 2}, nil},
+		{"empty", nil, nil},
+		{"unknown json", []byte(`{"hello": "world"}`), nil},
+		{"broken json", []byte(`{"raw_data": `), nil},
+	} {
+		msg, err := Decode(tc.payload)
+		if tc.check == nil {
+			if err == nil {
+				t.Errorf("%v: decoded %+v, want an error", tc.name, msg)
+			}
+			continue
+		}
+		if err != nil || !tc.check(msg) {
+			t.Errorf("%v: got %+v, %v", tc.name, msg, err)
+		}
+	}
+}
+
+func TestTopics(t *testing.T) {
+	pkt := frame()
+	pkt.SerialNumber = "0M0001"
+	for _, tc := range []struct {
+		config            Config
+		packet, subscribe string
+	}{
+		{Config{Prefix: "home/", Topic: "mm"}, "home/mm", "home/mm"},
+		{Config{Prefix: "home/", Topic: "mm", Template: "{prefix}{topic}/{transmitter}/{serial}"},
+			"home/mm/aa:bb:cc:dd:ee:ff/0M0001", "home/mm/+/+"},
+		{Config{Topic: "mm", Template: "{topic}/{serial}"}, "mm/0M0001", "mm/+"},
+	} {
+		if got := tc.config.PacketTopic(pkt); got != tc.packet {
+			t.Errorf("%q: published to %v, want %v", tc.config.Template, got, tc.packet)
+		}
+		if got := tc.config.SubscribeTopic(); got != tc.subscribe {
+			t.Errorf("%q: subscribed to %v, want %v", tc.config.Template, got, tc.subscribe)
+		}
+	}
+	if err := (&Config{Template: "{topic}/{nope}"}).Validate(); err == nil {
+		t.Error("unknown placeholder validated")
+	}
+}
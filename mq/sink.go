@@ -0,0 +1,166 @@
+package mq
+
+// mq: publishing packets to an MQTT broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/thecubic/miao2go"
+	"sort"
+	"time"
+)
+
+// Latest is the compact, retained summary of the most recent reading, for
+// anything that only wants the current number
+type Latest struct {
+	Serial      string    `json:"serial"`
+	Transmitter string    `json:"xmit,omitempty"`
+	Time        time.Time `json:"time"`
+	Glucose     float64   `json:"glucose"`
+	Raw         int       `json:"raw"`
+	Rate        *float64  `json:"rate,omitempty"`
+	Battery     uint8     `json:"battery"`
+}
+
+// LatestOf summarises a packet's latest reading, if it has one
+func LatestOf(pkt miao2go.MiaoMiaoPacket) (Latest, bool) {
+	reading, ok := pkt.Latest()
+	if !ok {
+		return Latest{}, false
+	}
+	latest := Latest{reading.Serial, pkt.Transmitter, reading.Time, reading.Glucose, reading.Raw, nil, pkt.BatteryPercentage}
+	if rate, ok := pkt.TrendRate(); ok {
+		latest.Rate = &rate
+	}
+	return latest, true
+}
+
+// Sink publishes packets in the configured schema to a topic, their latest
+// readings to a retained topic, and events to a subtopic, as a miao2go.Sink.
+// With HomeAssistant set it also keeps Home Assistant entities up to date
+type Sink struct {
+	client    mqtt.Client
+	config    *Config
+	timeout   time.Duration
+	seen      *miao2go.Deduper
+	announced map[string]string
+	// published is the last packet to make it to its own topic, so that
+	// when a later topic fails and the packet comes round again it isn't
+	// sent twice.  Everything after it is retained, and safe to resend
+	published string
+}
+
+// NewSink publishes as config says through an already-connecting client
+func NewSink(client mqtt.Client, config *Config, timeout time.Duration) *Sink {
+	return &Sink{client, config, timeout, miao2go.NewDeduper(12 * time.Hour), make(map[string]string), ""}
+}
+
+// publish sends a value as JSON, waiting up to the timeout for the broker
+func (sink *Sink) publish(topic string, retain bool, value interface{}) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return sink.send(topic, retain, payload)
+}
+
+// send sends a payload as it is, waiting up to the timeout for the broker
+func (sink *Sink) send(topic string, retain bool, payload []byte) error {
+	if !sink.client.IsConnectionOpen() {
+		return fmt.Errorf("not connected to broker")
+	}
+	token := sink.client.Publish(topic, byte(sink.config.QoS), retain, payload)
+	if !token.WaitTimeout(sink.timeout) {
+		return fmt.Errorf("timed out publishing to %v", topic)
+	}
+	return token.Error()
+}
+
+// packetID tells packets apart, across trips through a queue
+func packetID(pkt miao2go.MiaoMiaoPacket) string {
+	return fmt.Sprintf("%v/%v/%v", pkt.Transmitter, pkt.SerialNumber, pkt.EndTime.UnixNano())
+}
+
+// WriteReading publishes a packet, and its latest reading.  A packet that
+// is already out only has its retained topics published again
+func (sink *Sink) WriteReading(pkt miao2go.MiaoMiaoPacket) error {
+	latest, hasLatest := LatestOf(pkt)
+	if id := packetID(pkt); sink.published != id {
+		if err := sink.publishPacket(pkt, latest, hasLatest); err != nil {
+			return err
+		}
+		sink.published = id
+	}
+	if hasLatest {
+		if err := sink.publish(sink.config.LatestTopic(pkt), true, latest); err != nil {
+			return err
+		}
+	}
+	if sink.config.HomeAssistant {
+		return sink.homeAssistant(pkt)
+	}
+	return nil
+}
+
+// publishPacket publishes a packet to its own topic in the configured schema
+func (sink *Sink) publishPacket(pkt miao2go.MiaoMiaoPacket, latest Latest, hasLatest bool) error {
+	topic := sink.config.PacketTopic(pkt)
+	switch sink.config.Schema {
+	case SchemaCompact:
+		if hasLatest {
+			if err := sink.publish(topic, sink.config.Retain, latest); err != nil {
+				return err
+			}
+		}
+	case SchemaReadings:
+		// oldest first, so subscribers see them in order
+		fresh := sink.seen.Fresh(pkt.Readings())
+		sort.Slice(fresh, func(i, j int) bool { return fresh[i].Time.Before(fresh[j].Time) })
+		for i, reading := range fresh {
+			if reading.Raw == 0 {
+				continue
+			}
+			if err := sink.publish(topic, sink.config.Retain, reading); err != nil {
+				// the rest go again next time
+				sink.seen.Mark(fresh[:i])
+				return err
+			}
+		}
+		sink.seen.Mark(fresh)
+	case SchemaBinary:
+		if err := sink.send(topic, sink.config.Retain, EncodeBinary(pkt)); err != nil {
+			return err
+		}
+	default:
+		if err := sink.publish(topic, sink.config.Retain, pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteEvent publishes an event to the events subtopic
+func (sink *Sink) WriteEvent(event miao2go.Event) error {
+	if sink.config.HomeAssistant && event.Kind == miao2go.EventState {
+		if err := sink.haAvailable(event); err != nil {
+			return err
+		}
+	}
+	return sink.publish(sink.config.FullTopic()+"/events", false, event)
+}
+
+// Flush does nothing; every packet is published as it comes
+func (sink *Sink) Flush() error {
+	return nil
+}
+
+// Close says we're going offline and disconnects from the broker
+func (sink *Sink) Close() error {
+	if sink.client.IsConnectionOpen() {
+		token := sink.client.Publish(sink.config.StatusTopic(), byte(sink.config.QoS), true, Offline)
+		token.WaitTimeout(sink.timeout)
+	}
+	sink.client.Disconnect(250)
+	return nil
+}
@@ -0,0 +1,57 @@
+package mq
+
+import (
+	"fmt"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/thecubic/miao2go"
+	"testing"
+	"time"
+)
+
+// token is an already finished publish
+type token struct{ err error }
+
+func (tok token) Wait() bool                     { return true }
+func (tok token) WaitTimeout(time.Duration) bool { return true }
+func (tok token) Done() <-chan struct{}          { done := make(chan struct{}); close(done); return done }
+func (tok token) Error() error                   { return tok.err }
+
+// broker is a client that keeps what's published, failing the topics in
+// fail once each
+type broker struct {
+	mqtt.Client
+	fail      map[string]bool
+	published []string
+	payloads  []interface{}
+}
+
+func (b *broker) IsConnectionOpen() bool { return true }
+
+func (b *broker) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	if b.fail[topic] {
+		delete(b.fail, topic)
+		return token{fmt.Errorf("refused %v", topic)}
+	}
+	b.published = append(b.published, topic)
+	b.payloads = append(b.payloads, payload)
+	return token{}
+}
+
+func TestWriteReadingRetryDoesntRepublish(t *testing.T) {
+	b := &broker{fail: map[string]bool{"mm/latest": true}}
+	sink := NewSink(b, &Config{Topic: "mm", Schema: SchemaFull}, time.Second)
+	pkt := miao2go.MiaoMiaoPacket{
+		SerialNumber: "0M0001",
+		EndTime:      time.Now(),
+		Processed:    []miao2go.GlucoseReading{{Serial: "0M0001", Kind: miao2go.TrendReading, Time: time.Now(), Glucose: 100, Raw: 850}},
+	}
+	if err := sink.WriteReading(pkt); err == nil {
+		t.Fatal("latest was refused, but no error")
+	}
+	if err := sink.WriteReading(pkt); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(b.published) != "[mm mm/latest]" {
+		t.Errorf("published %v, want the packet once then latest", b.published)
+	}
+}
@@ -0,0 +1,213 @@
+package nightscout
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// postedWindow is how long we remember what's been uploaded
+const postedWindow = 24 * time.Hour
+
+// HashSecret hashes an API secret the way Nightscout expects to see it.
+// Secrets that already look like a SHA1 hash are passed through
+func HashSecret(secret string) string {
+	if _, err := hex.DecodeString(secret); err == nil && len(secret) == 2*sha1.Size {
+		return strings.ToLower(secret)
+	}
+	sum := sha1.Sum([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Client uploads to a Nightscout site, never sending the same entry twice
+type Client struct {
+	base    string
+	secret  string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+
+	mu     sync.Mutex
+	primed bool
+	posted []Entry
+	onSite []int64
+}
+
+// NewClient talks to the Nightscout site at base
+func NewClient(base, secret string, client *http.Client, retries int, backoff time.Duration) *Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hashed := ""
+	if len(secret) > 0 {
+		hashed = HashSecret(secret)
+	}
+	return &Client{
+		base:    strings.TrimRight(base, "/"),
+		secret:  hashed,
+		client:  client,
+		retries: retries,
+		backoff: backoff,
+	}
+}
+
+// do makes an API request, retrying failures with backoff
+func (nsc *Client) do(method, path string, body interface{}, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	return miao2go.Retry(nsc.retries, nsc.backoff, func() error {
+		req, err := http.NewRequest(method, nsc.base+path, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if len(nsc.secret) > 0 {
+			req.Header.Set("api-secret", nsc.secret)
+		}
+		resp, err := nsc.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			text, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+			return fmt.Errorf("nightscout said %v: %s", resp.Status, bytes.TrimSpace(text))
+		}
+		if result != nil {
+			return json.NewDecoder(resp.Body).Decode(result)
+		}
+		return nil
+	})
+}
+
+// prime learns what the site already has, so a restart doesn't re-upload
+func (nsc *Client) prime() error {
+	since := time.Now().Add(-postedWindow).UnixNano() / int64(time.Millisecond)
+	query := url.Values{}
+	query.Set("count", "1000")
+	query.Set("find[date][$gte]", fmt.Sprint(since))
+	var existing []Entry
+	if err := nsc.do("GET", "/api/v1/entries/sgv.json?"+query.Encode(), nil, &existing); err != nil {
+		return err
+	}
+	nsc.mu.Lock()
+	defer nsc.mu.Unlock()
+	for _, entry := range existing {
+		nsc.onSite = append(nsc.onSite, entry.Date)
+	}
+	nsc.primed = true
+	return nil
+}
+
+// uploaded is whether an entry was posted by us, or was already on the
+// site when we started.  Entries the site had are only known by date
+func (nsc *Client) uploaded(entry Entry) bool {
+	for _, posted := range nsc.posted {
+		if entry.same(posted) {
+			return true
+		}
+	}
+	for _, date := range nsc.onSite {
+		if near(entry.Date, date) {
+			return true
+		}
+	}
+	return false
+}
+
+// including is whether entries has the same reading as entry
+func including(entries []Entry, entry Entry) bool {
+	for _, other := range entries {
+		if entry.same(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// fresh filters out entries already on the site, and forgets old ones
+func (nsc *Client) fresh(entries []Entry) []Entry {
+	nsc.mu.Lock()
+	defer nsc.mu.Unlock()
+	horizon := time.Now().Add(-postedWindow).UnixNano() / int64(time.Millisecond)
+	var posted []Entry
+	for _, entry := range nsc.posted {
+		if entry.Date >= horizon {
+			posted = append(posted, entry)
+		}
+	}
+	nsc.posted = posted
+	var onSite []int64
+	for _, date := range nsc.onSite {
+		if date >= horizon {
+			onSite = append(onSite, date)
+		}
+	}
+	nsc.onSite = onSite
+	var fresh []Entry
+	for _, entry := range entries {
+		if !nsc.uploaded(entry) && !including(fresh, entry) {
+			fresh = append(fresh, entry)
+		}
+	}
+	return fresh
+}
+
+// PostEntries uploads whichever entries the site doesn't have yet
+func (nsc *Client) PostEntries(entries []Entry) (int, error) {
+	nsc.mu.Lock()
+	primed := nsc.primed
+	nsc.mu.Unlock()
+	if !primed {
+		if err := nsc.prime(); err != nil {
+			return 0, fmt.Errorf("couldn't fetch existing entries: %v", err)
+		}
+	}
+	fresh := nsc.fresh(entries)
+	if len(fresh) == 0 {
+		return 0, nil
+	}
+	if err := nsc.do("POST", "/api/v1/entries", fresh, nil); err != nil {
+		return 0, err
+	}
+	nsc.mu.Lock()
+	defer nsc.mu.Unlock()
+	nsc.posted = append(nsc.posted, fresh...)
+	return len(fresh), nil
+}
+
+// PostDeviceStatus uploads a device status
+func (nsc *Client) PostDeviceStatus(status DeviceStatus) error {
+	return nsc.do("POST", "/api/v1/devicestatus", status, nil)
+}
+
+// Upload sends everything a packet has to say: its entries and the
+// device status with the miaomiao's battery
+func (nsc *Client) Upload(pkt miao2go.MiaoMiaoPacket, device string, backfill bool) (int, error) {
+	posted, err := nsc.PostEntries(Entries(pkt, device, backfill))
+	if err != nil {
+		return posted, fmt.Errorf("couldn't post entries: %v", err)
+	}
+	if err = nsc.PostDeviceStatus(Status(pkt, device)); err != nil {
+		return posted, fmt.Errorf("couldn't post device status: %v", err)
+	}
+	return posted, nil
+}
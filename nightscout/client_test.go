@@ -0,0 +1,78 @@
+package nightscout
+
+import (
+	"encoding/json"
+	"github.com/thecubic/miao2go"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPostEntriesOncePerReading(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	onSite := ReadingEntry(miao2go.GlucoseReading{Serial: "0M0001", Kind: miao2go.HistoryReading, SensorMinute: 1000, Time: now.Add(-time.Hour), Glucose: 90, Raw: 900}, 0, false, "test")
+	var posted []Entry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode([]Entry{onSite})
+			return
+		}
+		var entries []Entry
+		json.NewDecoder(r.Body).Decode(&entries)
+		posted = append(posted, entries...)
+	}))
+	defer server.Close()
+	nsc := NewClient(server.URL, "", nil, 1, 0)
+
+	packet := func(jitter time.Duration) []Entry {
+		var entries []Entry
+		for idx, minute := range []int{1000, 1015, 1030} {
+			when := now.Add(-time.Hour + time.Duration(idx)*15*time.Minute + jitter)
+			reading := miao2go.GlucoseReading{Serial: "0M0001", Kind: miao2go.HistoryReading, SensorMinute: minute, Time: when, Glucose: 100, Raw: 1000}
+			entries = append(entries, ReadingEntry(reading, 0, false, "test"))
+		}
+		return entries
+	}
+	for _, jitter := range []time.Duration{0, 7 * time.Second, -3 * time.Second} {
+		if _, err := nsc.PostEntries(packet(jitter)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(posted) != 2 {
+		t.Errorf("posted %v entries, want the 2 the site didn't have: %v", len(posted), posted)
+	}
+}
+
+func TestPostEntriesOnceAcrossBuffers(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	var posted []Entry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode([]Entry{})
+			return
+		}
+		var entries []Entry
+		json.NewDecoder(r.Body).Decode(&entries)
+		posted = append(posted, entries...)
+	}))
+	defer server.Close()
+	nsc := NewClient(server.URL, "", nil, 1, 0)
+
+	for _, reading := range []miao2go.GlucoseReading{
+		{Serial: "0M0001", Kind: miao2go.TrendReading, SensorMinute: 1005, Time: now.Add(-15 * time.Minute), Glucose: 120, Raw: 1200},
+		// the same reading from the history buffer, a packet later
+		{Serial: "0M0001", Kind: miao2go.HistoryReading, SensorMinute: 1005, Time: now.Add(-15*time.Minute + 20*time.Second), Glucose: 121, Raw: 1210},
+		// and one without a sensor minute, only going by time
+		{Serial: "0M0001", Kind: miao2go.HistoryReading, Time: now.Add(-15*time.Minute - 10*time.Second), Glucose: 119, Raw: 1190},
+		// another sensor's reading at the same time is its own
+		{Serial: "0M0002", Kind: miao2go.HistoryReading, SensorMinute: 1005, Time: now.Add(-15 * time.Minute), Glucose: 80, Raw: 800},
+	} {
+		if _, err := nsc.PostEntries([]Entry{ReadingEntry(reading, 0, false, "test")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(posted) != 2 || posted[0].SGV != 120 || posted[1].SGV != 80 {
+		t.Errorf("posted %v, want the trend entry and the other sensor's", posted)
+	}
+}
@@ -0,0 +1,164 @@
+// Package nightscout speaks the Nightscout REST API, uploading miaomiao
+// readings to a Nightscout site
+package nightscout
+
+import (
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"time"
+)
+
+// Entry is a Nightscout sensor glucose value
+type Entry struct {
+	Type       string  `json:"type"`
+	SGV        int     `json:"sgv"`
+	Direction  string  `json:"direction"`
+	Delta      float64 `json:"delta,omitempty"`
+	Date       int64   `json:"date"`
+	DateString string  `json:"dateString"`
+	Device     string  `json:"device"`
+	Unfiltered int     `json:"unfiltered,omitempty"`
+	Noise      int     `json:"noise,omitempty"`
+
+	// serial and minute are the sensor and sensor minute of the reading
+	// the entry was made from, for telling whether two entries are the same
+	serial string
+	minute int
+}
+
+// Time is when the entry was read
+func (entry Entry) Time() time.Time {
+	return time.Unix(0, entry.Date*int64(time.Millisecond))
+}
+
+// sameSlop is how far apart two entries' dates can be for them to be the
+// same reading; it's under the minute between readings
+const sameSlop = 30 * int64(time.Second/time.Millisecond)
+
+// near is whether two dates are close enough to be the same reading
+func near(date, other int64) bool {
+	return date > other-sameSlop && date < other+sameSlop
+}
+
+// same is whether two entries are the same reading: from the same sensor,
+// at the same sensor minute or about the same time, whichever buffer they
+// came from. History timestamps move a little from packet to packet, and
+// the trend and history buffers both carry the latest readings
+func (entry Entry) same(other Entry) bool {
+	if entry.serial != other.serial {
+		return false
+	}
+	if entry.minute > 0 && entry.minute == other.minute {
+		return true
+	}
+	return near(entry.Date, other.Date)
+}
+
+// ReadingEntry makes an entry of a reading, with the given rate of change
+// (if known) turned into a direction
+func ReadingEntry(reading miao2go.GlucoseReading, rate float64, rateKnown bool, device string) Entry {
+	direction := miao2go.NoDirection
+	if rateKnown {
+		direction = miao2go.Direction(rate)
+	}
+	return Entry{
+		Type:       "sgv",
+		SGV:        int(reading.Glucose + 0.5),
+		Direction:  direction,
+		Date:       reading.Time.UnixNano() / int64(time.Millisecond),
+		DateString: reading.Time.UTC().Format(time.RFC3339),
+		Device:     device,
+		Unfiltered: reading.Raw,
+		Noise:      1,
+		serial:     reading.Serial,
+		minute:     reading.SensorMinute,
+	}
+}
+
+// Entries makes the entries for a packet: the latest trend reading and,
+// if backfilling, every history reading (with directions from their
+// neighbours), newest first
+func Entries(pkt miao2go.MiaoMiaoPacket, device string, backfill bool) []Entry {
+	var entries []Entry
+	latest, ok := pkt.Latest()
+	if !ok {
+		return nil
+	}
+	rate, rateKnown := pkt.TrendRate()
+	entries = append(entries, ReadingEntry(latest, rate, rateKnown, device))
+	if !backfill {
+		return entries
+	}
+	var history []miao2go.GlucoseReading
+	for _, reading := range pkt.Readings() {
+		if reading.Kind == miao2go.HistoryReading && reading.Raw > 0 {
+			history = append(history, reading)
+		}
+	}
+	for idx, reading := range history {
+		if !reading.Time.Before(latest.Time.Add(-time.Minute)) {
+			// the trend reading has this covered
+			continue
+		}
+		// the rate around a history entry is fitted over it and its neighbours
+		lo := idx - 1
+		if lo < 0 {
+			lo = 0
+		}
+		hi := lo + 3
+		if hi > len(history) {
+			hi = len(history)
+			if lo = hi - 3; lo < 0 {
+				lo = 0
+			}
+		}
+		rate, rateKnown := miao2go.Rate(history[lo:hi])
+		entries = append(entries, ReadingEntry(reading, rate, rateKnown, device))
+	}
+	return entries
+}
+
+// Uploader is the device status Nightscout shows for the collector
+type Uploader struct {
+	Battery int    `json:"battery"`
+	Name    string `json:"name,omitempty"`
+}
+
+// Transmitter is the miaomiao-specific part of a device status
+type Transmitter struct {
+	Address       string `json:"address,omitempty"`
+	Battery       int    `json:"battery"`
+	Firmware      string `json:"firmware"`
+	Hardware      string `json:"hardware"`
+	Sensor        string `json:"sensor"`
+	SensorMinutes int    `json:"sensorMinutes"`
+}
+
+// DeviceStatus is a Nightscout device status record
+type DeviceStatus struct {
+	Device      string      `json:"device"`
+	CreatedAt   string      `json:"created_at"`
+	Uploader    Uploader    `json:"uploader"`
+	Transmitter Transmitter `json:"miaomiao"`
+}
+
+// Status makes the device status for a packet; the miaomiao's battery is
+// reported as the uploader's, which is what Nightscout will show
+func Status(pkt miao2go.MiaoMiaoPacket, device string) DeviceStatus {
+	transmitter := Transmitter{
+		Address:  pkt.Transmitter,
+		Battery:  int(pkt.BatteryPercentage),
+		Firmware: fmt.Sprintf("%04x", pkt.FimrwareVersion),
+		Hardware: fmt.Sprintf("%04x", pkt.HardwareVersion),
+		Sensor:   pkt.SerialNumber,
+	}
+	if pkt.LibrePacket != nil {
+		transmitter.SensorMinutes = pkt.LibrePacket.SensorMinutes()
+	}
+	return DeviceStatus{
+		device,
+		pkt.EndTime.UTC().Format(time.RFC3339),
+		Uploader{int(pkt.BatteryPercentage), "miaomiao"},
+		transmitter,
+	}
+}
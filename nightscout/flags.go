@@ -0,0 +1,40 @@
+package nightscout
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Config is everything needed to upload to a Nightscout site
+type Config struct {
+	URL      string        `yaml:"url"`
+	Secret   string        `yaml:"secret"`
+	Device   string        `yaml:"device"`
+	Backfill bool          `yaml:"backfill"`
+	Retries  int           `yaml:"retries"`
+	Backoff  time.Duration `yaml:"backoff"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// Flags registers the ns.* flags on a flag set
+func Flags(fs *flag.FlagSet) *Config {
+	config := &Config{}
+	fs.StringVar(&config.URL, "ns.url", "", "nightscout site, e.g. https://example.herokuapp.com")
+	fs.StringVar(&config.Secret, "ns.secret", "", "nightscout API secret (plain or SHA1)")
+	fs.StringVar(&config.Device, "ns.device", "miao2go", "device name to upload as")
+	fs.BoolVar(&config.Backfill, "ns.backfill", true, "upload history readings too")
+	fs.IntVar(&config.Retries, "ns.retries", 5, "upload attempts before giving up on a packet")
+	fs.DurationVar(&config.Backoff, "ns.backoff", time.Second, "wait before the first upload retry")
+	fs.DurationVar(&config.Timeout, "ns.timeout", 30*time.Second, "nightscout request timeout")
+	return config
+}
+
+// Open makes a client for the configured site
+func (config *Config) Open() (*Client, error) {
+	if len(config.URL) == 0 {
+		return nil, fmt.Errorf("must pass ns.url")
+	}
+	return NewClient(config.URL, config.Secret, &http.Client{Timeout: config.Timeout}, config.Retries, config.Backoff), nil
+}
@@ -0,0 +1,108 @@
+package nightscout
+
+import (
+	"github.com/thecubic/miao2go"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Source is where a Server gets its data from
+type Source interface {
+	// Entries returns up to count entries from the window, newest first
+	Entries(count int, from, to time.Time) []Entry
+	// DeviceStatuses returns up to count statuses, newest first
+	DeviceStatuses(count int) []DeviceStatus
+}
+
+// Recent keeps the last while of decoded packets in memory, as a Source
+type Recent struct {
+	mu       sync.Mutex
+	keep     time.Duration
+	device   string
+	entries  []Entry
+	statuses []DeviceStatus
+}
+
+// maxStatuses is how many device statuses Recent hangs on to
+const maxStatuses = 288
+
+// NewRecent keeps entries for keep, labelling them as from device
+func NewRecent(keep time.Duration, device string) *Recent {
+	return &Recent{keep: keep, device: device}
+}
+
+// Add takes in every reading and the status of a packet.  A reading seen
+// in an earlier packet, from either buffer, keeps the entry it had then
+func (rec *Recent) Add(pkt miao2go.MiaoMiaoPacket) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for _, entry := range Entries(pkt, rec.device, true) {
+		if !including(rec.entries, entry) {
+			rec.entries = append(rec.entries, entry)
+		}
+	}
+	horizon := time.Now().Add(-rec.keep).UnixNano() / int64(time.Millisecond)
+	var kept []Entry
+	for _, entry := range rec.entries {
+		if entry.Date >= horizon {
+			kept = append(kept, entry)
+		}
+	}
+	rec.entries = kept
+	rec.statuses = append([]DeviceStatus{Status(pkt, rec.device)}, rec.statuses...)
+	if len(rec.statuses) > maxStatuses {
+		rec.statuses = rec.statuses[:maxStatuses]
+	}
+}
+
+// Entries returns up to count entries from the window, newest first, with
+// deltas filled in from the entry before
+func (rec *Recent) Entries(count int, from, to time.Time) []Entry {
+	rec.mu.Lock()
+	all := append([]Entry{}, rec.entries...)
+	rec.mu.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].Date > all[j].Date })
+	return window(withDeltas(all), count, from, to)
+}
+
+// DeviceStatuses returns up to count statuses, newest first
+func (rec *Recent) DeviceStatuses(count int) []DeviceStatus {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if count > len(rec.statuses) {
+		count = len(rec.statuses)
+	}
+	return append([]DeviceStatus{}, rec.statuses[:count]...)
+}
+
+// maxDeltaGap is the furthest apart two entries can be for a delta
+const maxDeltaGap = 16 * time.Minute
+
+// withDeltas fills in each entry's change from the one before it, for
+// entries sorted newest first
+func withDeltas(entries []Entry) []Entry {
+	for idx := 0; idx+1 < len(entries); idx++ {
+		if entries[idx].Time().Sub(entries[idx+1].Time()) <= maxDeltaGap {
+			entries[idx].Delta = float64(entries[idx].SGV - entries[idx+1].SGV)
+		}
+	}
+	return entries
+}
+
+// window picks up to count entries between from and to (either of which
+// may be zero, meaning unbounded) out of entries sorted newest first
+func window(entries []Entry, count int, from, to time.Time) []Entry {
+	picked := []Entry{}
+	for _, entry := range entries {
+		if len(picked) >= count {
+			break
+		}
+		when := entry.Time()
+		if (!from.IsZero() && when.Before(from)) || (!to.IsZero() && when.After(to)) {
+			continue
+		}
+		picked = append(picked, entry)
+	}
+	return picked
+}
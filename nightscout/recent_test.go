@@ -0,0 +1,55 @@
+package nightscout
+
+import (
+	"github.com/thecubic/miao2go"
+	"testing"
+	"time"
+)
+
+// packet stands in a trend reading and history readings for a sensor
+// minutes old, captured at when, with history on the 15 minute marks
+func packet(when time.Time, minute int, glucose ...float64) miao2go.MiaoMiaoPacket {
+	readings := []miao2go.GlucoseReading{{Serial: "0M0001", Kind: miao2go.TrendReading, SensorMinute: minute, Time: when, Glucose: glucose[0], Raw: 1000}}
+	age := 3 + (minute-3)%15
+	for idx, value := range glucose[1:] {
+		readings = append(readings, miao2go.GlucoseReading{
+			Serial: "0M0001", Kind: miao2go.HistoryReading, Index: idx,
+			SensorMinute: minute - age - idx*15, Time: when.Add(-time.Duration(age+idx*15) * time.Minute),
+			Glucose: value, Raw: 1000})
+	}
+	return miao2go.MiaoMiaoPacket{SerialNumber: "0M0001", EndTime: when, Processed: readings}
+}
+
+func TestRecentKeepsOneEntryPerReading(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	rec := NewRecent(time.Hour, "test")
+	rec.Add(packet(now.Add(-time.Minute), 1002, 120, 110, 100))
+	// a minute later, captured a few seconds off the minute
+	rec.Add(packet(now.Add(4*time.Second), 1003, 125, 110, 100))
+	entries := rec.Entries(100, time.Time{}, time.Time{})
+	var sgvs []int
+	for _, entry := range entries {
+		sgvs = append(sgvs, entry.SGV)
+	}
+	if len(sgvs) != 4 || sgvs[0] != 125 || sgvs[1] != 120 || sgvs[2] != 110 || sgvs[3] != 100 {
+		t.Fatalf("got entries %v, want 125 120 110 100", sgvs)
+	}
+	if entries[2].Delta != 10 {
+		t.Errorf("got delta %v between history entries, want 10", entries[2].Delta)
+	}
+}
+
+func TestRecentKeepsTrendOverLaterHistory(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	rec := NewRecent(time.Hour, "test")
+	rec.Add(packet(now.Add(-15*time.Minute), 1005, 120))
+	// fifteen minutes on, the same reading turns up in the history buffer
+	rec.Add(packet(now.Add(2*time.Second), 1020, 130, 121))
+	var sgvs []int
+	for _, entry := range rec.Entries(100, time.Time{}, time.Time{}) {
+		sgvs = append(sgvs, entry.SGV)
+	}
+	if len(sgvs) != 2 || sgvs[0] != 130 || sgvs[1] != 120 {
+		t.Errorf("got entries %v, want 130 120", sgvs)
+	}
+}
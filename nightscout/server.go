@@ -0,0 +1,188 @@
+package nightscout
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultCount is how many records are returned when nobody says
+const defaultCount = 10
+
+// pebbleTrends are the numeric trends the pebble endpoint uses
+var pebbleTrends = map[string]int{
+	miao2go.DoubleUp:      1,
+	miao2go.SingleUp:      2,
+	miao2go.FortyFiveUp:   3,
+	miao2go.Flat:          4,
+	miao2go.FortyFiveDown: 5,
+	miao2go.SingleDown:    6,
+	miao2go.DoubleDown:    7,
+}
+
+// Server serves enough of the Nightscout API for follower apps and watch
+// faces to point at us instead of a Nightscout site
+type Server struct {
+	source Source
+	name   string
+	mux    *http.ServeMux
+}
+
+// NewServer serves the readings of a source
+func NewServer(source Source, name string) *Server {
+	srv := &Server{source: source, name: name, mux: http.NewServeMux()}
+	srv.mux.HandleFunc("/api/v1/entries", srv.entries)
+	srv.mux.HandleFunc("/api/v1/entries.json", srv.entries)
+	srv.mux.HandleFunc("/api/v1/entries/sgv.json", srv.entries)
+	srv.mux.HandleFunc("/api/v1/entries/current.json", srv.current)
+	srv.mux.HandleFunc("/api/v1/devicestatus.json", srv.deviceStatus)
+	srv.mux.HandleFunc("/api/v1/status.json", srv.status)
+	srv.mux.HandleFunc("/pebble", srv.pebble)
+	return srv
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "read only", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	srv.mux.ServeHTTP(w, r)
+}
+
+// reply sends a JSON response
+func reply(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("couldn't send response: %v", err)
+	}
+}
+
+// count is the count parameter, or the default
+func count(r *http.Request) int {
+	if n, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && n > 0 {
+		return n
+	}
+	return defaultCount
+}
+
+// dateParam parses a find[date][...] bound, given in milliseconds or as
+// an RFC3339 date
+func dateParam(r *http.Request, op string) (time.Time, error) {
+	value := r.URL.Query().Get("find[date][" + op + "]")
+	if len(value) == 0 {
+		value = r.URL.Query().Get("find[dateString][" + op + "]")
+	}
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+	when, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad date %q", value)
+	}
+	return when, nil
+}
+
+// dateRange works out the window of find[date] bounds in a request
+func dateRange(r *http.Request) (time.Time, time.Time, error) {
+	var from, to time.Time
+	for _, op := range []string{"$gte", "$gt"} {
+		when, err := dateParam(r, op)
+		if err != nil {
+			return from, to, err
+		}
+		if !when.IsZero() {
+			from = when
+			if op == "$gt" {
+				from = from.Add(time.Millisecond)
+			}
+		}
+	}
+	for _, op := range []string{"$lte", "$lt"} {
+		when, err := dateParam(r, op)
+		if err != nil {
+			return from, to, err
+		}
+		if !when.IsZero() {
+			to = when
+			if op == "$lt" {
+				to = to.Add(-time.Millisecond)
+			}
+		}
+	}
+	return from, to, nil
+}
+
+func (srv *Server) entries(w http.ResponseWriter, r *http.Request) {
+	from, to, err := dateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reply(w, srv.source.Entries(count(r), from, to))
+}
+
+func (srv *Server) current(w http.ResponseWriter, r *http.Request) {
+	reply(w, srv.source.Entries(1, time.Time{}, time.Time{}))
+}
+
+func (srv *Server) deviceStatus(w http.ResponseWriter, r *http.Request) {
+	reply(w, srv.source.DeviceStatuses(count(r)))
+}
+
+func (srv *Server) status(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	reply(w, map[string]interface{}{
+		"status":          "ok",
+		"name":            srv.name,
+		"version":         "miao2go",
+		"apiEnabled":      true,
+		"serverTime":      now.UTC().Format(time.RFC3339),
+		"serverTimeEpoch": now.UnixNano() / int64(time.Millisecond),
+		"settings": map[string]interface{}{
+			"units": "mg/dl",
+		},
+	})
+}
+
+// pebbleBG is a reading as the pebble endpoint has it; mostly strings
+type pebbleBG struct {
+	SGV       string `json:"sgv"`
+	Trend     int    `json:"trend"`
+	Direction string `json:"direction"`
+	Datetime  int64  `json:"datetime"`
+	BGDelta   string `json:"bgdelta"`
+	Battery   string `json:"battery,omitempty"`
+}
+
+func (srv *Server) pebble(w http.ResponseWriter, r *http.Request) {
+	bgs := []pebbleBG{}
+	for idx, entry := range srv.source.Entries(count(r), time.Time{}, time.Time{}) {
+		bg := pebbleBG{
+			strconv.Itoa(entry.SGV),
+			pebbleTrends[entry.Direction],
+			entry.Direction,
+			entry.Date,
+			strconv.FormatFloat(entry.Delta, 'f', -1, 64),
+			"",
+		}
+		if idx == 0 {
+			if statuses := srv.source.DeviceStatuses(1); len(statuses) > 0 {
+				bg.Battery = strconv.Itoa(statuses[0].Uploader.Battery)
+			}
+		}
+		bgs = append(bgs, bg)
+	}
+	reply(w, map[string]interface{}{
+		"status": []map[string]int64{{"now": time.Now().UnixNano() / int64(time.Millisecond)}},
+		"bgs":    bgs,
+		"cals":   []struct{}{},
+	})
+}
@@ -0,0 +1,42 @@
+package nightscout
+
+import (
+	"github.com/thecubic/miao2go"
+	"log"
+)
+
+// Sink uploads packets to a Nightscout site, as a miao2go.Sink
+type Sink struct {
+	client   *Client
+	device   string
+	backfill bool
+}
+
+// NewSink uploads packets through client as device
+func NewSink(client *Client, device string, backfill bool) *Sink {
+	return &Sink{client, device, backfill}
+}
+
+// WriteReading uploads a packet's entries and device status
+func (sink *Sink) WriteReading(pkt miao2go.MiaoMiaoPacket) error {
+	posted, err := sink.client.Upload(pkt, sink.device, sink.backfill)
+	if err == nil {
+		log.Printf("uploaded %v entries", posted)
+	}
+	return err
+}
+
+// WriteEvent does nothing; Nightscout has nowhere to put them
+func (sink *Sink) WriteEvent(event miao2go.Event) error {
+	return nil
+}
+
+// Flush does nothing; every packet is uploaded as it comes
+func (sink *Sink) Flush() error {
+	return nil
+}
+
+// Close does nothing
+func (sink *Sink) Close() error {
+	return nil
+}
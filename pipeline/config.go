@@ -0,0 +1,319 @@
+package pipeline
+
+// pipeline: sources, processing stages and sinks, declared in a file
+
+import (
+	"flag"
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"github.com/thecubic/miao2go/alert"
+	"github.com/thecubic/miao2go/inf"
+	"github.com/thecubic/miao2go/mq"
+	"github.com/thecubic/miao2go/nightscout"
+	"github.com/thecubic/miao2go/queue"
+	"github.com/thecubic/miao2go/store"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"time"
+)
+
+// Source types
+const (
+	SourceBLE    = "ble"
+	SourceMQTT   = "mqtt"
+	SourceReplay = "replay"
+)
+
+// Stage types
+const (
+	StageDedupe    = "dedupe"
+	StageCalibrate = "calibrate"
+	StageSmooth    = "smooth"
+	StageUnits     = "units"
+)
+
+// Sink types
+const (
+	SinkPrint      = "print"
+	SinkJSON       = "json"
+	SinkStore      = "store"
+	SinkMQTT       = "mqtt"
+	SinkInflux     = "influx"
+	SinkNightscout = "nightscout"
+	SinkAlert      = "alert"
+)
+
+// Replay formats
+const (
+	FormatRecording = "recording"
+	FormatBtsnoop   = "btsnoop"
+)
+
+// Config is a whole pipeline
+type Config struct {
+	Registry string         `yaml:"registry"`
+	Queue    *queue.Config  `yaml:"queue"`
+	Sources  []SourceConfig `yaml:"sources"`
+	Stages   []StageConfig  `yaml:"stages"`
+	Sinks    []SinkConfig   `yaml:"sinks"`
+}
+
+// SourceConfig is somewhere packets come from; which fields matter
+// depends on the type
+type SourceConfig struct {
+	Type string `yaml:"type"`
+	// ble
+	Miao    string        `yaml:"miao"`
+	Accept  bool          `yaml:"accept"`
+	Timeout time.Duration `yaml:"timeout"`
+	// mqtt
+	MQTT *mq.Config `yaml:"-"`
+	// replay
+	File     string `yaml:"file"`
+	Format   string `yaml:"format"`
+	Realtime bool   `yaml:"realtime"`
+}
+
+// StageConfig is something done to every packet on its way to the sinks
+type StageConfig struct {
+	Type string `yaml:"type"`
+	// dedupe
+	Window time.Duration `yaml:"window"`
+	// calibrate
+	Slope     float64 `yaml:"slope"`
+	Intercept float64 `yaml:"intercept"`
+	// smooth
+	Points int `yaml:"points"`
+	// units
+	Units string `yaml:"units"`
+}
+
+// SinkConfig is somewhere packets go; only the config for its type is set
+type SinkConfig struct {
+	Type       string             `yaml:"type"`
+	Name       string             `yaml:"name"`
+	File       string             `yaml:"file"`
+	MQTT       *mq.Config         `yaml:"-"`
+	Influx     *inf.Config        `yaml:"-"`
+	Nightscout *nightscout.Config `yaml:"-"`
+	Store      *store.Config      `yaml:"-"`
+	Alert      *alert.Config      `yaml:"-"`
+}
+
+// defaults is a throwaway flag set, to get the flags' defaults from
+func defaults() *flag.FlagSet {
+	return flag.NewFlagSet("defaults", flag.ContinueOnError)
+}
+
+// UnmarshalYAML reads a source, starting from the defaults for its type
+func (sc *SourceConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain SourceConfig
+	config := plain{Accept: true, Timeout: 60 * time.Second, Format: FormatRecording}
+	if err := unmarshal(&config); err != nil {
+		return err
+	}
+	if config.Type == SourceMQTT {
+		config.MQTT = mq.Flags(defaults(), "m2g-collect", 1)
+		if err := unmarshal(config.MQTT); err != nil {
+			return err
+		}
+	}
+	*sc = SourceConfig(config)
+	return nil
+}
+
+// UnmarshalYAML reads a stage, starting from the defaults for its type
+func (sc *StageConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain StageConfig
+	config := plain{Window: 12 * time.Hour, Slope: 1, Points: 3}
+	if err := unmarshal(&config); err != nil {
+		return err
+	}
+	*sc = StageConfig(config)
+	return nil
+}
+
+// UnmarshalYAML reads a sink, starting from the defaults for its type
+func (sc *SinkConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain SinkConfig
+	var config plain
+	if err := unmarshal(&config); err != nil {
+		return err
+	}
+	var typed interface{}
+	switch config.Type {
+	case SinkMQTT:
+		config.MQTT = mq.Flags(defaults(), "m2g-collect", 0)
+		typed = config.MQTT
+	case SinkInflux:
+		config.Influx = inf.Flags(defaults(), "m2g-collect")
+		typed = config.Influx
+	case SinkNightscout:
+		config.Nightscout = nightscout.Flags(defaults())
+		typed = config.Nightscout
+	case SinkStore:
+		config.Store = store.Flags(defaults())
+		typed = config.Store
+	case SinkAlert:
+		config.Alert = alert.Flags(defaults())
+		typed = config.Alert
+	}
+	if typed != nil {
+		if err := unmarshal(typed); err != nil {
+			return err
+		}
+	}
+	*sc = SinkConfig(config)
+	return nil
+}
+
+// Load reads and validates a pipeline file
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{
+		Registry: miao2go.DefaultRegistryPath(),
+		Queue:    queue.Flags(defaults()),
+	}
+	if err = yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if err = config.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return config, nil
+}
+
+// Validate checks a pipeline makes sense before anything is started
+func (config *Config) Validate() error {
+	if len(config.Sources) == 0 {
+		return fmt.Errorf("no sources")
+	}
+	if len(config.Sinks) == 0 {
+		return fmt.Errorf("no sinks")
+	}
+	for idx, source := range config.Sources {
+		if err := source.Validate(); err != nil {
+			return fmt.Errorf("source %v: %v", idx, err)
+		}
+	}
+	for idx, stage := range config.Stages {
+		if err := stage.Validate(); err != nil {
+			return fmt.Errorf("stage %v: %v", idx, err)
+		}
+	}
+	names := make(map[string]bool)
+	for idx, sink := range config.Sinks {
+		if err := sink.Validate(); err != nil {
+			return fmt.Errorf("sink %v: %v", idx, err)
+		}
+		if names[sink.Label()] {
+			return fmt.Errorf("sink %v: there's already a sink called %q", idx, sink.Label())
+		}
+		names[sink.Label()] = true
+	}
+	return nil
+}
+
+// Validate checks a source has what its type needs
+func (sc SourceConfig) Validate() error {
+	switch sc.Type {
+	case SourceBLE:
+		if sc.Timeout <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+	case SourceMQTT:
+		if sc.MQTT == nil || len(sc.MQTT.Broker) == 0 {
+			return fmt.Errorf("mqtt source needs a broker")
+		}
+		if sc.MQTT.QoS < 0 || sc.MQTT.QoS > 2 {
+			return fmt.Errorf("qos must be 0, 1 or 2")
+		}
+		if err := sc.MQTT.Validate(); err != nil {
+			return err
+		}
+	case SourceReplay:
+		if len(sc.File) == 0 {
+			return fmt.Errorf("replay source needs a file")
+		}
+		if sc.Format != FormatRecording && sc.Format != FormatBtsnoop {
+			return fmt.Errorf("unknown replay format %q", sc.Format)
+		}
+	default:
+		return fmt.Errorf("unknown source type %q", sc.Type)
+	}
+	return nil
+}
+
+// Validate checks a stage's settings are sane
+func (sc StageConfig) Validate() error {
+	switch sc.Type {
+	case StageDedupe:
+		if sc.Window <= 0 {
+			return fmt.Errorf("window must be positive")
+		}
+	case StageCalibrate:
+		if sc.Slope <= 0 {
+			return fmt.Errorf("slope must be positive")
+		}
+	case StageSmooth:
+		if sc.Points < 1 {
+			return fmt.Errorf("points must be at least 1")
+		}
+	case StageUnits:
+		if sc.Units != miao2go.MgDL && sc.Units != miao2go.MmolL {
+			return fmt.Errorf("units must be %v or %v", miao2go.MgDL, miao2go.MmolL)
+		}
+	default:
+		return fmt.Errorf("unknown stage type %q", sc.Type)
+	}
+	return nil
+}
+
+// Validate checks a sink has what its type needs
+func (sc SinkConfig) Validate() error {
+	switch sc.Type {
+	case SinkPrint, SinkJSON:
+	case SinkMQTT:
+		if len(sc.MQTT.Broker) == 0 {
+			return fmt.Errorf("mqtt sink needs a broker")
+		}
+		if sc.MQTT.QoS < 0 || sc.MQTT.QoS > 2 {
+			return fmt.Errorf("qos must be 0, 1 or 2")
+		}
+		if err := sc.MQTT.Validate(); err != nil {
+			return err
+		}
+	case SinkInflux:
+		switch sc.Influx.Output {
+		case inf.OutputV1, inf.OutputV2, inf.OutputLine:
+		default:
+			return fmt.Errorf("unknown influx output %q", sc.Influx.Output)
+		}
+	case SinkNightscout:
+		if len(sc.Nightscout.URL) == 0 {
+			return fmt.Errorf("nightscout sink needs a url")
+		}
+	case SinkStore:
+		if len(sc.Store.Dir) == 0 {
+			return fmt.Errorf("store sink needs a dir")
+		}
+	case SinkAlert:
+		if err := sc.Alert.Validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown sink type %q", sc.Type)
+	}
+	return nil
+}
+
+// Label is what a sink is called in logs and stats
+func (sc SinkConfig) Label() string {
+	if len(sc.Name) > 0 {
+		return sc.Name
+	}
+	return sc.Type
+}
@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"github.com/thecubic/miao2go/mq"
+	"strings"
+	"sync"
+)
+
+// control carries out MQTT commands on whichever of the pipeline's
+// miaomiaos they're for, one at a time
+func (pl *Pipeline) control(registry *miao2go.Registry) mq.Execute {
+	var mu sync.Mutex
+	return func(cmd mq.Command) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		miao, err := pl.commanded(cmd.Transmitter, registry)
+		if err != nil {
+			return "", err
+		}
+		address := miao.Address()
+		switch cmd.Command {
+		case mq.CommandAccept:
+			return address, miao.RequestAccept()
+		case mq.CommandSetInterval:
+			return address, miao.SetInterval(cmd.Minutes)
+		case mq.CommandReadNow:
+			return address, miao.RequestReading()
+		case mq.CommandReconnect:
+			// a pipeline's source finishes when its connection drops
+			return address, fmt.Errorf("pipeline sources don't reconnect")
+		}
+		return address, fmt.Errorf("unknown command %q", cmd.Command)
+	}
+}
+
+// commanded is the live miaomiao a command is for: the one it names (by
+// address, alias or serial), or the only one there is
+func (pl *Pipeline) commanded(transmitter string, registry *miao2go.Registry) (*miao2go.ConnectedMiao, error) {
+	var live []*miao2go.ConnectedMiao
+	for _, source := range pl.sources {
+		if ble, ok := source.(*bleSource); ok {
+			if miao := ble.Live(); miao != nil {
+				live = append(live, miao)
+			}
+		}
+	}
+	if len(transmitter) == 0 {
+		switch len(live) {
+		case 0:
+			return nil, fmt.Errorf("no miaomiao connected")
+		case 1:
+			return live[0], nil
+		}
+		return nil, fmt.Errorf("%v miaomiaos connected, say which with xmit", len(live))
+	}
+	address := transmitter
+	if ent := registry.Lookup(transmitter); ent != nil {
+		address = ent.Address
+	}
+	for _, miao := range live {
+		if strings.EqualFold(miao.Address(), address) {
+			return miao, nil
+		}
+	}
+	// could be some other publisher's
+	return nil, mq.ErrNotOurs
+}
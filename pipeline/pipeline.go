@@ -0,0 +1,136 @@
+package pipeline
+
+import (
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"github.com/thecubic/miao2go/alert"
+	"log"
+	"sync"
+)
+
+// Pipeline is a running Config: every source's packets go through every
+// stage and out to every sink
+type Pipeline struct {
+	stages  []Stage
+	sources []Source
+	fanout  *miao2go.Fanout
+	packets chan miao2go.MiaoMiaoPacket
+	events  chan miao2go.Event
+	done    chan struct{}
+	alerts  map[string]*alert.Engine
+}
+
+// Start opens a config's sinks and starts its sources
+func Start(config *Config) (*Pipeline, error) {
+	return StartAfter(config, nil)
+}
+
+// StartAfter is Start in place of a stopped pipeline (or nil), whose alert
+// sinks the new ones of the same name carry on from
+func StartAfter(config *Config, previous *Pipeline) (*Pipeline, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	registry, err := miao2go.LoadRegistry(config.Registry)
+	if err != nil {
+		return nil, fmt.Errorf("can't load registry: %v", err)
+	}
+	pl := &Pipeline{
+		fanout:  miao2go.NewFanout(),
+		packets: make(chan miao2go.MiaoMiaoPacket),
+		events:  make(chan miao2go.Event),
+		done:    make(chan struct{}),
+		alerts:  make(map[string]*alert.Engine),
+	}
+	for _, sc := range config.Stages {
+		stage, err := sc.Stage()
+		if err != nil {
+			return nil, err
+		}
+		pl.stages = append(pl.stages, stage)
+	}
+	for _, sc := range config.Sources {
+		source, err := sc.Source(registry)
+		if err != nil {
+			return nil, err
+		}
+		pl.sources = append(pl.sources, source)
+	}
+	execute := pl.control(registry)
+	for _, sc := range config.Sinks {
+		sink, err := sc.Open(config.Queue, execute)
+		if err != nil {
+			pl.fanout.Close()
+			return nil, fmt.Errorf("sink %v: %v", sc.Label(), err)
+		}
+		if engine, ok := sink.(*alert.Engine); ok {
+			pl.alerts[sc.Label()] = engine
+			if previous != nil && previous.alerts[sc.Label()] != nil {
+				engine.Remember(previous.alerts[sc.Label()].Memory())
+			}
+		}
+		pl.fanout.Add(sc.Label(), sink)
+	}
+
+	var running sync.WaitGroup
+	for idx, source := range pl.sources {
+		running.Add(1)
+		go func(idx int, source Source) {
+			defer running.Done()
+			if err := source.Run(pl.packets, pl.events); err != nil {
+				log.Printf("source %v (%v): %v", idx, config.Sources[idx].Type, err)
+			}
+		}(idx, source)
+	}
+	go func() {
+		running.Wait()
+		close(pl.packets)
+	}()
+	go pl.process()
+	return pl, nil
+}
+
+// process puts packets through the stages and hands them to the sinks
+// until every source is finished
+func (pl *Pipeline) process() {
+	defer close(pl.done)
+	for {
+		select {
+		case pkt, ok := <-pl.packets:
+			if !ok {
+				pl.fanout.Close()
+				return
+			}
+			keep := true
+			for _, stage := range pl.stages {
+				if keep = stage(&pkt); !keep {
+					break
+				}
+			}
+			if keep {
+				pl.fanout.WriteReading(pkt)
+			}
+		case event := <-pl.events:
+			pl.fanout.WriteEvent(event)
+		}
+	}
+}
+
+// Done is closed once every source has finished and the sinks are closed
+func (pl *Pipeline) Done() <-chan struct{} {
+	return pl.done
+}
+
+// Stop stops every source, and returns once the sinks have caught up and
+// been closed
+func (pl *Pipeline) Stop() {
+	for _, source := range pl.sources {
+		source.Stop()
+	}
+	<-pl.done
+}
+
+// Stats reports how each sink is getting on, by name
+func (pl *Pipeline) Stats() map[string]miao2go.SinkStats {
+	return pl.fanout.Stats()
+}
@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"github.com/thecubic/miao2go/inf"
+	"github.com/thecubic/miao2go/mq"
+	"github.com/thecubic/miao2go/nightscout"
+	"github.com/thecubic/miao2go/queue"
+	"os"
+	"time"
+)
+
+// publishTimeout is how long an MQTT sink waits for the broker
+const publishTimeout = 10 * time.Second
+
+// Open opens the sink a config describes.  Sinks on the far side of a
+// network are put behind a queue.  An MQTT sink taking commands hands them
+// to execute
+func (sc SinkConfig) Open(qconfig *queue.Config, execute mq.Execute) (miao2go.Sink, error) {
+	switch sc.Type {
+	case SinkPrint:
+		return miao2go.PrintSink{}, nil
+	case SinkJSON:
+		if len(sc.File) == 0 || sc.File == "-" {
+			return miao2go.NewJSONSink(os.Stdout), nil
+		}
+		file, err := os.OpenFile(sc.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return miao2go.NewJSONSink(file), nil
+	case SinkStore:
+		return sc.Store.Open()
+	case SinkMQTT:
+		if !sc.MQTT.Commands {
+			execute = nil
+		}
+		client, err := sc.MQTT.ConnectCommands(execute)
+		if err != nil {
+			return nil, err
+		}
+		return qconfig.Sink(sc.Label(), mq.NewSink(client, sc.MQTT, publishTimeout))
+	case SinkInflux:
+		writer, err := sc.Influx.Open()
+		if err != nil {
+			return nil, err
+		}
+		return qconfig.Sink(sc.Label(), inf.NewSink(writer, sc.Influx.Prefix))
+	case SinkNightscout:
+		client, err := sc.Nightscout.Open()
+		if err != nil {
+			return nil, err
+		}
+		return qconfig.Sink(sc.Label(), nightscout.NewSink(client, sc.Nightscout.Device, sc.Nightscout.Backfill))
+	case SinkAlert:
+		return sc.Alert.Open()
+	}
+	return nil, fmt.Errorf("unknown sink type %q", sc.Type)
+}
@@ -0,0 +1,205 @@
+package pipeline
+
+import (
+	"fmt"
+	"github.com/currantlabs/ble"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/thecubic/miao2go"
+	"github.com/thecubic/miao2go/mq"
+	"golang.org/x/net/context"
+	"log"
+	"sync"
+)
+
+// Source is somewhere packets come from
+type Source interface {
+	// Run sends packets and events until the source runs dry or is stopped
+	Run(packets chan<- miao2go.MiaoMiaoPacket, events chan<- miao2go.Event) error
+	// Stop makes Run return
+	Stop()
+}
+
+// forward relays a miaomiao's packets and events until it stops emitting
+// or stop is closed
+func forward(miao *miao2go.ConnectedMiao, accept bool, stop <-chan struct{}, packets chan<- miao2go.MiaoMiaoPacket, events chan<- miao2go.Event) {
+	emitter := miao.ReadingEmitter(accept)
+	// don't leave the emitter stuck on us if we're stopped first
+	defer func() {
+		go func() {
+			for range emitter {
+			}
+		}()
+	}()
+	miaoEvents := miao.Events()
+	for {
+		select {
+		case pkt, ok := <-emitter:
+			if !ok {
+				return
+			}
+			packets <- pkt
+		case event := <-miaoEvents:
+			events <- event
+		case <-stop:
+			return
+		}
+	}
+}
+
+// bleSource is a live miaomiao; the BLE device must already be set up
+type bleSource struct {
+	config   SourceConfig
+	registry *miao2go.Registry
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stop     chan struct{}
+	once     sync.Once
+
+	mu   sync.Mutex
+	live *miao2go.ConnectedMiao
+}
+
+// Live is the miaomiao the source is reading, if it's connected
+func (src *bleSource) Live() *miao2go.ConnectedMiao {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	return src.live
+}
+
+func (src *bleSource) Run(packets chan<- miao2go.MiaoMiaoPacket, events chan<- miao2go.Event) error {
+	connectCtx, cancel := context.WithTimeout(src.ctx, src.config.Timeout)
+	defer cancel()
+	cln, err := ble.Connect(connectCtx, miao2go.MiaoFilter(src.config.Miao, src.registry))
+	if err != nil {
+		return fmt.Errorf("couldn't connect to %v: %v", src.config.Miao, err)
+	}
+	defer cln.CancelConnection()
+	log.Printf("connected to %v", cln.Address())
+	miao, err := miao2go.AttachBTLE(cln)
+	if err != nil {
+		return fmt.Errorf("couldn't get Miao descriptor: %v", err)
+	}
+	miao.UseRegistry(src.registry)
+	src.mu.Lock()
+	src.live = miao
+	src.mu.Unlock()
+	defer func() {
+		src.mu.Lock()
+		src.live = nil
+		src.mu.Unlock()
+	}()
+	forward(miao, src.config.Accept, src.stop, packets, events)
+	return nil
+}
+
+func (src *bleSource) Stop() {
+	src.once.Do(func() {
+		src.cancel()
+		close(src.stop)
+	})
+}
+
+// replaySource is a recorded session, played back
+type replaySource struct {
+	config SourceConfig
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func (src *replaySource) Run(packets chan<- miao2go.MiaoMiaoPacket, events chan<- miao2go.Event) error {
+	var (
+		recorded []miao2go.RecordedEvent
+		err      error
+	)
+	if src.config.Format == FormatBtsnoop {
+		recorded, err = miao2go.OpenBtsnoop(src.config.File)
+	} else {
+		recorded, err = miao2go.OpenRecording(src.config.File)
+	}
+	if err != nil {
+		return err
+	}
+	forward(miao2go.ReplayMiao(recorded, src.config.Realtime), true, src.stop, packets, events)
+	return nil
+}
+
+func (src *replaySource) Stop() {
+	src.once.Do(func() { close(src.stop) })
+}
+
+// mqttSource is packets someone else published
+type mqttSource struct {
+	config *mq.Config
+	stop   chan struct{}
+	once   sync.Once
+
+	// Run doesn't return, letting packets be closed, while a handler
+	// might still send on it
+	mu       sync.Mutex
+	stopped  bool
+	handling sync.WaitGroup
+}
+
+func (src *mqttSource) Run(packets chan<- miao2go.MiaoMiaoPacket, events chan<- miao2go.Event) error {
+	topic := src.config.SubscribeTopic()
+	handler := func(client mqtt.Client, msg mqtt.Message) {
+		src.mu.Lock()
+		if src.stopped {
+			src.mu.Unlock()
+			return
+		}
+		src.handling.Add(1)
+		src.mu.Unlock()
+		defer src.handling.Done()
+		decoded, err := mq.Decode(msg.Payload())
+		if err != nil {
+			log.Printf("%v: err in Decode: %v", msg.Topic(), err)
+			return
+		}
+		if decoded.Packet == nil {
+			log.Printf("%v: skipping, only full and binary payloads carry packets", msg.Topic())
+			return
+		}
+		select {
+		case packets <- *decoded.Packet:
+		case <-src.stop:
+		}
+	}
+	opts, err := src.config.Options()
+	if err != nil {
+		return err
+	}
+	// (re)subscribe every time we (re)connect
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		if token := client.Subscribe(topic, byte(src.config.QoS), handler); token.Wait() && token.Error() != nil {
+			log.Printf("couldn't subscribe to %v: %v", topic, token.Error())
+		}
+	})
+	client := mqtt.NewClient(opts)
+	client.Connect()
+	<-src.stop
+	client.Disconnect(250)
+	src.mu.Lock()
+	src.stopped = true
+	src.mu.Unlock()
+	src.handling.Wait()
+	return nil
+}
+
+func (src *mqttSource) Stop() {
+	src.once.Do(func() { close(src.stop) })
+}
+
+// Source builds the source a config describes
+func (sc SourceConfig) Source(registry *miao2go.Registry) (Source, error) {
+	switch sc.Type {
+	case SourceBLE:
+		ctx, cancel := context.WithCancel(context.Background())
+		return &bleSource{config: sc, registry: registry, ctx: ctx, cancel: cancel, stop: make(chan struct{})}, nil
+	case SourceReplay:
+		return &replaySource{config: sc, stop: make(chan struct{})}, nil
+	case SourceMQTT:
+		return &mqttSource{config: sc.MQTT, stop: make(chan struct{})}, nil
+	}
+	return nil, fmt.Errorf("unknown source type %q", sc.Type)
+}
@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"sort"
+)
+
+// Stage is something done to every packet on its way to the sinks;
+// returning false drops the packet
+type Stage func(*miao2go.MiaoMiaoPacket) bool
+
+// Dedupe drops packets whose latest reading has already been seen in the
+// last window, as when two sources relay the same miaomiao
+func Dedupe(dd *miao2go.Deduper) Stage {
+	return func(pkt *miao2go.MiaoMiaoPacket) bool {
+		latest, ok := pkt.Latest()
+		if !ok {
+			return true
+		}
+		seen := []miao2go.GlucoseReading{latest}
+		if len(dd.Fresh(seen)) == 0 {
+			return false
+		}
+		dd.Mark(seen)
+		return true
+	}
+}
+
+// Calibrate corrects every reading's glucose by a linear calibration
+func Calibrate(slope, intercept float64) Stage {
+	return func(pkt *miao2go.MiaoMiaoPacket) bool {
+		readings := append([]miao2go.GlucoseReading{}, pkt.Readings()...)
+		for idx := range readings {
+			if readings[idx].Raw > 0 {
+				readings[idx].Glucose = slope*readings[idx].Glucose + intercept
+			}
+		}
+		pkt.Processed = readings
+		return true
+	}
+}
+
+// Smooth replaces each trend reading's glucose with the average of it and
+// the points-1 readings before it
+func Smooth(points int) Stage {
+	return func(pkt *miao2go.MiaoMiaoPacket) bool {
+		readings := append([]miao2go.GlucoseReading{}, pkt.Readings()...)
+		var trend []int
+		for idx, reading := range readings {
+			if reading.Kind == miao2go.TrendReading && reading.Raw > 0 {
+				trend = append(trend, idx)
+			}
+		}
+		// most recent first
+		sort.Slice(trend, func(i, j int) bool { return readings[trend[i]].Time.After(readings[trend[j]].Time) })
+		smoothed := make([]float64, len(trend))
+		for i := range trend {
+			var sum float64
+			n := 0
+			for j := i; j < len(trend) && j < i+points; j++ {
+				sum += readings[trend[j]].Glucose
+				n++
+			}
+			smoothed[i] = sum / float64(n)
+		}
+		for i, idx := range trend {
+			readings[idx].Glucose = smoothed[i]
+		}
+		pkt.Processed = readings
+		return true
+	}
+}
+
+// Units sets the units packets' glucose should be shown in
+func Units(units string) Stage {
+	return func(pkt *miao2go.MiaoMiaoPacket) bool {
+		pkt.Units = units
+		return true
+	}
+}
+
+// Stage builds the stage a config describes
+func (sc StageConfig) Stage() (Stage, error) {
+	switch sc.Type {
+	case StageDedupe:
+		return Dedupe(miao2go.NewDeduper(sc.Window)), nil
+	case StageCalibrate:
+		return Calibrate(sc.Slope, sc.Intercept), nil
+	case StageSmooth:
+		return Smooth(sc.Points), nil
+	case StageUnits:
+		return Units(sc.Units), nil
+	}
+	return nil, fmt.Errorf("unknown stage type %q", sc.Type)
+}
@@ -0,0 +1,38 @@
+package queue
+
+import (
+	"flag"
+	"path/filepath"
+	"time"
+)
+
+// Config is where and how sinks queue their packets
+type Config struct {
+	Dir        string        `yaml:"dir"`
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"maxbackoff"`
+	// MaxAttempts and MaxDepth bound how long a packet is retried and how
+	// many wait; past them packets are given up on.  Zero is no limit
+	MaxAttempts int `yaml:"maxattempts"`
+	MaxDepth    int `yaml:"maxdepth"`
+}
+
+// Flags registers the queue.* flags on a flag set
+func Flags(fs *flag.FlagSet) *Config {
+	config := &Config{}
+	fs.StringVar(&config.Dir, "queue", "", "keep undelivered packets in this directory (default: in memory)")
+	fs.DurationVar(&config.Backoff, "queue.backoff", 5*time.Second, "wait before redelivering after a failure")
+	fs.DurationVar(&config.MaxBackoff, "queue.maxbackoff", 5*time.Minute, "longest wait between redeliveries")
+	fs.IntVar(&config.MaxAttempts, "queue.maxattempts", 100, "give up on a packet after this many failed deliveries (0: never)")
+	fs.IntVar(&config.MaxDepth, "queue.maxdepth", 10000, "give up on the oldest packets past this many queued (0: no limit)")
+	return config
+}
+
+// Open starts the queue for a sink; each sink gets its own subdirectory
+func (config *Config) Open(name string, deliver Deliver) (*Queue, error) {
+	dir := ""
+	if len(config.Dir) > 0 {
+		dir = filepath.Join(config.Dir, name)
+	}
+	return Open(name, dir, deliver, config.Backoff, config.MaxBackoff, config.MaxAttempts, config.MaxDepth)
+}
@@ -0,0 +1,274 @@
+package queue
+
+// queue: packets on their way to a sink, kept on disk until it takes them
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Deliver hands a packet to a sink; an error means try again later
+type Deliver func(miao2go.MiaoMiaoPacket) error
+
+// item is a queued packet and the file it's kept in
+type item struct {
+	seq uint64
+	pkt miao2go.MiaoMiaoPacket
+}
+
+// Queue delivers packets to a sink in order, one at a time, retrying with
+// a doubling backoff while the sink keeps failing.  Each packet is written
+// to its own file in the queue's directory until delivered, so a restart
+// picks up where the last run left off.  A queue without a directory only
+// lasts as long as the process.
+//
+// A packet the sink has refused maxAttempts times, or the oldest packet
+// when there are more than maxDepth, is given up on: moved to the dead
+// subdirectory, or just dropped in memory.  Zero means no limit
+type Queue struct {
+	mu          sync.Mutex
+	name        string
+	dir         string
+	deliver     Deliver
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	maxAttempts int
+	maxDepth    int
+	pending     []item
+	seq         uint64
+	wake        chan struct{}
+	done        chan struct{}
+	closing     sync.Once
+	stopped     chan struct{}
+}
+
+const (
+	suffix = ".json"
+	// deadDir is the subdirectory packets that are given up on go to
+	deadDir = "dead"
+)
+
+// Open starts a queue called name in dir (or in memory, if dir is empty),
+// delivering anything left over from before straight away
+func Open(name, dir string, deliver Deliver, backoff, maxBackoff time.Duration, maxAttempts, maxDepth int) (*Queue, error) {
+	q := &Queue{
+		name:        name,
+		dir:         dir,
+		deliver:     deliver,
+		backoff:     backoff,
+		maxBackoff:  maxBackoff,
+		maxAttempts: maxAttempts,
+		maxDepth:    maxDepth,
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	if len(dir) > 0 {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := q.load(); err != nil {
+			return nil, err
+		}
+		if len(q.pending) > 0 {
+			log.Printf("%v: %v packets left over in %v", name, len(q.pending), dir)
+		}
+	}
+	go q.run()
+	return q, nil
+}
+
+// path is where a queued packet is kept
+func (q *Queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, suffix))
+}
+
+// bury gives up on a packet, keeping it in the dead subdirectory where
+// there's a directory to keep it in
+func (q *Queue) bury(seq uint64) {
+	if len(q.dir) == 0 {
+		return
+	}
+	dead := filepath.Join(q.dir, deadDir)
+	if err := os.MkdirAll(dead, 0755); err != nil {
+		log.Printf("%v: couldn't make %v: %v", q.name, dead, err)
+		os.Remove(q.path(seq))
+		return
+	}
+	if err := os.Rename(q.path(seq), filepath.Join(dead, filepath.Base(q.path(seq)))); err != nil {
+		log.Printf("%v: couldn't keep dead packet: %v", q.name, err)
+		os.Remove(q.path(seq))
+	}
+}
+
+// remove takes a packet out of the queue, saying whether it was still in
+// it; Put may have given up on it meanwhile.  Call with mu held
+func (q *Queue) remove(seq uint64) bool {
+	for idx, queued := range q.pending {
+		if queued.seq == seq {
+			q.pending = append(q.pending[:idx], q.pending[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// load picks up the packets a previous run didn't deliver
+func (q *Queue) load() error {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), suffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), suffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(q.dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		var pkt miao2go.MiaoMiaoPacket
+		if err = json.Unmarshal(data, &pkt); err != nil {
+			log.Printf("%v: dropping unreadable %v: %v", q.name, file.Name(), err)
+			os.Remove(filepath.Join(q.dir, file.Name()))
+			continue
+		}
+		q.pending = append(q.pending, item{seq, pkt})
+		if seq >= q.seq {
+			q.seq = seq + 1
+		}
+	}
+	sort.Slice(q.pending, func(i, j int) bool { return q.pending[i].seq < q.pending[j].seq })
+	return nil
+}
+
+// Put queues a packet for delivery, and has it on disk before returning
+func (q *Queue) Put(pkt miao2go.MiaoMiaoPacket) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	seq := q.seq
+	if len(q.dir) > 0 {
+		data, err := json.Marshal(pkt)
+		if err != nil {
+			return err
+		}
+		// write aside and rename, so a crash never leaves half a packet
+		tmp := q.path(seq) + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		if err = os.Rename(tmp, q.path(seq)); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	q.seq++
+	q.pending = append(q.pending, item{seq, pkt})
+	if q.maxDepth > 0 && len(q.pending) > q.maxDepth {
+		oldest := q.pending[0]
+		q.pending = q.pending[1:]
+		log.Printf("%v: more than %v packets queued, giving up on the oldest from %v", q.name, q.maxDepth, oldest.pkt.EndTime)
+		q.bury(oldest.seq)
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Depth is how many packets are waiting to be delivered
+func (q *Queue) Depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Wait waits up to timeout for the queue to empty, saying whether it did
+func (q *Queue) Wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for q.Depth() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+// run delivers packets, oldest first, until the queue is closed
+func (q *Queue) run() {
+	defer close(q.stopped)
+	wait := q.backoff
+	attempts := 0
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.mu.Unlock()
+			select {
+			case <-q.wake:
+				continue
+			case <-q.done:
+				return
+			}
+		}
+		head := q.pending[0]
+		depth := len(q.pending)
+		q.mu.Unlock()
+
+		if err := q.deliver(head.pkt); err != nil {
+			if attempts++; q.maxAttempts > 0 && attempts >= q.maxAttempts {
+				log.Printf("%v: giving up on packet from %v after %v attempts: %v", q.name, head.pkt.EndTime, attempts, err)
+				q.mu.Lock()
+				if q.remove(head.seq) {
+					q.bury(head.seq)
+				}
+				q.mu.Unlock()
+				attempts, wait = 0, q.backoff
+				continue
+			}
+			log.Printf("%v: delivery failed with %v queued, retrying in %v: %v", q.name, depth, wait, err)
+			select {
+			case <-time.After(wait):
+			case <-q.done:
+				return
+			}
+			if wait *= 2; wait > q.maxBackoff {
+				wait = q.maxBackoff
+			}
+			continue
+		}
+		attempts, wait = 0, q.backoff
+
+		q.mu.Lock()
+		queued := q.remove(head.seq)
+		q.mu.Unlock()
+		if queued && len(q.dir) > 0 {
+			if err := os.Remove(q.path(head.seq)); err != nil {
+				log.Printf("%v: couldn't remove delivered packet: %v", q.name, err)
+			}
+		}
+	}
+}
+
+// Close stops delivering; whatever is undelivered stays on disk for next
+// time (or is lost, for a queue in memory)
+func (q *Queue) Close() error {
+	q.closing.Do(func() { close(q.done) })
+	<-q.stopped
+	return nil
+}
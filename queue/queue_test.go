@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// refuser is a sink that never takes anything, counting how often it's asked
+type refuser struct {
+	mu    sync.Mutex
+	tries int
+}
+
+func (ref *refuser) deliver(pkt miao2go.MiaoMiaoPacket) error {
+	ref.mu.Lock()
+	defer ref.mu.Unlock()
+	ref.tries++
+	return fmt.Errorf("no")
+}
+
+func TestQueueGivesUpAfterMaxAttempts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ref := &refuser{}
+	q, err := Open("test", dir, ref.deliver, time.Millisecond, time.Millisecond, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.Put(miao2go.MiaoMiaoPacket{SerialNumber: "0M0001"}); err != nil {
+		t.Fatal(err)
+	}
+	if !q.Wait(5 * time.Second) {
+		t.Fatalf("still %v queued", q.Depth())
+	}
+	ref.mu.Lock()
+	if ref.tries != 3 {
+		t.Errorf("tried %v times, want 3", ref.tries)
+	}
+	ref.mu.Unlock()
+	dead, _ := filepath.Glob(filepath.Join(dir, deadDir, "*"+suffix))
+	if len(dead) != 1 {
+		t.Errorf("got dead letters %v, want one", dead)
+	}
+}
+
+func TestQueueDepthCap(t *testing.T) {
+	ref := &refuser{}
+	q, err := Open("test", "", ref.deliver, time.Hour, time.Hour, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := 0; idx < 5; idx++ {
+		q.Put(miao2go.MiaoMiaoPacket{PktLength: uint16(idx)})
+	}
+	if depth := q.Depth(); depth != 2 {
+		t.Errorf("got depth %v, want 2", depth)
+	}
+	q.mu.Lock()
+	if newest := q.pending[len(q.pending)-1].pkt.PktLength; newest != 4 {
+		t.Errorf("newest queued is %v, want 4", newest)
+	}
+	q.mu.Unlock()
+	q.Close()
+	// closing twice is harmless
+	q.Close()
+}
@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"github.com/thecubic/miao2go"
+	"sync"
+)
+
+// Sink puts a queue in front of another sink, so packets it can't take
+// right now are kept and redelivered.  Events go straight through
+type Sink struct {
+	mu    sync.Mutex
+	inner miao2go.Sink
+	queue *Queue
+}
+
+// Sink opens the queue called name in front of inner
+func (config *Config) Sink(name string, inner miao2go.Sink) (*Sink, error) {
+	sink := &Sink{inner: inner}
+	q, err := config.Open(name, func(pkt miao2go.MiaoMiaoPacket) error {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		return inner.WriteReading(pkt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sink.queue = q
+	return sink, nil
+}
+
+// Queue is the queue in front of the sink
+func (sink *Sink) Queue() *Queue {
+	return sink.queue
+}
+
+// WriteReading queues a packet for the inner sink
+func (sink *Sink) WriteReading(pkt miao2go.MiaoMiaoPacket) error {
+	return sink.queue.Put(pkt)
+}
+
+// WriteEvent passes an event straight to the inner sink
+func (sink *Sink) WriteEvent(event miao2go.Event) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.inner.WriteEvent(event)
+}
+
+// Flush flushes the inner sink
+func (sink *Sink) Flush() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.inner.Flush()
+}
+
+// Close stops the queue, leaving anything undelivered for next time, and
+// closes the inner sink
+func (sink *Sink) Close() error {
+	sink.queue.Close()
+	return sink.inner.Close()
+}
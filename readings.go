@@ -0,0 +1,252 @@
+package miao2go
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// GlucoseFactor converts raw sensor counts to an uncalibrated mg/dL
+	GlucoseFactor = 8.5
+	// MmolFactor converts mg/dL to mmol/L
+	MmolFactor = 18.0182
+	// historyInterval is how far apart history entries are
+	historyInterval = 15 * time.Minute
+	// sensorMinutesOffset is where the sensor keeps its age in minutes
+	sensorMinutesOffset = 316
+)
+
+// ReadingKind is which of the sensor's buffers a reading came from
+type ReadingKind string
+
+// Reading kinds
+const (
+	// TrendReading is one of the last 16 minutes, one a minute
+	TrendReading ReadingKind = "trend"
+	// HistoryReading is one of the last 8 hours, one every 15 minutes
+	HistoryReading ReadingKind = "history"
+)
+
+// GlucoseReading is a single decoded, timestamped sensor measurement
+type GlucoseReading struct {
+	Serial      string      `json:"serial"`
+	Kind        ReadingKind `json:"kind"`
+	Index       int         `json:"index"`
+	Time        time.Time   `json:"time"`
+	Raw         int         `json:"raw"`
+	Glucose     float64     `json:"glucose"`
+	Temperature int         `json:"temperature"`
+	// SensorMinute is the sensor's own idea of when the reading was taken,
+	// which unlike Time doesn't wobble from one capture to the next
+	SensorMinute int `json:"sensor_minute"`
+}
+
+// RawGlucose is the uncalibrated glucose count of a reading
+func (lr LibreReading) RawGlucose() int {
+	return int(binary.LittleEndian.Uint16(lr.Data[0:2]) & 0x1fff)
+}
+
+// RawTemperature is the uncalibrated temperature count of a reading
+func (lr LibreReading) RawTemperature() int {
+	return int(lr.Data[4]&0x3f)<<8 | int(lr.Data[3])
+}
+
+// Glucose is the reading in mg/dL, using the stock conversion factor
+func (lr LibreReading) Glucose() float64 {
+	return float64(lr.RawGlucose()) / GlucoseFactor
+}
+
+// SensorMinutes is how long the sensor has been running, per the sensor
+func (lpkt *LibrePacket) SensorMinutes() int {
+	return int(binary.LittleEndian.Uint16(lpkt.Data[sensorMinutesOffset : sensorMinutesOffset+2]))
+}
+
+// Glucose units
+const (
+	MgDL  = "mg/dL"
+	MmolL = "mmol/L"
+)
+
+// InUnits is a reading's glucose in units
+func (reading GlucoseReading) InUnits(units string) float64 {
+	if units == MmolL {
+		return reading.Glucose / MmolFactor
+	}
+	return reading.Glucose
+}
+
+// Readings timestamps every trend and history entry of the packet, most
+// recent first within each buffer.  Trend entries are a minute apart back
+// from the capture; history entries land on the sensor's 15 minute marks
+func (lpkt *LibrePacket) Readings() []GlucoseReading {
+	readings := make([]GlucoseReading, 0, trendEntries+historyEntries)
+	minutes := lpkt.SensorMinutes()
+	for idx, lr := range lpkt.Trend {
+		readings = append(readings, GlucoseReading{
+			lpkt.SerialNumber, TrendReading, idx,
+			lpkt.CaptureTime.Add(-time.Duration(idx) * time.Minute),
+			lr.RawGlucose(), lr.Glucose(), lr.RawTemperature(),
+			minutes - idx})
+	}
+	// the latest history entry is written 3 minutes after each 15 minute mark
+	latestHistoryAge := 3
+	if minutes >= 3 {
+		latestHistoryAge = 3 + (minutes-3)%15
+	}
+	for idx, lr := range lpkt.History {
+		readings = append(readings, GlucoseReading{
+			lpkt.SerialNumber, HistoryReading, idx,
+			lpkt.CaptureTime.Add(-time.Duration(latestHistoryAge)*time.Minute - time.Duration(idx)*historyInterval),
+			lr.RawGlucose(), lr.Glucose(), lr.RawTemperature(),
+			minutes - latestHistoryAge - idx*15})
+	}
+	return readings
+}
+
+// Readings are all the decoded readings a miaomiao relayed, or their
+// processed stand-ins if there are any
+func (mmp MiaoMiaoPacket) Readings() []GlucoseReading {
+	if mmp.Processed != nil {
+		return mmp.Processed
+	}
+	if mmp.LibrePacket == nil {
+		return nil
+	}
+	return mmp.LibrePacket.Readings()
+}
+
+// Latest is the most recent trend reading, if there is one
+func (mmp MiaoMiaoPacket) Latest() (GlucoseReading, bool) {
+	for _, reading := range mmp.Readings() {
+		if reading.Kind == TrendReading && reading.Raw > 0 {
+			return reading, true
+		}
+	}
+	return GlucoseReading{}, false
+}
+
+// Key identifies a reading across packets: the same sensor, buffer and
+// sensor minute is the same reading, however many packets it shows up in.
+// Sensors that don't report their age fall back to the wall clock minute
+func (reading GlucoseReading) Key() string {
+	if reading.SensorMinute > 0 {
+		return fmt.Sprintf("%s/%s/%d", reading.Serial, reading.Kind, reading.SensorMinute)
+	}
+	return fmt.Sprintf("%s/%s/@%d", reading.Serial, reading.Kind, reading.Time.Truncate(time.Minute).Unix())
+}
+
+// Deduper remembers which readings have been dealt with, so the overlap
+// between the trend and history buffers of consecutive packets is only
+// handled once.  Readings older than the window are forgotten
+type Deduper struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[string]time.Time
+}
+
+// NewDeduper remembers readings for window; history reaches back 8 hours
+func NewDeduper(window time.Duration) *Deduper {
+	return &Deduper{window: window, seen: make(map[string]time.Time)}
+}
+
+// Fresh returns the readings that haven't been marked yet
+func (dd *Deduper) Fresh(readings []GlucoseReading) []GlucoseReading {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	var fresh []GlucoseReading
+	for _, reading := range readings {
+		if _, ok := dd.seen[reading.Key()]; !ok {
+			fresh = append(fresh, reading)
+		}
+	}
+	return fresh
+}
+
+// Mark remembers readings as dealt with
+func (dd *Deduper) Mark(readings []GlucoseReading) {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	horizon := time.Now().Add(-dd.window)
+	for key, when := range dd.seen {
+		if when.Before(horizon) {
+			delete(dd.seen, key)
+		}
+	}
+	for _, reading := range readings {
+		dd.seen[reading.Key()] = reading.Time
+	}
+}
+
+// trendRateSpan is how much of the trend buffer goes into TrendRate
+const trendRateSpan = 10
+
+// Rate fits a line through readings, returning the rate of change in
+// mg/dL per minute.  It needs at least three non-empty readings
+func Rate(readings []GlucoseReading) (float64, bool) {
+	var n, sumT, sumG, sumTT, sumTG float64
+	var origin time.Time
+	for _, reading := range readings {
+		if reading.Raw == 0 {
+			continue
+		}
+		if origin.IsZero() {
+			origin = reading.Time
+		}
+		t := reading.Time.Sub(origin).Minutes()
+		n++
+		sumT += t
+		sumG += reading.Glucose
+		sumTT += t * t
+		sumTG += t * reading.Glucose
+	}
+	denominator := n*sumTT - sumT*sumT
+	if n < 3 || denominator == 0 {
+		return 0, false
+	}
+	return (n*sumTG - sumT*sumG) / denominator, true
+}
+
+// TrendRate is how fast glucose is changing, in mg/dL per minute, over the
+// most recent minutes of the trend buffer
+func (mmp MiaoMiaoPacket) TrendRate() (float64, bool) {
+	var recent []GlucoseReading
+	for _, reading := range mmp.Readings() {
+		if reading.Kind == TrendReading && reading.Index < trendRateSpan {
+			recent = append(recent, reading)
+		}
+	}
+	return Rate(recent)
+}
+
+// Trend arrows, by rate of change, as Nightscout names them
+const (
+	DoubleUp      = "DoubleUp"
+	SingleUp      = "SingleUp"
+	FortyFiveUp   = "FortyFiveUp"
+	Flat          = "Flat"
+	FortyFiveDown = "FortyFiveDown"
+	SingleDown    = "SingleDown"
+	DoubleDown    = "DoubleDown"
+	NoDirection   = "NONE"
+)
+
+// Direction is the trend arrow for a rate of change in mg/dL per minute
+func Direction(rate float64) string {
+	switch {
+	case rate > 3:
+		return DoubleUp
+	case rate > 2:
+		return SingleUp
+	case rate > 1:
+		return FortyFiveUp
+	case rate >= -1:
+		return Flat
+	case rate >= -2:
+		return FortyFiveDown
+	case rate >= -3:
+		return SingleDown
+	}
+	return DoubleDown
+}
@@ -0,0 +1,243 @@
+package miao2go
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/currantlabs/ble"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// RecordKind is what sort of GATT traffic a recorded event was
+type RecordKind string
+
+// Recorded event kinds
+const (
+	// RecordAttach notes which miaomiao the recording is of
+	RecordAttach RecordKind = "attach"
+	// RecordNotify is a notification delivered to gattDataCallback
+	RecordNotify RecordKind = "notify"
+	// RecordWrite is a characteristic or descriptor write we made
+	RecordWrite RecordKind = "write"
+)
+
+// Record targets, i.e. which end of the GATT profile was involved
+const (
+	targetXmit = "xmit"
+	targetRecv = "recv"
+	targetDesc = "desc"
+)
+
+// HexBytes is a byte slice that reads and writes as a hex string
+type HexBytes []byte
+
+// MarshalText renders the bytes as hex
+func (hb HexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(hb)), nil
+}
+
+// UnmarshalText parses hex back into bytes
+func (hb *HexBytes) UnmarshalText(text []byte) error {
+	data, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*hb = data
+	return nil
+}
+
+// RecordedEvent is a single timestamped piece of GATT traffic.
+// Recordings are stored as one JSON event per line
+type RecordedEvent struct {
+	Time   time.Time  `json:"time"`
+	Kind   RecordKind `json:"kind"`
+	Target string     `json:"target"`
+	Data   HexBytes   `json:"data,omitempty"`
+}
+
+// Recorder captures GATT traffic of a ConnectedMiao to a writer
+type Recorder struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewRecorder records to an already-open writer
+func NewRecorder(w io.Writer) *Recorder {
+	rec := &Recorder{encoder: json.NewEncoder(w)}
+	if closer, ok := w.(io.Closer); ok {
+		rec.closer = closer
+	}
+	return rec
+}
+
+// CreateRecorder records to a newly created file
+func CreateRecorder(path string) (*Recorder, error) {
+	fh, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create recording: %v", err)
+	}
+	return NewRecorder(fh), nil
+}
+
+// Record appends an event to the recording
+func (rec *Recorder) Record(kind RecordKind, target string, data []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	// copy, as the BLE stack is free to reuse its buffers
+	copied := make(HexBytes, len(data))
+	copy(copied, data)
+	if err := rec.encoder.Encode(RecordedEvent{time.Now(), kind, target, copied}); err != nil {
+		log.Printf("couldn't record %v: %v", kind, err)
+	}
+}
+
+// Close closes the underlying writer, if it can be closed
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closer != nil {
+		return rec.closer.Close()
+	}
+	return nil
+}
+
+// ReadRecording loads every event from a recording
+func ReadRecording(r io.Reader) ([]RecordedEvent, error) {
+	var events []RecordedEvent
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event RecordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("bad event on line %v: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// OpenRecording loads every event from a recording file
+func OpenRecording(path string) ([]RecordedEvent, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return ReadRecording(fh)
+}
+
+// Record starts capturing every notification and write of this miaomiao
+func (lcm *ConnectedMiao) Record(rec *Recorder) {
+	lcm.recorder = rec
+	rec.Record(RecordAttach, lcm.Address(), nil)
+}
+
+// replayAddr is the address a replayed miaomiao claims to have
+type replayAddr string
+
+func (ra replayAddr) String() string {
+	return string(ra)
+}
+
+// replayClient stands in for a BLE connection, feeding recorded
+// notifications back in rather than listening to a radio
+type replayClient struct {
+	address  replayAddr
+	events   []RecordedEvent
+	writes   int
+	realtime bool
+	playing  bool
+	deliver  func(data []byte, when time.Time)
+	finished func()
+}
+
+func (rc *replayClient) Address() ble.Addr {
+	return rc.address
+}
+
+// Subscribe plays back every recorded notification, in order and stamped
+// with the time it was recorded rather than the handler
+func (rc *replayClient) Subscribe(c *ble.Characteristic, ind bool, h ble.NotificationHandler) error {
+	if rc.playing {
+		return nil
+	}
+	rc.playing = true
+	go func() {
+		var last time.Time
+		for _, event := range rc.events {
+			if event.Kind != RecordNotify {
+				continue
+			}
+			if rc.realtime && !last.IsZero() {
+				time.Sleep(event.Time.Sub(last))
+			}
+			last = event.Time
+			rc.deliver(event.Data, event.Time)
+		}
+		rc.finished()
+	}()
+	return nil
+}
+
+// replayed writes go nowhere, but are checked against what was recorded
+func (rc *replayClient) write(target string, data []byte) {
+	for ; rc.writes < len(rc.events); rc.writes++ {
+		if rc.events[rc.writes].Kind == RecordWrite {
+			break
+		}
+	}
+	if rc.writes == len(rc.events) {
+		log.Printf("replay: unrecorded %v write %x", target, data)
+		return
+	}
+	event := rc.events[rc.writes]
+	rc.writes++
+	if event.Target != target || hex.EncodeToString(event.Data) != hex.EncodeToString(data) {
+		log.Printf("replay: wrote %v %x, recording has %v %x", target, data, event.Target, []byte(event.Data))
+	}
+}
+
+func (rc *replayClient) WriteDescriptor(d *ble.Descriptor, v []byte) error {
+	rc.write(targetDesc, v)
+	return nil
+}
+
+func (rc *replayClient) WriteCharacteristic(c *ble.Characteristic, v []byte, noRsp bool) error {
+	rc.write(targetRecv, v)
+	return nil
+}
+
+// ReplayMiao creates a ConnectedMiao that is fed from a recording instead of
+// a live BLE connection.  With realtime set, notifications are delivered
+// with their recorded spacing; otherwise as fast as they are consumed.
+// Once the recording runs out, reads fail as if the device hung up
+func ReplayMiao(events []RecordedEvent, realtime bool) *ConnectedMiao {
+	client := &replayClient{events: events, realtime: realtime}
+	for _, event := range events {
+		if event.Kind == RecordAttach {
+			client.address = replayAddr(event.Target)
+			break
+		}
+	}
+	lcm := newConnectedMiao(client, nil, nil, nil, nil)
+	client.deliver = func(data []byte, when time.Time) {
+		// a recording can always wait for the reader, unlike a radio
+		lcm.gattData(data, when, true)
+	}
+	client.finished = func() {
+		lcm.setBtState(MSDisconnected, time.Now())
+		close(lcm.datachan)
+	}
+	return lcm
+}
@@ -0,0 +1,161 @@
+package miao2go
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RegistryEntry is what we remember about a single miaomiao
+type RegistryEntry struct {
+	Address  string    `json:"address"`
+	Alias    string    `json:"alias,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Serial   string    `json:"serial,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Registry is a local, file-backed mapping of miaomiao address to the
+// last sensor serial it reported and a friendly alias
+type Registry struct {
+	path    string
+	mu      sync.Mutex
+	Devices map[string]*RegistryEntry `json:"devices"`
+}
+
+// DefaultRegistryPath is where the registry lives unless told otherwise
+func DefaultRegistryPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "miao2go", "registry.json")
+}
+
+// LoadRegistry reads a registry from disk; a missing file is an empty registry
+func LoadRegistry(path string) (*Registry, error) {
+	reg := &Registry{path: path, Devices: make(map[string]*RegistryEntry)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return reg, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("couldn't read registry: %v", err)
+	}
+	if err = json.Unmarshal(data, reg); err != nil {
+		return nil, fmt.Errorf("couldn't parse registry %v: %v", path, err)
+	}
+	if reg.Devices == nil {
+		reg.Devices = make(map[string]*RegistryEntry)
+	}
+	return reg, nil
+}
+
+// Save writes the registry back to where it was loaded from
+func (reg *Registry) Save() error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.save()
+}
+
+func (reg *Registry) save() error {
+	data, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(reg.path), 0755); err != nil {
+		return fmt.Errorf("couldn't create registry dir: %v", err)
+	}
+	// write-then-rename so a crash can't leave half a registry
+	tmp := reg.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("couldn't write registry: %v", err)
+	}
+	return os.Rename(tmp, reg.path)
+}
+
+// entry returns the entry for an address, creating it if need be
+func (reg *Registry) entry(address string) *RegistryEntry {
+	address = strings.ToLower(address)
+	ent, ok := reg.Devices[address]
+	if !ok {
+		ent = &RegistryEntry{Address: address}
+		reg.Devices[address] = ent
+	}
+	return ent
+}
+
+// Lookup finds a device by alias, address or last-seen sensor serial
+func (reg *Registry) Lookup(selector string) *RegistryEntry {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if ent, ok := reg.Devices[strings.ToLower(selector)]; ok {
+		copied := *ent
+		return &copied
+	}
+	for _, ent := range reg.Devices {
+		if ent.Alias == selector || (len(ent.Serial) > 0 && ent.Serial == selector) {
+			copied := *ent
+			return &copied
+		}
+	}
+	return nil
+}
+
+// Entries returns a copy of every known device
+func (reg *Registry) Entries() []RegistryEntry {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	entries := make([]RegistryEntry, 0, len(reg.Devices))
+	for _, ent := range reg.Devices {
+		entries = append(entries, *ent)
+	}
+	return entries
+}
+
+// SetAlias gives a device a friendly name and persists it
+func (reg *Registry) SetAlias(address, alias string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, ent := range reg.Devices {
+		if ent.Alias == alias && ent.Address != strings.ToLower(address) {
+			return fmt.Errorf("alias %v already belongs to %v", alias, ent.Address)
+		}
+	}
+	reg.entry(address).Alias = alias
+	return reg.save()
+}
+
+// SawAdvertisement records that a device was advertising under a name.
+// It's not persisted on its own; advertisements are far too chatty
+func (reg *Registry) SawAdvertisement(address, name string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	ent := reg.entry(address)
+	if len(name) > 0 {
+		ent.Name = name
+	}
+	ent.LastSeen = time.Now()
+}
+
+// Observe records the sensor a device just reported and persists it
+func (reg *Registry) Observe(address, serial string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	ent := reg.entry(address)
+	ent.Serial = serial
+	ent.LastSeen = time.Now()
+	return reg.save()
+}
+
+// Label is the most human-friendly name we know for a device
+func (ent RegistryEntry) Label() string {
+	if len(ent.Alias) > 0 {
+		return ent.Alias
+	}
+	return ent.Address
+}
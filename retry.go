@@ -0,0 +1,25 @@
+package miao2go
+
+import (
+	"fmt"
+	"time"
+)
+
+// Retry calls try until it succeeds or attempts run out, doubling the
+// wait between attempts from backoff; it always tries at least once
+func Retry(attempts int, backoff time.Duration, try func() error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = try(); err == nil {
+			return nil
+		}
+		if attempt < attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return fmt.Errorf("gave up after %v attempts: %v", attempts, err)
+}
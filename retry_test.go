@@ -0,0 +1,33 @@
+package miao2go
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRetry(t *testing.T) {
+	refused := errors.New("refused")
+	for _, test := range []struct {
+		attempts, failures, tries int
+		ok                        bool
+	}{
+		{attempts: 3, failures: 0, tries: 1, ok: true},
+		{attempts: 3, failures: 2, tries: 3, ok: true},
+		{attempts: 3, failures: 5, tries: 3, ok: false},
+		{attempts: 0, failures: 0, tries: 1, ok: true},
+		{attempts: 0, failures: 5, tries: 1, ok: false},
+		{attempts: -1, failures: 0, tries: 1, ok: true},
+	} {
+		tries := 0
+		err := Retry(test.attempts, 0, func() error {
+			tries++
+			if tries <= test.failures {
+				return refused
+			}
+			return nil
+		})
+		if tries != test.tries || (err == nil) != test.ok {
+			t.Errorf("%v attempts, %v failures: tried %v times, err %v", test.attempts, test.failures, tries, err)
+		}
+	}
+}
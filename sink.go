@@ -0,0 +1,280 @@
+package miao2go
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// EventKind is what sort of thing an Event is about
+type EventKind string
+
+// Event kinds
+const (
+	// EventState is a change in a miaomiao's BLE state
+	EventState EventKind = "state"
+	// EventError is something going wrong that isn't a sink's own problem
+	EventError EventKind = "error"
+)
+
+// Event is something that happened to a miaomiao that isn't a reading
+type Event struct {
+	Time        time.Time `json:"time"`
+	Kind        EventKind `json:"kind"`
+	Transmitter string    `json:"xmit,omitempty"`
+	Message     string    `json:"message"`
+	// State is the BLE state moved to, for EventState
+	State string `json:"state,omitempty"`
+}
+
+// Sink is somewhere decoded packets go.  Sinks are only ever called from
+// one goroutine at a time
+type Sink interface {
+	// WriteReading takes a decoded packet and every reading in it
+	WriteReading(MiaoMiaoPacket) error
+	// WriteEvent takes anything else worth knowing about
+	WriteEvent(Event) error
+	// Flush pushes out anything the sink has buffered
+	Flush() error
+	// Close flushes and lets go of the sink
+	Close() error
+}
+
+// Events turns BLE state changes into Events.  Events nobody is around to
+// take are dropped rather than hold up the BLE stack
+func (lcm *ConnectedMiao) Events() <-chan Event {
+	events := make(chan Event, 16)
+	lcm.OnTransition(func(st StateTransition) {
+		select {
+		case events <- Event{st.Time, EventState, lcm.Address(), fmt.Sprintf("%v -> %v", st.From, st.To), st.To.String()}:
+		default:
+		}
+	})
+	return events
+}
+
+// PrintSink prints packets and events to stdout, the way the commands
+// always have
+type PrintSink struct{}
+
+// WriteReading prints a packet
+func (PrintSink) WriteReading(pkt MiaoMiaoPacket) error {
+	pkt.Print()
+	if pkt.LibrePacket != nil {
+		pkt.LibrePacket.Print()
+	}
+	if latest, ok := pkt.Latest(); ok {
+		units := pkt.Units
+		if len(units) == 0 {
+			units = MgDL
+		}
+		fmt.Printf("  Latest: %.1f %v at %v\n", latest.InUnits(units), units, latest.Time.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// WriteEvent prints an event
+func (PrintSink) WriteEvent(event Event) error {
+	fmt.Printf("%v %v %v: %v\n", event.Time.Format(time.RFC3339), event.Transmitter, event.Kind, event.Message)
+	return nil
+}
+
+// Flush does nothing
+func (PrintSink) Flush() error { return nil }
+
+// Close does nothing
+func (PrintSink) Close() error { return nil }
+
+// JSONSink writes packets and events to a writer as NDJSON
+type JSONSink struct {
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewJSONSink writes NDJSON to w, closing it on Close if it's a Closer
+func NewJSONSink(w io.Writer) *JSONSink {
+	js := &JSONSink{encoder: json.NewEncoder(w)}
+	if closer, ok := w.(io.Closer); ok {
+		js.closer = closer
+	}
+	return js
+}
+
+// WriteReading writes a packet as a line of JSON
+func (js *JSONSink) WriteReading(pkt MiaoMiaoPacket) error {
+	return js.encoder.Encode(pkt)
+}
+
+// WriteEvent writes an event as a line of JSON
+func (js *JSONSink) WriteEvent(event Event) error {
+	return js.encoder.Encode(event)
+}
+
+// Flush does nothing; every line is written as it comes
+func (js *JSONSink) Flush() error { return nil }
+
+// Close closes the underlying writer, if it can be
+func (js *JSONSink) Close() error {
+	if js.closer != nil {
+		return js.closer.Close()
+	}
+	return nil
+}
+
+// SinkStats are how a sink in a Fanout has been getting on
+type SinkStats struct {
+	Written uint64
+	Failed  uint64
+	Dropped uint64
+}
+
+// FanoutBuffer is how many packets and events each sink of a Fanout can
+// fall behind by before it starts missing them
+var FanoutBuffer = 64
+
+// sinkItem is a packet or an event on its way to a sink
+type sinkItem struct {
+	pkt   *MiaoMiaoPacket
+	event *Event
+}
+
+// fanoutSink is a sink with its own goroutine and backlog
+type fanoutSink struct {
+	name    string
+	sink    Sink
+	backlog chan sinkItem
+	stats   SinkStats
+}
+
+// Fanout delivers one stream of packets and events to any number of
+// sinks.  Each sink runs on its own goroutine with its own backlog, so a
+// slow or failing sink only holds up (and loses data for) itself
+type Fanout struct {
+	mu     sync.Mutex
+	sinks  []*fanoutSink
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewFanout makes an empty Fanout; Add sinks to it before Running it
+func NewFanout() *Fanout {
+	return &Fanout{}
+}
+
+// Add starts delivering to a sink, under name in logs and stats
+func (fo *Fanout) Add(name string, sink Sink) {
+	fs := &fanoutSink{name: name, sink: sink, backlog: make(chan sinkItem, FanoutBuffer)}
+	fo.mu.Lock()
+	fo.sinks = append(fo.sinks, fs)
+	fo.mu.Unlock()
+	fo.wg.Add(1)
+	go fo.drain(fs)
+}
+
+// drain feeds one sink until its backlog is closed, then closes it
+func (fo *Fanout) drain(fs *fanoutSink) {
+	defer fo.wg.Done()
+	for item := range fs.backlog {
+		var err error
+		if item.pkt != nil {
+			err = fs.sink.WriteReading(*item.pkt)
+		} else {
+			err = fs.sink.WriteEvent(*item.event)
+		}
+		fo.mu.Lock()
+		if err != nil {
+			fs.stats.Failed++
+		} else {
+			fs.stats.Written++
+		}
+		fo.mu.Unlock()
+		if err != nil {
+			log.Printf("%v: %v", fs.name, err)
+		}
+		// caught up; a good time for batching sinks to push out
+		if len(fs.backlog) == 0 {
+			if err = fs.sink.Flush(); err != nil {
+				log.Printf("%v: couldn't flush: %v", fs.name, err)
+			}
+		}
+	}
+	if err := fs.sink.Close(); err != nil {
+		log.Printf("%v: couldn't close: %v", fs.name, err)
+	}
+}
+
+// offer hands an item to every sink that has room for it
+func (fo *Fanout) offer(item sinkItem) {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	if fo.closed {
+		return
+	}
+	for _, fs := range fo.sinks {
+		select {
+		case fs.backlog <- item:
+		default:
+			fs.stats.Dropped++
+			log.Printf("%v: falling behind, dropped %v", fs.name, fs.stats.Dropped)
+		}
+	}
+}
+
+// WriteReading hands a packet to every sink
+func (fo *Fanout) WriteReading(pkt MiaoMiaoPacket) {
+	fo.offer(sinkItem{pkt: &pkt})
+}
+
+// WriteEvent hands an event to every sink
+func (fo *Fanout) WriteEvent(event Event) {
+	fo.offer(sinkItem{event: &event})
+}
+
+// Run delivers packets and events until the packets run out, then closes
+// every sink once it has caught up.  events may be nil
+func (fo *Fanout) Run(packets <-chan MiaoMiaoPacket, events <-chan Event) {
+	for packets != nil {
+		select {
+		case pkt, ok := <-packets:
+			if !ok {
+				packets = nil
+				continue
+			}
+			fo.WriteReading(pkt)
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			fo.WriteEvent(event)
+		}
+	}
+	fo.Close()
+}
+
+// Close closes every sink once it has caught up
+func (fo *Fanout) Close() {
+	fo.mu.Lock()
+	if !fo.closed {
+		fo.closed = true
+		for _, fs := range fo.sinks {
+			close(fs.backlog)
+		}
+	}
+	fo.mu.Unlock()
+	fo.wg.Wait()
+}
+
+// Stats reports how each sink is getting on, by name
+func (fo *Fanout) Stats() map[string]SinkStats {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	stats := make(map[string]SinkStats, len(fo.sinks))
+	for _, fs := range fo.sinks {
+		stats[fs.name] = fs.stats
+	}
+	return stats
+}
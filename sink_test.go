@@ -0,0 +1,32 @@
+package miao2go
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFanoutRunWithClosedEvents(t *testing.T) {
+	var out bytes.Buffer
+	fo := NewFanout()
+	fo.Add("json", NewJSONSink(&out))
+	packets := make(chan MiaoMiaoPacket)
+	events := make(chan Event)
+	close(events)
+	done := make(chan struct{})
+	go func() {
+		fo.Run(packets, events)
+		close(done)
+	}()
+	packets <- MiaoMiaoPacket{SerialNumber: "0M0001"}
+	close(packets)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't finish")
+	}
+	if !strings.Contains(out.String(), "0M0001") {
+		t.Errorf("packet didn't reach the sink: %q", out.String())
+	}
+}
@@ -0,0 +1,143 @@
+package miao2go
+
+import (
+	"fmt"
+	"time"
+)
+
+// validTransitions are the BLE state changes a miaomiao can go through
+var validTransitions = map[MiaoBluetoothState][]MiaoBluetoothState{
+	MSDeclared:      {MSConnected, MSDisconnected},
+	MSConnected:     {MSSubscribed, MSDisconnected},
+	MSSubscribed:    {MSBeingNotified, MSDisconnected},
+	MSBeingNotified: {MSSubscribed, MSDisconnected},
+	MSDisconnected:  {},
+}
+
+func (mbs MiaoBluetoothState) String() string {
+	switch mbs {
+	case MSDeclared:
+		return "declared"
+	case MSConnected:
+		return "connected"
+	case MSSubscribed:
+		return "subscribed"
+	case MSBeingNotified:
+		return "being-notified"
+	case MSDisconnected:
+		return "disconnected"
+	}
+	return fmt.Sprintf("MiaoBluetoothState(%d)", int(mbs))
+}
+
+func (mds MiaoDeviceState) String() string {
+	switch mds {
+	case MPDeclared:
+		return "unknown"
+	case MPLibre:
+		return "libre"
+	case MPNewSensor:
+		return "new-sensor"
+	case MPNoSensor:
+		return "no-sensor"
+	}
+	return fmt.Sprintf("MiaoDeviceState(%#02x)", byte(mds))
+}
+
+// StateTransition describes a change in BLE state
+type StateTransition struct {
+	From MiaoBluetoothState
+	To   MiaoBluetoothState
+	Time time.Time
+}
+
+// TransitionHook is called after every BLE state change, from whichever
+// goroutine made it (quite possibly the BLE stack's); don't dawdle
+type TransitionHook func(StateTransition)
+
+// MiaoState is a consistent snapshot of a miaomiao's state
+type MiaoState struct {
+	BtState  MiaoBluetoothState
+	DevState MiaoDeviceState
+	LastEmit time.Time
+	NextEmit time.Time
+}
+
+// OnTransition registers a hook to fire on each BLE state change
+func (lcm *ConnectedMiao) OnTransition(hook TransitionHook) {
+	lcm.mu.Lock()
+	defer lcm.mu.Unlock()
+	lcm.hooks = append(lcm.hooks, hook)
+}
+
+// Snapshot returns all of the miaomiao's state at once
+func (lcm *ConnectedMiao) Snapshot() MiaoState {
+	lcm.mu.Lock()
+	defer lcm.mu.Unlock()
+	return MiaoState{lcm.btState, lcm.devState, lcm.lastEmit, lcm.nextEmit}
+}
+
+// BtState is the percieved BLE state of the device
+func (lcm *ConnectedMiao) BtState() MiaoBluetoothState {
+	lcm.mu.Lock()
+	defer lcm.mu.Unlock()
+	return lcm.btState
+}
+
+// DevState is the percieved application state of the device
+func (lcm *ConnectedMiao) DevState() MiaoDeviceState {
+	lcm.mu.Lock()
+	defer lcm.mu.Unlock()
+	return lcm.devState
+}
+
+// LastEmit is when the device last started sending a response
+func (lcm *ConnectedMiao) LastEmit() time.Time {
+	lcm.mu.Lock()
+	defer lcm.mu.Unlock()
+	return lcm.lastEmit
+}
+
+// NextEmit is when the device is next expected to send a response
+func (lcm *ConnectedMiao) NextEmit() time.Time {
+	lcm.mu.Lock()
+	defer lcm.mu.Unlock()
+	return lcm.nextEmit
+}
+
+// setDevState records the application state of the device
+func (lcm *ConnectedMiao) setDevState(state MiaoDeviceState) {
+	lcm.mu.Lock()
+	defer lcm.mu.Unlock()
+	lcm.devState = state
+}
+
+// setBtState moves to a new BLE state, refusing transitions that make no
+// sense.  Moving to the state we're already in is a no-op
+func (lcm *ConnectedMiao) setBtState(to MiaoBluetoothState, when time.Time) error {
+	lcm.mu.Lock()
+	from := lcm.btState
+	if from == to {
+		lcm.mu.Unlock()
+		return nil
+	}
+	valid := false
+	for _, next := range validTransitions[from] {
+		if next == to {
+			valid = true
+		}
+	}
+	if !valid {
+		lcm.mu.Unlock()
+		return fmt.Errorf("invalid state transition %v -> %v", from, to)
+	}
+	lcm.btState = to
+	hooks := lcm.hooks
+	lcm.mu.Unlock()
+
+	transition := StateTransition{from, to, when}
+	for _, hook := range hooks {
+		hook(transition)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package store
+
+import (
+	"flag"
+)
+
+// Config is where, if anywhere, a collector keeps its readings
+type Config struct {
+	Dir string `yaml:"dir"`
+}
+
+// Flags registers the --store flag on a flag set
+func Flags(fs *flag.FlagSet) *Config {
+	config := &Config{}
+	fs.StringVar(&config.Dir, "store", "", "keep readings and raw packets in this directory")
+	return config
+}
+
+// Open opens the configured store, or returns nil if there isn't one
+func (config *Config) Open() (*Store, error) {
+	if len(config.Dir) == 0 {
+		return nil, nil
+	}
+	return Open(config.Dir)
+}
@@ -0,0 +1,355 @@
+package store
+
+// store: readings and raw packets kept on disk, one directory per sensor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/thecubic/miao2go"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	readingsFile = "readings.ndjson"
+	packetsFile  = "packets.ndjson"
+)
+
+// StoredPacket is a raw miaomiao frame as kept on disk; everything else
+// about a packet can be decoded again from it
+type StoredPacket struct {
+	Start       time.Time        `json:"start"`
+	End         time.Time        `json:"end"`
+	Transmitter string           `json:"xmit,omitempty"`
+	Data        miao2go.HexBytes `json:"data"`
+}
+
+// Packet decodes a stored packet again
+func (sp StoredPacket) Packet() (miao2go.MiaoMiaoPacket, error) {
+	var mmp miao2go.MiaoMiaoPacket
+	if len(sp.Data) != miao2go.MiaoFrameLength {
+		return mmp, fmt.Errorf("stored frame is %v bytes", len(sp.Data))
+	}
+	copy(mmp.Data[:], sp.Data)
+	mmp.StartTime = sp.Start
+	mmp.EndTime = sp.End
+	mmp.Transmitter = sp.Transmitter
+	return mmp.Redecode(), nil
+}
+
+// series is everything known about one sensor
+type series struct {
+	keys        map[string]bool
+	readings    []miao2go.GlucoseReading
+	readingsOut *os.File
+	packetsOut  *os.File
+}
+
+// Store keeps decoded readings and raw packets under a directory, one
+// subdirectory per sensor serial.  Readings are appended as NDJSON and
+// indexed in memory, so queries don't touch the disk; raw packets are
+// only read back on request
+type Store struct {
+	mu     sync.Mutex
+	dir    string
+	series map[string]*series
+}
+
+// Open opens (creating if need be) a store in dir, loading its index
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	st := &Store{dir: dir, series: make(map[string]*series)}
+	subdirs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, subdir := range subdirs {
+		if !subdir.IsDir() {
+			continue
+		}
+		if err := st.load(subdir.Name()); err != nil {
+			st.Close()
+			return nil, fmt.Errorf("couldn't load %v: %v", subdir.Name(), err)
+		}
+	}
+	return st, nil
+}
+
+// load indexes the readings of one sensor
+func (st *Store) load(serial string) error {
+	ser := &series{keys: make(map[string]bool)}
+	st.series[serial] = ser
+	err := scan(filepath.Join(st.dir, serial, readingsFile), func(line []byte) error {
+		var reading miao2go.GlucoseReading
+		if err := json.Unmarshal(line, &reading); err != nil {
+			return err
+		}
+		if !ser.keys[reading.Key()] {
+			ser.keys[reading.Key()] = true
+			ser.readings = append(ser.readings, reading)
+		}
+		return nil
+	})
+	ser.sort()
+	return err
+}
+
+// scan calls each for every line of an NDJSON file.  A line that won't
+// parse (say, half written when we were killed) is logged and skipped
+func scan(path string, each func([]byte) error) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := each(scanner.Bytes()); err != nil {
+			log.Printf("%v line %v: skipping: %v", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (ser *series) sort() {
+	sort.SliceStable(ser.readings, func(i, j int) bool {
+		return ser.readings[i].Time.Before(ser.readings[j].Time)
+	})
+}
+
+// open gets the series for a serial ready for writing
+func (st *Store) open(serial string) (*series, error) {
+	ser, ok := st.series[serial]
+	if !ok {
+		ser = &series{keys: make(map[string]bool)}
+		st.series[serial] = ser
+	}
+	if ser.readingsOut != nil {
+		return ser, nil
+	}
+	dir := filepath.Join(st.dir, serial)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var err error
+	ser.readingsOut, err = os.OpenFile(filepath.Join(dir, readingsFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	ser.packetsOut, err = os.OpenFile(filepath.Join(dir, packetsFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		ser.readingsOut.Close()
+		ser.readingsOut = nil
+		return nil, err
+	}
+	return ser, nil
+}
+
+// appendLines writes values to a file as NDJSON in one go, and syncs it
+func appendLines(file *os.File, values ...interface{}) error {
+	var buf []byte
+	for _, value := range values {
+		line, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	if _, err := file.Write(buf); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// Put stores a packet and whichever of its readings aren't already stored,
+// returning how many readings were new.  Packets without a sensor have
+// nothing to key them by and are ignored.  A nil store stores nothing, so
+// collectors can call it whether or not they were given one
+func (st *Store) Put(pkt miao2go.MiaoMiaoPacket) (int, error) {
+	if st == nil || pkt.LibrePacket == nil || len(pkt.LibrePacket.SerialNumber) == 0 {
+		return 0, nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ser, err := st.open(pkt.LibrePacket.SerialNumber)
+	if err != nil {
+		return 0, err
+	}
+	stored := StoredPacket{pkt.StartTime, pkt.EndTime, pkt.Transmitter, pkt.Data[:]}
+	if err = appendLines(ser.packetsOut, stored); err != nil {
+		return 0, err
+	}
+	var fresh []interface{}
+	for _, reading := range pkt.Readings() {
+		if reading.Raw == 0 || ser.keys[reading.Key()] {
+			continue
+		}
+		fresh = append(fresh, reading)
+	}
+	if len(fresh) == 0 {
+		return 0, nil
+	}
+	if err = appendLines(ser.readingsOut, fresh...); err != nil {
+		return 0, err
+	}
+	for _, value := range fresh {
+		reading := value.(miao2go.GlucoseReading)
+		ser.keys[reading.Key()] = true
+		ser.readings = append(ser.readings, reading)
+	}
+	ser.sort()
+	return len(fresh), nil
+}
+
+// Serials lists the sensors the store knows about
+func (st *Store) Serials() []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	serials := make([]string, 0, len(st.series))
+	for serial := range st.series {
+		serials = append(serials, serial)
+	}
+	sort.Strings(serials)
+	return serials
+}
+
+// within is whether a time is in a window; zero ends are unbounded
+func within(when, from, to time.Time) bool {
+	return (from.IsZero() || !when.Before(from)) && (to.IsZero() || !when.After(to))
+}
+
+// Range returns the readings of a sensor (or every sensor, if serial is
+// empty) between from and to, oldest first.  Zero times are unbounded
+func (st *Store) Range(serial string, from, to time.Time) []miao2go.GlucoseReading {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var readings []miao2go.GlucoseReading
+	for name, ser := range st.series {
+		if len(serial) > 0 && name != serial {
+			continue
+		}
+		start := 0
+		if !from.IsZero() {
+			start = sort.Search(len(ser.readings), func(i int) bool {
+				return !ser.readings[i].Time.Before(from)
+			})
+		}
+		for _, reading := range ser.readings[start:] {
+			if !to.IsZero() && reading.Time.After(to) {
+				break
+			}
+			readings = append(readings, reading)
+		}
+	}
+	sort.SliceStable(readings, func(i, j int) bool {
+		return readings[i].Time.Before(readings[j].Time)
+	})
+	return readings
+}
+
+// Latest is the most recent reading of a sensor, or of any sensor if
+// serial is empty
+func (st *Store) Latest(serial string) (miao2go.GlucoseReading, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var latest miao2go.GlucoseReading
+	found := false
+	for name, ser := range st.series {
+		if (len(serial) > 0 && name != serial) || len(ser.readings) == 0 {
+			continue
+		}
+		last := ser.readings[len(ser.readings)-1]
+		if !found || last.Time.After(latest.Time) {
+			latest = last
+			found = true
+		}
+	}
+	return latest, found
+}
+
+// Packets reads back the packets of a sensor (or every sensor) captured
+// between from and to, oldest first
+func (st *Store) Packets(serial string, from, to time.Time) ([]miao2go.MiaoMiaoPacket, error) {
+	var packets []miao2go.MiaoMiaoPacket
+	for _, name := range st.Serials() {
+		if len(serial) > 0 && name != serial {
+			continue
+		}
+		err := scan(filepath.Join(st.dir, name, packetsFile), func(line []byte) error {
+			var stored StoredPacket
+			if err := json.Unmarshal(line, &stored); err != nil {
+				return err
+			}
+			if !within(stored.End, from, to) {
+				return nil
+			}
+			pkt, err := stored.Packet()
+			if err != nil {
+				return err
+			}
+			packets = append(packets, pkt)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(packets, func(i, j int) bool {
+		return packets[i].EndTime.Before(packets[j].EndTime)
+	})
+	return packets, nil
+}
+
+// Close closes the store's files
+func (st *Store) Close() error {
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var firstErr error
+	for _, ser := range st.series {
+		for _, file := range []*os.File{ser.readingsOut, ser.packetsOut} {
+			if file == nil {
+				continue
+			}
+			if err := file.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		ser.readingsOut, ser.packetsOut = nil, nil
+	}
+	return firstErr
+}
+
+// WriteReading stores a packet, as a Sink
+func (st *Store) WriteReading(pkt miao2go.MiaoMiaoPacket) error {
+	_, err := st.Put(pkt)
+	return err
+}
+
+// WriteEvent does nothing; the store only keeps readings
+func (st *Store) WriteEvent(event miao2go.Event) error {
+	return nil
+}
+
+// Flush does nothing; every Put is synced as it's written
+func (st *Store) Flush() error {
+	return nil
+}
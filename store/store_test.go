@@ -0,0 +1,149 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/thecubic/miao2go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// start is when the first test packet was captured
+var start = time.Date(2018, 9, 25, 10, 0, 0, 0, time.UTC)
+
+// frame is a packet from sensor id whose only reading is its latest trend
+// entry.  The sensor's age is left at zero, as it's in the history area,
+// so readings are keyed by the minute
+func frame(id byte, raw uint16, end time.Time) miao2go.MiaoMiaoPacket {
+	var pkt miao2go.MiaoMiaoPacket
+	pkt.Data[0], pkt.Data[miao2go.MiaoFrameLength-1] = byte(miao2go.MPLibre), 0x29
+	pkt.Data[10] = id
+	fram := pkt.Data[18:]
+	// the newest trend entry is the one before the index
+	fram[26] = 1
+	binary.LittleEndian.PutUint16(fram[46:], raw)
+	pkt.StartTime, pkt.EndTime = end.Add(-time.Second), end
+	return pkt.Redecode()
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a, b miao2go.MiaoMiaoPacket
+	for idx := 0; idx < 5; idx++ {
+		a = frame(0x10, uint16(1000+idx), start.Add(time.Duration(idx)*time.Minute))
+		if fresh, err := st.Put(a); err != nil || fresh != 1 {
+			t.Fatalf("put %v: %v fresh, %v", idx, fresh, err)
+		}
+	}
+	for idx := 0; idx < 3; idx++ {
+		b = frame(0x20, uint16(2000+idx), start.Add(time.Duration(idx)*time.Minute+30*time.Second))
+		if _, err := st.Put(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the same reading again, a few seconds later
+	if fresh, err := st.Put(frame(0x20, 2002, start.Add(2*time.Minute+35*time.Second))); err != nil || fresh != 0 {
+		t.Errorf("put a stored reading again: %v fresh, %v", fresh, err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a reading written twice, and a line torn by a crash
+	readings := filepath.Join(dir, a.SerialNumber, readingsFile)
+	lines, err := ioutil.ReadFile(readings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := lines[:bytes.IndexByte(lines, '\n')+1]
+	file, err := os.OpenFile(readings, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(first)
+	file.Write([]byte(`{"serial":"` + a.SerialNumber + `","kind":"tre`))
+	file.Close()
+	packets := filepath.Join(dir, a.SerialNumber, packetsFile)
+	file, err = os.OpenFile(packets, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(`{"start":"2018-09-25T10:0`))
+	file.Close()
+
+	st, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if serials := st.Serials(); len(serials) != 2 || serials[0] == serials[1] {
+		t.Fatalf("got serials %v, want two", serials)
+	}
+	if got := st.Range(a.SerialNumber, time.Time{}, time.Time{}); len(got) != 5 {
+		t.Errorf("got %v readings of %v, want 5", len(got), a.SerialNumber)
+	}
+
+	for _, test := range []struct {
+		name     string
+		serial   string
+		from, to time.Time
+		want     []int
+	}{
+		{"everything", "", time.Time{}, time.Time{}, []int{1000, 2000, 1001, 2001, 1002, 2002, 1003, 1004}},
+		{"both ends", "", start.Add(time.Minute), start.Add(2 * time.Minute), []int{1001, 2001, 1002}},
+		{"from between readings", "", start.Add(90 * time.Second), time.Time{}, []int{2001, 1002, 2002, 1003, 1004}},
+		{"to only", "", time.Time{}, start.Add(30 * time.Second), []int{1000, 2000}},
+		{"one sensor", b.SerialNumber, start.Add(time.Minute), time.Time{}, []int{2001, 2002}},
+		{"after everything", "", start.Add(time.Hour), time.Time{}, nil},
+	} {
+		var raws []int
+		for _, reading := range st.Range(test.serial, test.from, test.to) {
+			raws = append(raws, reading.Raw)
+		}
+		if len(raws) != len(test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, raws, test.want)
+			continue
+		}
+		for idx := range raws {
+			if raws[idx] != test.want[idx] {
+				t.Errorf("%v: got %v, want %v", test.name, raws, test.want)
+				break
+			}
+		}
+	}
+
+	if latest, ok := st.Latest(""); !ok || latest.Raw != 1004 {
+		t.Errorf("got latest %v, want a's last", latest)
+	}
+	if latest, ok := st.Latest(b.SerialNumber); !ok || latest.Raw != 2002 {
+		t.Errorf("got latest %v, want b's last", latest)
+	}
+	if _, ok := st.Latest("0NOSUCHSENSOR"); ok {
+		t.Error("got a latest reading for a sensor never seen")
+	}
+
+	stored, err := st.Packets(b.SerialNumber, start.Add(time.Minute), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 3 {
+		t.Fatalf("got %v packets, want 3", len(stored))
+	}
+	if latest, ok := stored[2].Latest(); !ok || latest.Raw != 2002 || stored[2].SerialNumber != b.SerialNumber || !stored[2].EndTime.Equal(start.Add(2*time.Minute+35*time.Second)) {
+		t.Errorf("got %v decoded from the last packet, want b's last reading", latest)
+	}
+	if all, err := st.Packets("", time.Time{}, time.Time{}); err != nil || len(all) != 9 {
+		t.Errorf("got %v packets (%v), want all 9 but the torn one", len(all), err)
+	}
+}
@@ -0,0 +1,108 @@
+package miao2go
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// framBlocks are the header, body and footer of the FRAM, each starting
+// with a CRC of the rest of it
+var framBlocks = [3][2]int{{0, 24}, {24, 320}, {320, 344}}
+
+// Plausible glucose, in mg/dL, and sensor age, in minutes, for a Libre;
+// anything outside is more likely a bad read than a reading
+const (
+	MinPlausibleGlucose = 10
+	MaxPlausibleGlucose = 600
+	MaxSensorMinutes    = 16 * 24 * 60
+)
+
+// crc16 is the Libre's CRC: CCITT, reflected, from 0xffff, with the result
+// bit reversed
+func crc16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for bit := 0; bit < 8; bit++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	var reversed uint16
+	for bit := 0; bit < 16; bit++ {
+		reversed = reversed<<1 | crc&1
+		crc >>= 1
+	}
+	return reversed
+}
+
+// CheckCRCs checks the CRC of each block of the FRAM
+func (lpkt *LibrePacket) CheckCRCs() error {
+	for idx, block := range framBlocks {
+		want := binary.LittleEndian.Uint16(lpkt.Data[block[0] : block[0]+2])
+		if got := crc16(lpkt.Data[block[0]+2 : block[1]]); got != want {
+			return fmt.Errorf("block %v CRC is %04x, should be %04x", idx, want, got)
+		}
+	}
+	return nil
+}
+
+// Problem is something wrong with a packet, by which check found it
+type Problem struct {
+	Check  string
+	Detail string
+}
+
+func (p Problem) String() string {
+	return p.Check + ": " + p.Detail
+}
+
+// Checks a packet can fail
+const (
+	CheckFraming   = "framing"
+	CheckCRC       = "crc"
+	CheckPlausible = "plausible"
+)
+
+// Problems is everything wrong with a packet: framing, FRAM CRCs, and
+// values no working sensor or miaomiao would send.  None means it's fine
+func (mmp MiaoMiaoPacket) Problems() []Problem {
+	var problems []Problem
+	add := func(check, format string, args ...interface{}) {
+		problems = append(problems, Problem{check, fmt.Sprintf(format, args...)})
+	}
+	if mmp.Data[0] != byte(MPLibre) {
+		add(CheckFraming, "starts %#02x, not %#02x", mmp.Data[0], byte(MPLibre))
+	}
+	if mmp.Data[MiaoFrameLength-1] != encapsulatedEnd {
+		add(CheckFraming, "ends %#02x, not %#02x", mmp.Data[MiaoFrameLength-1], encapsulatedEnd)
+	}
+	if int(mmp.PktLength) != MiaoFrameLength {
+		add(CheckFraming, "length says %v, not %v", mmp.PktLength, MiaoFrameLength)
+	}
+	if mmp.BatteryPercentage > 100 {
+		add(CheckPlausible, "battery at %v%%", mmp.BatteryPercentage)
+	}
+	if mmp.LibrePacket == nil {
+		add(CheckFraming, "no sensor data")
+		return problems
+	}
+	if err := mmp.LibrePacket.CheckCRCs(); err != nil {
+		add(CheckCRC, "%v", err)
+	}
+	if minutes := mmp.LibrePacket.SensorMinutes(); minutes > MaxSensorMinutes {
+		add(CheckPlausible, "sensor %v minutes old", minutes)
+	}
+	for _, reading := range mmp.LibrePacket.Readings() {
+		if reading.Raw == 0 {
+			continue
+		}
+		if reading.Glucose < MinPlausibleGlucose || reading.Glucose > MaxPlausibleGlucose {
+			add(CheckPlausible, "%v reading %v is %.0f mg/dL", reading.Kind, reading.Index, reading.Glucose)
+		}
+	}
+	return problems
+}
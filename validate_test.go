@@ -0,0 +1,84 @@
+package miao2go
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestCRC16(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		want uint16
+	}{
+		{"", 0xffff},
+		{"123456789", 0x89f6},
+		{"\x00\x00\x00\x00\x00\x00\x00\x00\x00", 0x1872},
+	} {
+		if got := crc16([]byte(tc.data)); got != tc.want {
+			t.Errorf("crc16(%q) = %04x, want %04x", tc.data, got, tc.want)
+		}
+	}
+}
+
+// sealed sets the CRCs of a FRAM dump's blocks
+func sealed(fram []byte) {
+	for _, block := range framBlocks {
+		binary.LittleEndian.PutUint16(fram[block[0]:], crc16(fram[block[0]+2:block[1]]))
+	}
+}
+
+// framed is a miaomiao frame around a FRAM dump with one trend reading,
+// changed by change before (and broken after) its CRCs are set
+func framed(change, broken func(frame, fram []byte)) MiaoMiaoPacket {
+	frame := make([]byte, MiaoFrameLength)
+	frame[0] = byte(MPLibre)
+	binary.BigEndian.PutUint16(frame[1:3], MiaoFrameLength)
+	frame[13] = 80
+	frame[MiaoFrameLength-1] = encapsulatedEnd
+	fram := frame[18 : MiaoFrameLength-1]
+	binary.LittleEndian.PutUint16(fram[sensorMinutesOffset:], 1000)
+	fram[26] = 1
+	binary.LittleEndian.PutUint16(fram[trendOffset:], 850)
+	if change != nil {
+		change(frame, fram)
+	}
+	sealed(fram)
+	if broken != nil {
+		broken(frame, fram)
+	}
+	pkt, err := DecodeFrame(frame, time.Now())
+	if err != nil {
+		panic(err)
+	}
+	return *pkt
+}
+
+func TestProblems(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		change, after func(frame, fram []byte)
+		want          string
+	}{
+		{"fine", nil, nil, ""},
+		{"corrupt body", nil, func(frame, fram []byte) { fram[100] ^= 0xff }, CheckCRC},
+		{"corrupt header", nil, func(frame, fram []byte) { fram[5] ^= 0x01 }, CheckCRC},
+		{"no end", nil, func(frame, fram []byte) { frame[MiaoFrameLength-1] = 0 }, CheckFraming},
+		{"short", nil, func(frame, fram []byte) { binary.BigEndian.PutUint16(frame[1:3], 300) }, CheckFraming},
+		{"battery", func(frame, fram []byte) { frame[13] = 150 }, nil, CheckPlausible},
+		{"glucose", func(frame, fram []byte) { binary.LittleEndian.PutUint16(fram[trendOffset:], 0x1fff) }, nil, CheckPlausible},
+		{"ancient", func(frame, fram []byte) {
+			binary.LittleEndian.PutUint16(fram[sensorMinutesOffset:], MaxSensorMinutes+1)
+		}, nil, CheckPlausible},
+	} {
+		problems := framed(tc.change, tc.after).Problems()
+		if (len(problems) == 0) != (len(tc.want) == 0) {
+			t.Errorf("%v: got %v, want %v problems", tc.name, problems, tc.want)
+		}
+		for _, problem := range problems {
+			if problem.Check != tc.want {
+				t.Errorf("%v: got %v, want only %v problems", tc.name, problem, tc.want)
+			}
+		}
+	}
+}